@@ -23,6 +23,14 @@ func (driver *Driver) FilenameExtension() string {
 
 func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
 	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	return
+}
+
+func (driver *Driver) Execute(f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
 	pipe <- f
 	return
 }
@@ -30,3 +38,23 @@ func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
 func (driver *Driver) Version(id string) (uint64, error) {
 	return uint64(0), nil
 }
+
+func (driver *Driver) SupportsTransactions() bool {
+	return false
+}
+
+func (driver *Driver) SupportsLocking() bool {
+	return false
+}
+
+func (driver *Driver) SupportsVersionListing() bool {
+	return false
+}
+
+func (driver *Driver) SupportsDirtyState() bool {
+	return false
+}
+
+func (driver *Driver) SupportsMultiStatement() bool {
+	return false
+}
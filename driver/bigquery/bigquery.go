@@ -0,0 +1,365 @@
+// Package bigquery implements the Driver interface for BigQuery, running
+// the statements of migration files one by one.
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/journal"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+)
+
+type Driver struct {
+	client *client
+
+	journal *journal.Journal
+
+	// the context migrations run with, see SetContext
+	ctx context.Context
+}
+
+// the version table, in the dataset of the url
+const tableName = "schema_migrations"
+
+// BigQuery Driver URL format:
+// bigquery://project/dataset[?credentials_file=/path/to/key.json]
+//
+// Requests are authenticated with application default credentials unless
+// credentials_file names a service account key or instance is an
+// *http.Client that authenticates requests itself.
+func (driver *Driver) Initialize(instance interface{}, url string) error {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	project, dataset := u.Host, strings.Trim(u.Path, "/")
+	if project == "" || dataset == "" || strings.Contains(dataset, "/") {
+		return fmt.Errorf("Invalid BigQuery url, expected bigquery://project/dataset.")
+	}
+	c := &client{http: http.DefaultClient, endpoint: defaultEndpoint, project: project, dataset: dataset}
+
+	switch instance := instance.(type) {
+	case nil:
+		if path := u.Query().Get("credentials_file"); path != "" {
+			c.tokens, err = fileTokenSource(context.Background(), path)
+		} else {
+			c.tokens, err = defaultTokenSource(context.Background())
+		}
+		if err != nil {
+			return err
+		}
+	case *http.Client:
+		c.http = instance
+	default:
+		return fmt.Errorf("Expected instance of *http.Client, got %#v", instance)
+	}
+
+	driver.client = c
+	if err := driver.ensureVersionTableExists(); err != nil {
+		if _, ok := err.(*apiError); !ok {
+			return migrationerror.Wrap(migrationerror.Connection, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (driver *Driver) Close() error {
+	return nil
+}
+
+func (driver *Driver) ensureVersionTableExists() error {
+	_, err := driver.client.query(driver.runContext(), `CREATE TABLE IF NOT EXISTS `+tableName+` (
+		id STRING NOT NULL,
+		version INT64 NOT NULL
+	)`)
+	return err
+}
+
+func (driver *Driver) FilenameExtension() string {
+	return "sql"
+}
+
+// Migrate runs the statements of the file one by one and records the
+// version afterwards. BigQuery has no transactions spanning DDL, so the
+// statements that succeeded before a failing one stay applied.
+func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	start := time.Now()
+	if err := driver.execute(f); err != nil {
+		pipe <- migrationError(f, err)
+		return
+	}
+	if err := driver.recordVersion(id, f.Version, f.Direction); err != nil {
+		pipe <- err
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// Execute runs the statements of the file without recording its version.
+func (driver *Driver) Execute(f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	start := time.Now()
+	if err := driver.execute(f); err != nil {
+		pipe <- migrationError(f, err)
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// execute runs the statements of a migration file one by one.
+func (driver *Driver) execute(f file.File) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	timeout, err := f.Timeout()
+	if err != nil {
+		return err
+	}
+	ctx := driver.runContext()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for _, stmt := range statements(f.Content) {
+		if err := driver.journal.Record(f, stmt); err != nil {
+			return err
+		}
+		if _, err := driver.client.query(ctx, stmt); err != nil {
+			if cerr := migrationerror.Cancelled(driver.runContext(), f); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func migrationError(f file.File, err error) error {
+	switch err := err.(type) {
+	case *migrationerror.Error:
+		return err
+	case *apiError:
+		return migrationerror.New(f, err.reason(), err)
+	}
+	return migrationerror.New(f, "", err)
+}
+
+// recordVersion inserts (up) or deletes (down) a version in the
+// version table.
+func (driver *Driver) recordVersion(id string, version uint64, d direction.Direction) error {
+	params := []param{
+		{name: "id", typ: "STRING", value: id},
+		{name: "version", typ: "INT64", value: strconv.FormatUint(version, 10)},
+	}
+	var q string
+	switch d {
+	case direction.Up:
+		q = `INSERT INTO ` + tableName + ` (id, version) VALUES (@id, @version)`
+	case direction.Down:
+		q = `DELETE FROM ` + tableName + ` WHERE id = @id AND version = @version`
+	default:
+		return errors.New("Unsupported direction.Direction Type")
+	}
+	_, err := driver.client.query(driver.runContext(), q, params...)
+	return err
+}
+
+// SetContext makes the statements of all following migrations run with
+// ctx. Once it is done, the running query job is cancelled; statements
+// that ran already aren't rolled back.
+func (driver *Driver) SetContext(ctx context.Context) {
+	driver.ctx = ctx
+}
+
+// runContext returns the context set by SetContext, context.Background()
+// if unset
+func (driver *Driver) runContext() context.Context {
+	if driver.ctx == nil {
+		return context.Background()
+	}
+	return driver.ctx
+}
+
+// SetJournal records the statements of all following migrations in j.
+// Updates of the version table are not recorded.
+func (driver *Driver) SetJournal(j *journal.Journal) {
+	driver.journal = j
+}
+
+func (driver *Driver) Version(id string) (uint64, error) {
+	rows, err := driver.client.query(driver.runContext(), `SELECT MAX(version) FROM `+tableName+` WHERE id = @id`,
+		param{name: "id", typ: "STRING", value: id})
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 || rows[0][0] == nil {
+		return 0, nil
+	}
+	return parseVersion(rows[0][0])
+}
+
+// ListVersions returns the applied versions of id in ascending order.
+func (driver *Driver) ListVersions(id string) ([]uint64, error) {
+	rows, err := driver.client.query(driver.runContext(), `SELECT version FROM `+tableName+` WHERE id = @id ORDER BY version ASC`,
+		param{name: "id", typ: "STRING", value: id})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]uint64, 0, len(rows))
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		version, err := parseVersion(row[0])
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// parseVersion parses a version of a query result, where the API
+// returns INT64 values as strings.
+func parseVersion(v interface{}) (uint64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("Unexpected version %#v in %s.", v, tableName)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// CountStatements returns the number of statements in content.
+func (driver *Driver) CountStatements(content []byte) int {
+	return len(statements(content))
+}
+
+// statements splits content at semicolons into the statements it runs
+// one by one. Semicolons in strings, quoted identifiers, comments and
+// BEGIN ... END blocks, e.g. the body of a procedure, don't end a
+// statement. Statements consisting of comments only are dropped.
+func statements(content []byte) []string {
+	s := string(content)
+	stmts := make([]string, 0)
+	start := 0
+	hasCode := false
+	// the BEGIN and CASE blocks the current statement is in
+	depth := 0
+	prev := ""
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], `'''`) || strings.HasPrefix(s[i:], `"""`):
+			hasCode = true
+			i = skipQuoted(s, i+3, s[i:i+3])
+		case c == '\'' || c == '"' || c == '`':
+			hasCode = true
+			i = skipQuoted(s, i+1, string(c))
+		case strings.HasPrefix(s[i:], "--") || c == '#':
+			i = skipQuoted(s, i+1, "\n")
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipQuoted(s, i+2, "*/")
+		case c == ';':
+			if prev == "BEGIN" {
+				// BEGIN; starts a transaction rather than a block
+				depth--
+			}
+			prev = ""
+			if depth > 0 {
+				continue
+			}
+			if hasCode {
+				stmts = append(stmts, strings.TrimSpace(s[start:i]))
+			}
+			start, hasCode, depth = i+1, false, 0
+		case isWordChar(c):
+			j := i
+			for j < len(s) && isWordChar(s[j]) {
+				j++
+			}
+			word := strings.ToUpper(s[i:j])
+			switch {
+			case prev == "BEGIN" && word == "TRANSACTION":
+				depth--
+			case prev == "END" && (word == "IF" || word == "LOOP" || word == "WHILE" || word == "REPEAT" || word == "FOR"):
+				// ends a control statement, not a block
+				depth++
+			case prev == "END" && word == "CASE":
+				// END CASE closes the CASE statement once
+			case word == "BEGIN" || word == "CASE":
+				depth++
+			case word == "END":
+				depth--
+			}
+			prev = word
+			hasCode = true
+			i = j - 1
+		case !unicode.IsSpace(rune(c)):
+			prev = ""
+			hasCode = true
+		}
+	}
+	if hasCode {
+		stmts = append(stmts, strings.TrimSpace(s[start:]))
+	}
+	return stmts
+}
+
+// skipQuoted returns the index of the last byte of the first end in s at
+// or after i that isn't escaped by a backslash, or the end of s if there
+// is none.
+func skipQuoted(s string, i int, end string) int {
+	for ; i < len(s); i++ {
+		if s[i] == '\\' && end != "\n" && end != "*/" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], end) {
+			return i + len(end) - 1
+		}
+	}
+	return len(s)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func (driver *Driver) SupportsTransactions() bool {
+	return false
+}
+
+func (driver *Driver) SupportsLocking() bool {
+	return false
+}
+
+func (driver *Driver) SupportsVersionListing() bool {
+	return true
+}
+
+func (driver *Driver) SupportsDirtyState() bool {
+	return false
+}
+
+func (driver *Driver) SupportsMultiStatement() bool {
+	return true
+}
@@ -0,0 +1,294 @@
+package bigquery
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+func TestStatements(t *testing.T) {
+	var tests = []struct {
+		content     string
+		expectStmts []string
+	}{
+		{"CREATE TABLE a (id INT64);\nCREATE TABLE b (id INT64)", []string{"CREATE TABLE a (id INT64)", "CREATE TABLE b (id INT64)"}},
+		{"ALTER TABLE a SET OPTIONS (description = 'a; b'); -- done;\n", []string{"ALTER TABLE a SET OPTIONS (description = 'a; b')"}},
+		{`SELECT 'it\'s; here', "x;y", ` + "`a;b`" + `;`, []string{`SELECT 'it\'s; here', "x;y", ` + "`a;b`"}},
+		{"CREATE FUNCTION f() RETURNS STRING LANGUAGE js AS '''return 'a;b';''';\nSELECT 1", []string{"CREATE FUNCTION f() RETURNS STRING LANGUAGE js AS '''return 'a;b';'''", "SELECT 1"}},
+		{"# comment;\n/* a; b */ SELECT 1;", []string{"# comment;\n/* a; b */ SELECT 1"}},
+		{"CREATE PROCEDURE p() BEGIN\n  IF TRUE THEN SELECT 1; END IF;\n  SELECT CASE WHEN x THEN 1 END;\nEND;\nSELECT 2;",
+			[]string{"CREATE PROCEDURE p() BEGIN\n  IF TRUE THEN SELECT 1; END IF;\n  SELECT CASE WHEN x THEN 1 END;\nEND", "SELECT 2"}},
+		{"BEGIN TRANSACTION; SELECT 1; COMMIT TRANSACTION;", []string{"BEGIN TRANSACTION", "SELECT 1", "COMMIT TRANSACTION"}},
+		{"BEGIN; SELECT 1; COMMIT;", []string{"BEGIN", "SELECT 1", "COMMIT"}},
+		{"-- only a comment\n;;", []string{}},
+	}
+
+	for _, test := range tests {
+		stmts := statements([]byte(test.content))
+		if !reflect.DeepEqual(stmts, test.expectStmts) {
+			t.Errorf("Expected %q for %q, got %q", test.expectStmts, test.content, stmts)
+		}
+	}
+}
+
+// fakeBigQuery answers queries of the driver, keeping versions in memory.
+// Queries containing "fails" fail, queries containing "slow" aren't
+// complete at first.
+type fakeBigQuery struct {
+	mu       sync.Mutex
+	versions map[string]bool
+	queries  []string
+	polls    int
+}
+
+func (s *fakeBigQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/bigquery/v2/projects/my-project/queries/") {
+		s.polls += 1
+		json.NewEncoder(w).Encode(map[string]interface{}{"jobComplete": true})
+		return
+	}
+	if r.Method != "POST" || r.URL.Path != "/bigquery/v2/projects/my-project/queries" {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		Query           string
+		DefaultDataset  map[string]string
+		QueryParameters []struct {
+			Name           string
+			ParameterValue map[string]string
+		}
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.DefaultDataset["datasetId"] != "my_dataset" {
+		http.Error(w, "unexpected dataset", http.StatusBadRequest)
+		return
+	}
+	params := make(map[string]string)
+	for _, p := range req.QueryParameters {
+		params[p.Name] = p.ParameterValue["value"]
+	}
+	key := params["id"] + ":" + params["version"]
+	s.queries = append(s.queries, req.Query)
+
+	var resp = map[string]interface{}{"jobComplete": true}
+	switch {
+	case strings.Contains(req.Query, "fails"):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":400,"message":"Syntax error: Unexpected identifier at [1:1]","errors":[{"reason":"invalidQuery"}]}}`))
+		return
+	case strings.Contains(req.Query, "slow"):
+		resp = map[string]interface{}{"jobComplete": false, "jobReference": map[string]string{"projectId": "my-project", "jobId": "job1"}}
+	case strings.HasPrefix(req.Query, "INSERT"):
+		s.versions[key] = true
+	case strings.HasPrefix(req.Query, "DELETE"):
+		delete(s.versions, key)
+	case strings.HasPrefix(req.Query, "SELECT"):
+		versions := make([]string, 0)
+		for k := range s.versions {
+			if strings.HasPrefix(k, params["id"]+":") {
+				versions = append(versions, strings.TrimPrefix(k, params["id"]+":"))
+			}
+		}
+		sort.Strings(versions)
+		rows := make([]interface{}, 0)
+		if strings.Contains(req.Query, "MAX") {
+			var max interface{}
+			if len(versions) > 0 {
+				max = versions[len(versions)-1]
+			}
+			rows = append(rows, map[string]interface{}{"f": []interface{}{map[string]interface{}{"v": max}}})
+		} else {
+			for _, v := range versions {
+				rows = append(rows, map[string]interface{}{"f": []interface{}{map[string]interface{}{"v": v}}})
+			}
+		}
+		resp["rows"] = rows
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// redirect sends all requests to the server at url.
+type redirect struct {
+	url *neturl.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = r.url.Scheme, r.url.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMigrate(t *testing.T) {
+	fake := &fakeBigQuery{versions: make(map[string]bool)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	serverUrl, _ := neturl.Parse(server.URL)
+
+	d := &Driver{}
+	if err := d.Initialize(&http.Client{Transport: redirect{serverUrl}}, "bigquery://my-project/my_dataset"); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if !strings.HasPrefix(fake.queries[0], "CREATE TABLE IF NOT EXISTS schema_migrations") {
+		t.Errorf("Expected the version table to be created, got %q", fake.queries)
+	}
+
+	files := []file.File{
+		{
+			Path:      "/foobar",
+			FileName:  "001_foobar.up.sql",
+			Version:   1,
+			Name:      "foobar",
+			Direction: direction.Up,
+			Content: []byte(`
+				CREATE TABLE events (id STRING);
+				CREATE TABLE slow_events (id STRING);
+			`),
+		},
+		{
+			Path:      "/foobar",
+			FileName:  "002_foobar.up.sql",
+			Version:   2,
+			Name:      "foobar",
+			Direction: direction.Up,
+			Content:   []byte(`CREATE TABLE fails (id STRING);`),
+		},
+	}
+
+	pipe := pipep.New()
+	go d.Migrate("", files[0], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if fake.polls != 1 {
+		t.Errorf("Expected the incomplete query to be polled once, got %v", fake.polls)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate("", files[1], pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	merr, ok := errs[0].(*migrationerror.Error)
+	if !ok || merr.Code != "invalidQuery" || merr.Err.Error() != "Syntax error: Unexpected identifier at [1:1]" {
+		t.Errorf("Expected a migration error with the reason as code, got %#v", errs[0])
+	}
+
+	version, err := d.Version("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("Expected version 1, got %v", version)
+	}
+	if version, err := d.Version("tenant"); err != nil || version != 0 {
+		t.Errorf("Expected version 0 of another id, got %v, %v", version, err)
+	}
+	versions, err := d.ListVersions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []uint64{1}) {
+		t.Errorf("Expected versions [1], got %v", versions)
+	}
+
+	if err := d.Initialize(nil, "bigquery://my-project"); err == nil {
+		t.Error("Expected error for a url without dataset")
+	}
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches += 1
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature) != nil {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if !strings.Contains(string(claims), `"iss":"migrate@my-project.iam.gserviceaccount.com"`) {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token":"token1","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	keyFile := path.Join(tmpdir, "key.json")
+	b, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "migrate@my-project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"token_uri":    server.URL,
+	})
+	ioutil.WriteFile(keyFile, b, 0600)
+
+	tokens, err := fileTokenSource(context.Background(), keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		token, err := tokens.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "token1" {
+			t.Errorf("Expected token1, got %v", token.AccessToken)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the token to be fetched once, got %v", fetches)
+	}
+
+	ioutil.WriteFile(keyFile, []byte(`{"type":"unknown"}`), 0600)
+	if _, err := fileTokenSource(context.Background(), keyFile); err == nil {
+		t.Error("Expected error for an unsupported credentials type")
+	}
+}
@@ -0,0 +1,188 @@
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const defaultEndpoint = "https://bigquery.googleapis.com/bigquery/v2"
+
+// how long a single request waits for a query to complete before
+// the job is polled again
+const queryWait = 10 * time.Second
+
+// client runs queries with the jobs.query REST method of the BigQuery
+// API, which is all the driver needs.
+type client struct {
+	http     *http.Client
+	endpoint string
+	tokens   oauth2.TokenSource
+
+	project, dataset string
+}
+
+// param is a named query parameter, referenced as @name.
+type param struct {
+	name, typ, value string
+}
+
+// apiError is an error response of the API, e.g. for an invalid query.
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Errors  []struct {
+		Reason string `json:"reason"`
+	} `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// reason returns the reason of the error, e.g. invalidQuery.
+func (e *apiError) reason() string {
+	if len(e.Errors) == 0 {
+		return ""
+	}
+	return e.Errors[0].Reason
+}
+
+type jobReference struct {
+	ProjectId string `json:"projectId"`
+	JobId     string `json:"jobId"`
+	Location  string `json:"location"`
+}
+
+type queryResponse struct {
+	JobComplete  bool         `json:"jobComplete"`
+	JobReference jobReference `json:"jobReference"`
+	Rows         []struct {
+		F []struct {
+			V interface{} `json:"v"`
+		} `json:"f"`
+	} `json:"rows"`
+}
+
+// query runs q in the dataset and waits for it to complete. It returns
+// the rows of the result, which hold strings or nil (NULL) for the
+// scalar types the driver selects.
+func (c *client) query(ctx context.Context, q string, params ...param) ([][]interface{}, error) {
+	type parameter struct {
+		Name           string            `json:"name"`
+		ParameterType  map[string]string `json:"parameterType"`
+		ParameterValue map[string]string `json:"parameterValue"`
+	}
+	req := struct {
+		Query           string            `json:"query"`
+		UseLegacySql    bool              `json:"useLegacySql"`
+		DefaultDataset  map[string]string `json:"defaultDataset"`
+		ParameterMode   string            `json:"parameterMode,omitempty"`
+		QueryParameters []parameter       `json:"queryParameters,omitempty"`
+		TimeoutMs       int64             `json:"timeoutMs"`
+	}{
+		Query:          q,
+		DefaultDataset: map[string]string{"projectId": c.project, "datasetId": c.dataset},
+		TimeoutMs:      int64(queryWait / time.Millisecond),
+	}
+	for _, p := range params {
+		req.ParameterMode = "NAMED"
+		req.QueryParameters = append(req.QueryParameters, parameter{
+			Name:           p.name,
+			ParameterType:  map[string]string{"type": p.typ},
+			ParameterValue: map[string]string{"value": p.value},
+		})
+	}
+
+	var resp queryResponse
+	if err := c.do(ctx, "POST", "/projects/"+neturl.PathEscape(c.project)+"/queries", req, &resp); err != nil {
+		return nil, err
+	}
+	for !resp.JobComplete {
+		job := resp.JobReference
+		path := "/projects/" + neturl.PathEscape(job.ProjectId) + "/queries/" + neturl.PathEscape(job.JobId) +
+			"?timeoutMs=" + fmt.Sprint(int64(queryWait/time.Millisecond)) + "&location=" + neturl.QueryEscape(job.Location)
+		if err := c.do(ctx, "GET", path, nil, &resp); err != nil {
+			if ctx.Err() != nil {
+				// the job keeps running unless it is cancelled explicitly
+				c.cancel(job)
+			}
+			return nil, err
+		}
+	}
+
+	rows := make([][]interface{}, len(resp.Rows))
+	for i, row := range resp.Rows {
+		for _, field := range row.F {
+			rows[i] = append(rows[i], field.V)
+		}
+	}
+	return rows, nil
+}
+
+// cancel requests the cancellation of job, ignoring failures.
+func (c *client) cancel(job jobReference) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryWait)
+	defer cancel()
+	path := "/projects/" + neturl.PathEscape(job.ProjectId) + "/jobs/" + neturl.PathEscape(job.JobId) +
+		"/cancel?location=" + neturl.QueryEscape(job.Location)
+	c.do(ctx, "POST", path, nil, nil)
+}
+
+// do sends a request with body encoded as JSON, if not nil, and decodes
+// the response into v.
+func (c *client) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.tokens != nil {
+		token, err := c.tokens.Token()
+		if err != nil {
+			return err
+		}
+		token.SetAuthHeader(req)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error *apiError `json:"error"`
+		}
+		if err := json.Unmarshal(b, &e); err != nil || e.Error == nil {
+			return fmt.Errorf("Unexpected BigQuery response %s: %s", resp.Status, bytes.TrimSpace(b))
+		}
+		return e.Error
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,40 @@
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const scope = "https://www.googleapis.com/auth/bigquery"
+
+// defaultTokenSource finds application default credentials: the key file
+// named by GOOGLE_APPLICATION_CREDENTIALS, the credentials of gcloud or
+// else the service account of the GCE metadata server. Tokens are reused
+// until shortly before they expire.
+func defaultTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	creds, err := google.FindDefaultCredentials(ctx, scope)
+	if err != nil {
+		return nil, fmt.Errorf("No application default credentials found, set GOOGLE_APPLICATION_CREDENTIALS "+
+			"or credentials_file in the url: %v", err)
+	}
+	return creds.TokenSource, nil
+}
+
+// fileTokenSource returns a token source for the credentials in the
+// JSON file at path, e.g. a service account key or the credentials of
+// workload identity federation.
+func fileTokenSource(ctx context.Context, path string) (oauth2.TokenSource, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(ctx, b, scope)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %v", path, err)
+	}
+	return creds.TokenSource, nil
+}
@@ -2,36 +2,78 @@
 package cassandra
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/PlanitarInc/migrate/file"
 	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/journal"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
 	"github.com/gocql/gocql"
 )
 
 type Driver struct {
 	session     *gocql.Session
 	ownsSession bool
+
+	// how often and how long to wait re-reading the version right
+	// after the version table was seeded
+	versionRetries      int
+	versionRetryBackoff time.Duration
+
+	// whether to seed the version counter if it doesn't exist
+	seed bool
+
+	// the table holding migration locks and how long they last
+	lockTable string
+	lockTTL   time.Duration
+
+	// the lock taken by this driver, nil if none
+	lock *heldLock
+
+	// how long a single query may run
+	timeout time.Duration
+
+	// the version table, optionally qualified by a keyspace;
+	// the versions of ids are kept in the same name suffixed by _by_id
+	table string
+
+	journal *journal.Journal
+
+	// the context migrations run with, see SetContext
+	ctx context.Context
 }
 
 const (
+	// the version of the empty id, in the only row of the table
 	tableName  = "schema_migrations"
 	versionRow = 1
+
+	// the versions of all other ids; counter tables can't get another
+	// primary key column, so they live in a table of their own
+	idTableName   = "schema_migrations_by_id"
+	idTableSuffix = "_by_id"
+)
+
+const (
+	defaultVersionRetries      = 5
+	defaultVersionRetryBackoff = 100 * time.Millisecond
 )
 
+const defaultTimeout = time.Minute
+
 type counterStmt bool
 
-func (c counterStmt) String() string {
-	sign := ""
+func (c counterStmt) sign() string {
 	if bool(c) {
-		sign = "+"
-	} else {
-		sign = "-"
+		return "+"
 	}
-	return "UPDATE " + tableName + " SET version = version " + sign + " 1 where versionRow = ?"
+	return "-"
 }
 
 const (
@@ -39,12 +81,39 @@ const (
 	down counterStmt = false
 )
 
+// counterQuery returns the statement c updating the counter of id.
+func (driver *Driver) counterQuery(c counterStmt, id string) (string, interface{}) {
+	if id == "" {
+		return "UPDATE " + driver.versionTable() + " SET version = version " + c.sign() + " 1 where versionRow = ?", versionRow
+	}
+	return "UPDATE " + driver.idVersionTable() + " SET version = version " + c.sign() + " 1 WHERE id = ?", id
+}
+
+// versionTable returns the table holding the version of the empty id
+func (driver *Driver) versionTable() string {
+	if driver.table == "" {
+		return tableName
+	}
+	return driver.table
+}
+
+// idVersionTable returns the table holding the versions of all other ids
+func (driver *Driver) idVersionTable() string {
+	if driver.table == "" {
+		return idTableName
+	}
+	return driver.table + idTableSuffix
+}
+
 // Cassandra Driver URL format:
-// cassandra://host:port/keyspace
+// cassandra://host:port/keyspace?seed=true&version_retries=5&version_retry_backoff=100ms&lock_table=schema_migrations_lock&lock_ttl=15m&version_table=schema_migrations&timeout=1m
 //
 // Example:
 // cassandra://localhost/SpaceOfKeys
 func (driver *Driver) Initialize(instance interface{}, rawurl string) error {
+	if err := driver.setOptions(rawurl); err != nil {
+		return err
+	}
 	if err := driver.setSession(instance, rawurl); err != nil {
 		return err
 	}
@@ -61,6 +130,60 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
+func (driver *Driver) setOptions(rawurl string) error {
+	driver.versionRetries = defaultVersionRetries
+	driver.versionRetryBackoff = defaultVersionRetryBackoff
+	driver.seed = true
+	driver.lockTable = defaultLockTable
+	driver.lockTTL = defaultLockTTL
+	driver.table = tableName
+	driver.timeout = defaultTimeout
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if v := q.Get("seed"); v != "" {
+		if driver.seed, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("Invalid seed %q.", v)
+		}
+	}
+	if v := q.Get("version_retries"); v != "" {
+		if driver.versionRetries, err = strconv.Atoi(v); err != nil || driver.versionRetries < 0 {
+			return fmt.Errorf("Invalid version_retries %q.", v)
+		}
+	}
+	if v := q.Get("version_retry_backoff"); v != "" {
+		if driver.versionRetryBackoff, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("Invalid version_retry_backoff %q: %v", v, err)
+		}
+	}
+	if v := q.Get("lock_table"); v != "" {
+		if !lockTableRegex.MatchString(v) {
+			return fmt.Errorf("Invalid lock_table %q.", v)
+		}
+		driver.lockTable = v
+	}
+	if v := q.Get("version_table"); v != "" {
+		if !lockTableRegex.MatchString(v) {
+			return fmt.Errorf("Invalid version_table %q.", v)
+		}
+		driver.table = v
+	}
+	if v := q.Get("lock_ttl"); v != "" {
+		if driver.lockTTL, err = time.ParseDuration(v); err != nil || driver.lockTTL < time.Second {
+			return fmt.Errorf("Invalid lock_ttl %q, expected a duration of at least 1s.", v)
+		}
+	}
+	if v := q.Get("timeout"); v != "" {
+		if driver.timeout, err = time.ParseDuration(v); err != nil || driver.timeout <= 0 {
+			return fmt.Errorf("Invalid timeout %q, expected a positive duration.", v)
+		}
+	}
+	return nil
+}
+
 func (driver *Driver) setSession(instance interface{}, rawurl string) error {
 	if instance != nil {
 		session, ok := instance.(*gocql.Session)
@@ -80,7 +203,7 @@ func (driver *Driver) setSession(instance interface{}, rawurl string) error {
 	cluster := gocql.NewCluster(u.Host)
 	cluster.Keyspace = u.Path[1:len(u.Path)]
 	cluster.Consistency = gocql.All
-	cluster.Timeout = 1 * time.Minute
+	cluster.Timeout = driver.timeout
 
 	// Check if url user struct is null
 	if u.User != nil {
@@ -103,24 +226,58 @@ func (driver *Driver) setSession(instance interface{}, rawurl string) error {
 }
 
 func (driver *Driver) ensureVersionTableExists() error {
-	err := driver.session.Query("CREATE TABLE IF NOT EXISTS " + tableName + " (version counter, versionRow bigint primary key);").Exec()
-	if err != nil {
+	if err := driver.session.Query("CREATE TABLE IF NOT EXISTS " + driver.versionTable() + " (version counter, versionRow bigint primary key);").Exec(); err != nil {
 		return err
 	}
+	return driver.session.Query("CREATE TABLE IF NOT EXISTS " + driver.idVersionTable() + " (id text primary key, version counter);").Exec()
+}
 
-	_, err = driver.Version("")
-	if err != nil {
-		driver.session.Query(up.String(), versionRow).Exec()
+// ensureSeeded seeds the version counter unless it exists already.
+// The counter holds the version plus one, so seeding sets it to 1
+// (version 0). Operators who manage the counter themselves disable
+// seeding with ?seed=false; migrating without a counter is an error then.
+func (driver *Driver) ensureSeeded(id string) error {
+	_, err := driver.counter(id)
+	if err != gocql.ErrNotFound {
+		return err
+	}
+	if !driver.seed {
+		return fmt.Errorf("Version counter in %s does not exist and seeding is disabled.", driver.versionTable())
+	}
+	if err := driver.session.Query(driver.counterQuery(up, id)).Exec(); err != nil {
+		return err
 	}
+	return driver.waitForSeed(id)
+}
 
-	return nil
+// waitForSeed re-reads the version counter until the seed written by
+// ensureSeeded is visible. With eventual consistency an immediate
+// read may miss the write and report a wrong version.
+func (driver *Driver) waitForSeed(id string) error {
+	backoff := driver.versionRetryBackoff
+	var err error
+	for i := 0; ; i++ {
+		var counter int64
+		if counter, err = driver.counter(id); err == nil && counter >= 1 {
+			return nil
+		}
+		if i >= driver.versionRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err == nil {
+		err = fmt.Errorf("counter not seeded")
+	}
+	return fmt.Errorf("Unable to read version from %s after seeding it: %v", driver.versionTable(), err)
 }
 
 func (driver *Driver) FilenameExtension() string {
 	return "cql"
 }
 
-func (driver *Driver) version(d direction.Direction, invert bool) error {
+func (driver *Driver) version(id string, d direction.Direction, invert bool) error {
 	var stmt counterStmt
 	switch d {
 	case direction.Up:
@@ -131,49 +288,327 @@ func (driver *Driver) version(d direction.Direction, invert bool) error {
 	if invert {
 		stmt = !stmt
 	}
-	return driver.session.Query(stmt.String(), versionRow).Exec()
+	return driver.session.Query(driver.counterQuery(stmt, id)).Exec()
 }
 
 func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
-	// XXX id is not supported
+	f.ParseFileName(driver.FilenameExtension())
+	if err := driver.lockLost(f); err != nil {
+		pipe <- f
+		pipe <- err
+		close(pipe)
+		return
+	}
+	if err := driver.ensureSeeded(id); err != nil {
+		pipe <- f
+		pipe <- migrationerror.New(f, errorCode(err), err)
+		close(pipe)
+		return
+	}
 
 	var err error
 	defer func() {
 		if err != nil {
 			// Invert version direction if we couldn't apply the changes for some reason.
-			if err := driver.version(f.Direction, true); err != nil {
+			if err := driver.version(id, f.Direction, true); err != nil {
 				pipe <- err
 			}
-			pipe <- err
+			pipe <- migrationError(f, err)
 		}
 		close(pipe)
 	}()
 
 	pipe <- f
-	if err = driver.version(f.Direction, false); err != nil {
+	start := time.Now()
+	if err = driver.version(id, f.Direction, false); err != nil {
 		return
 	}
 
-	if err = f.ReadContent(); err != nil {
+	if err = driver.execute(f, pipe); err == nil {
+		pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+	}
+}
+
+// Execute runs the migration file without updating the version counter.
+func (driver *Driver) Execute(f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	if err := driver.lockLost(f); err != nil {
+		pipe <- err
 		return
 	}
+	start := time.Now()
+	if err := driver.execute(f, pipe); err != nil {
+		pipe <- migrationError(f, err)
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
 
-	for _, query := range strings.Split(string(f.Content), ";;") {
-		query = strings.TrimSpace(query)
-		if len(query) == 0 {
-			continue
+// queries splits the content of a migration file into its queries at
+// semicolons, except for those in string literals, quoted names, $$
+// blocks, comments and between BEGIN BATCH and APPLY BATCH, so that a
+// batch is sent as one query. Queries of comments only are skipped, so
+// the ;; separating queries in older files still works.
+func queries(content []byte) []string {
+	s := string(content)
+	queries := make([]string, 0)
+	start := 0
+	hasCode := false
+	// the first and the last two words of the current query
+	var first, prev, last string
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '"':
+			// escaped quotes are doubled, which just ends and starts again
+			hasCode = true
+			i = skipTo(s, i+1, string(c))
+		case strings.HasPrefix(s[i:], "$$"):
+			hasCode = true
+			i = skipTo(s, i+2, "$$")
+		case strings.HasPrefix(s[i:], "--") || strings.HasPrefix(s[i:], "//"):
+			i = skipTo(s, i+2, "\n")
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipTo(s, i+2, "*/")
+		case c == ';':
+			if first == "BEGIN" && (prev != "APPLY" || last != "BATCH") {
+				continue
+			}
+			if hasCode {
+				queries = append(queries, strings.TrimSpace(s[start:i]))
+			}
+			start, hasCode = i+1, false
+			first, prev, last = "", "", ""
+		case isWordChar(c):
+			j := i
+			for j < len(s) && isWordChar(s[j]) {
+				j++
+			}
+			word := strings.ToUpper(s[i:j])
+			if first == "" {
+				first = word
+			}
+			prev, last = last, word
+			hasCode = true
+			i = j - 1
+		case !unicode.IsSpace(rune(c)):
+			hasCode = true
 		}
+	}
+	if hasCode {
+		queries = append(queries, strings.TrimSpace(s[start:]))
+	}
+	return queries
+}
+
+// skipTo returns the index of the last byte of the first end in s at or
+// after i, or the end of s if there is none.
+func skipTo(s string, i int, end string) int {
+	n := strings.Index(s[i:], end)
+	if n < 0 {
+		return len(s)
+	}
+	return i + n + len(end) - 1
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// CountStatements returns the number of queries in content.
+func (driver *Driver) CountStatements(content []byte) int {
+	return len(queries(content))
+}
+
+// execute runs the queries of a migration file one by one, sending
+// the progress of every query down the pipe.
+func (driver *Driver) execute(f file.File, pipe chan interface{}) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	timeout, err := f.Timeout()
+	if err != nil {
+		return err
+	}
+	ctx := driver.runContext()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-		if err = driver.session.Query(query).Exec(); err != nil {
-			return
+	qs := queries(f.Content)
+	for i, query := range qs {
+		if err := driver.journal.Record(f, query); err != nil {
+			return err
 		}
+		pipe <- file.StatementProgress{File: f, Current: i + 1, Total: len(qs)}
+		if err := driver.session.Query(query).WithContext(ctx).Exec(); err != nil {
+			if lerr := driver.lockLost(f); lerr != nil {
+				return lerr
+			}
+			if cerr := migrationerror.Cancelled(driver.runContext(), f); cerr != nil {
+				return cerr
+			}
+			return migrationerror.New(f, errorCode(err), fmt.Errorf("Query %d of %d failed: %w", i+1, len(qs), err))
+		}
+	}
+	return nil
+}
+
+// SetContext makes the queries of all following migrations run with
+// ctx. Once it is done, the running query is cancelled; queries that
+// ran already aren't rolled back.
+func (driver *Driver) SetContext(ctx context.Context) {
+	driver.ctx = ctx
+}
+
+// runContext returns the context set by SetContext, context.Background()
+// if unset. While the lock is held, it is cancelled once refreshing the
+// lock fails.
+func (driver *Driver) runContext() context.Context {
+	if driver.lock != nil {
+		return driver.lock.ctx
+	}
+	if driver.ctx == nil {
+		return context.Background()
 	}
+	return driver.ctx
+}
+
+// SetJournal records the queries of all following migrations in j.
+// Updates of the version counter are not recorded.
+func (driver *Driver) SetJournal(j *journal.Journal) {
+	driver.journal = j
+}
+
+// migrationError wraps an error of f in a migration error,
+// unless it is one already.
+func migrationError(f file.File, err error) error {
+	if merr, ok := err.(*migrationerror.Error); ok {
+		return merr
+	}
+	return migrationerror.New(f, errorCode(err), err)
+}
+
+// errorCode returns the cassandra error code of err, if any.
+func errorCode(err error) string {
+	if reqErr, ok := err.(gocql.RequestError); ok {
+		return strconv.Itoa(reqErr.Code())
+	}
+	return ""
 }
 
 func (driver *Driver) Version(id string) (uint64, error) {
-	// XXX id is not supported
+	counter, err := driver.counter(id)
+	if err == gocql.ErrNotFound {
+		// not seeded yet
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counterVersion(id, counter)
+}
+
+// counterVersion returns the version of a raw version counter of id,
+// failing if the counter is below 1, e.g. after a down migration ran on
+// a counter that wasn't seeded.
+func counterVersion(id string, counter int64) (uint64, error) {
+	if counter < 1 {
+		return 0, fmt.Errorf("Invalid version counter %d of id '%s', expected at least 1 (the version plus one). "+
+			"Fix it with 'migrate force <v>'.", counter, id)
+	}
+	return uint64(counter) - 1, nil
+}
+
+// ForceVersion sets the version counter of id to version. Counters can
+// only be incremented, so this adds the difference to the current
+// counter, which must not change meanwhile.
+func (driver *Driver) ForceVersion(id string, version uint64) error {
+	counter, err := driver.counter(id)
+	if err == gocql.ErrNotFound {
+		counter, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+	delta := int64(version) + 1 - counter
+	if delta == 0 {
+		return nil
+	}
+	if id == "" {
+		return driver.session.Query("UPDATE "+driver.versionTable()+" SET version = version + ? WHERE versionRow = ?", delta, versionRow).Exec()
+	}
+	return driver.session.Query("UPDATE "+driver.idVersionTable()+" SET version = version + ? WHERE id = ?", delta, id).Exec()
+}
+
+// Baseline sets the version counter of id to the last of versions,
+// as the counter only keeps track of the current version.
+func (driver *Driver) Baseline(id string, versions []uint64) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	return driver.ForceVersion(id, versions[len(versions)-1])
+}
+
+// counter reads the raw version counter of id, which is the version plus one.
+func (driver *Driver) counter(id string) (int64, error) {
+	var counter int64
+	var err error
+	if id == "" {
+		err = driver.session.Query("SELECT version FROM "+driver.versionTable()+" WHERE versionRow = ?", versionRow).Scan(&counter)
+	} else {
+		err = driver.session.Query("SELECT version FROM "+driver.idVersionTable()+" WHERE id = ?", id).Scan(&counter)
+	}
+	return counter, err
+}
+
+// IdVersions returns the current version of every id
+// that was migrated before.
+func (driver *Driver) IdVersions() (map[string]uint64, error) {
+	versions := make(map[string]uint64)
+	if counter, err := driver.counter(""); err == nil {
+		if versions[""], err = counterVersion("", counter); err != nil {
+			return nil, err
+		}
+	} else if err != gocql.ErrNotFound {
+		return nil, err
+	}
+
+	iter := driver.session.Query("SELECT id, version FROM " + driver.idVersionTable()).Iter()
+	var id string
+	var counter int64
+	for iter.Scan(&id, &counter) {
+		version, err := counterVersion(id, counter)
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+		versions[id] = version
+	}
+	return versions, iter.Close()
+}
+
+func (driver *Driver) SupportsTransactions() bool {
+	return false
+}
+
+func (driver *Driver) SupportsLocking() bool {
+	return true
+}
+
+func (driver *Driver) SupportsVersionListing() bool {
+	return false
+}
+
+func (driver *Driver) SupportsDirtyState() bool {
+	return false
+}
 
-	var version int64
-	err := driver.session.Query("SELECT version FROM "+tableName+" WHERE versionRow = ?", versionRow).Scan(&version)
-	return uint64(version) - 1, err
+func (driver *Driver) SupportsMultiStatement() bool {
+	return true
 }
@@ -1,12 +1,18 @@
 package cassandra
 
 import (
+	"context"
+	"errors"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/PlanitarInc/migrate/file"
 	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
 	pipep "github.com/PlanitarInc/migrate/pipe"
 	"github.com/gocql/gocql"
 )
@@ -38,6 +44,9 @@ func TestMigrate(t *testing.T) {
 	if err := session.Query(`DROP TABLE IF EXISTS ` + tableName).Exec(); err != nil {
 		t.Fatal(err)
 	}
+	if err := session.Query(`DROP TABLE IF EXISTS ` + idTableName).Exec(); err != nil {
+		t.Fatal(err)
+	}
 
 	d := &Driver{}
 	if err := d.Initialize(nil, driverUrl); err != nil {
@@ -86,14 +95,22 @@ func TestMigrate(t *testing.T) {
 
 	pipe := pipep.New()
 	go d.Migrate("test", files[0], pipe)
-	errs := pipep.ReadErrors(pipe)
-	if len(errs) > 0 {
-		t.Fatal(errs)
+	progress := make([]file.StatementProgress, 0)
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			t.Fatal(item)
+		case file.StatementProgress:
+			progress = append(progress, item)
+		}
+	}
+	if len(progress) != 2 || progress[1].Current != 2 || progress[1].Total != 2 {
+		t.Errorf("Expected progress of 2 queries, got %v", progress)
 	}
 
 	pipe = pipep.New()
 	go d.Migrate("test", files[1], pipe)
-	errs = pipep.ReadErrors(pipe)
+	errs := pipep.ReadErrors(pipe)
 	if len(errs) > 0 {
 		t.Fatal(errs)
 	}
@@ -103,6 +120,33 @@ func TestMigrate(t *testing.T) {
 	errs = pipep.ReadErrors(pipe)
 	if len(errs) == 0 {
 		t.Error("Expected test case to fail")
+	} else if !strings.HasPrefix(errs[len(errs)-1].Error(), "Query 1 of 1 failed: ") {
+		t.Errorf("Expected the failed query to be named, got %v", errs[len(errs)-1])
+	}
+
+	// the inserts of a batch are applied all or nothing
+	batch := file.File{
+		Path:      "/foobar",
+		FileName:  "003_foobar.up.sql",
+		Version:   3,
+		Name:      "foobar",
+		Direction: direction.Up,
+		Content: []byte(`
+                CREATE TABLE yolo (id varint primary key, msg text);
+                BEGIN BATCH
+                    INSERT INTO yolo (id, msg) VALUES (1, 'a;b');
+                    INSERT INTO yolo (id, msg) VALUES (2, 'c');
+                APPLY BATCH;
+            `),
+	}
+	pipe = pipep.New()
+	go d.Migrate("test", batch, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var count int
+	if err := session.Query(`SELECT COUNT(*) FROM yolo`).Scan(&count); err != nil || count != 2 {
+		t.Errorf("Expected 2 rows inserted by the batch, got %v, %v", count, err)
 	}
 
 	if err := d.Close(); err != nil {
@@ -110,3 +154,233 @@ func TestMigrate(t *testing.T) {
 	}
 
 }
+
+func TestQueries(t *testing.T) {
+	var tests = []struct {
+		content       string
+		expectQueries []string
+	}{
+		{"CREATE TABLE a (id int primary key);\nCREATE INDEX ON a (id);", []string{"CREATE TABLE a (id int primary key)", "CREATE INDEX ON a (id)"}},
+		{"DROP TABLE a;;\nDROP TABLE b;;\n", []string{"DROP TABLE a", "DROP TABLE b"}},
+		{"INSERT INTO a (id, msg) VALUES (1, 'a;b ''c;'' $$');", []string{"INSERT INTO a (id, msg) VALUES (1, 'a;b ''c;'' $$')"}},
+		{"-- drop a; and b\nDROP TABLE a; /* ; */ DROP TABLE b; // done;\n", []string{"-- drop a; and b\nDROP TABLE a", "/* ; */ DROP TABLE b"}},
+		{"CREATE FUNCTION f (i int) RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE java AS $$ return i; $$;", []string{"CREATE FUNCTION f (i int) RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE java AS $$ return i; $$"}},
+		{`
+			BEGIN BATCH USING TIMESTAMP 1
+				INSERT INTO a (id) VALUES (1);
+				INSERT INTO a (id) VALUES (2);
+			APPLY BATCH;
+			begin unlogged batch insert into a (id) values (3); apply batch;
+			SELECT * FROM "weird;name";`,
+			[]string{
+				"BEGIN BATCH USING TIMESTAMP 1\n\t\t\t\tINSERT INTO a (id) VALUES (1);\n\t\t\t\tINSERT INTO a (id) VALUES (2);\n\t\t\tAPPLY BATCH",
+				"begin unlogged batch insert into a (id) values (3); apply batch",
+				`SELECT * FROM "weird;name"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if queries := queries([]byte(test.content)); !reflect.DeepEqual(queries, test.expectQueries) {
+			t.Errorf("Expected %q, got %q", test.expectQueries, queries)
+		}
+	}
+}
+
+func TestMigrateIds(t *testing.T) {
+	driverUrl := "cassandra://localhost/migratetest"
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, table := range []string{tableName, idTableName} {
+		if err := d.session.Query(`TRUNCATE ` + table).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrate := func(id string, version uint64) {
+		pipe := pipep.New()
+		go d.Migrate(id, file.File{
+			FileName:  "00" + strconv.FormatUint(version, 10) + "_foo.up.cql",
+			Version:   version,
+			Direction: direction.Up,
+			Content:   []byte(`SELECT now() FROM system.local`),
+		}, pipe)
+		if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	migrate("app", 1)
+	migrate("app", 2)
+	migrate("analytics", 1)
+	migrate("", 1)
+
+	expected := map[string]uint64{"app": 2, "analytics": 1, "": 1}
+	for id, expectVersion := range expected {
+		if version, err := d.Version(id); err != nil || version != expectVersion {
+			t.Errorf("Expected version %v for id %q, got %v, %v", expectVersion, id, version, err)
+		}
+	}
+	versions, err := d.IdVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Expected versions %v, got %v", expected, versions)
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	// no session is needed: the driver gives up before running anything
+	l := &heldLock{owner: "me", done: make(chan struct{})}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	l.fail(errors.New("Refreshing the migration lock for id '' failed"))
+	d := &Driver{lock: l}
+	if d.runContext().Err() == nil {
+		t.Error("Expected the run context to be cancelled once the lock is lost")
+	}
+
+	f := file.File{FileName: "001_foobar.up.sql", Version: 1, Name: "foobar", Direction: direction.Up, Content: []byte("SELECT 1;")}
+	pipe := pipep.New()
+	go d.Migrate("", f, pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if merr, ok := errs[0].(*migrationerror.Error); !ok || merr.Category != migrationerror.Lock || merr.File == nil {
+		t.Errorf("Expected a lock error about the file, got %#v", errs[0])
+	}
+}
+
+func TestLockRefresh(t *testing.T) {
+	driverUrl := "cassandra://localhost/migratetest?lock_ttl=1s"
+	d1, d2 := &Driver{}, &Driver{}
+	if err := d1.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d1.Close()
+	if err := d2.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Close()
+	d1.ForceUnlock("refresh")
+
+	if err := d1.Lock("refresh"); err != nil {
+		t.Fatal(err)
+	}
+	// well past the TTL the lock was taken with
+	time.Sleep(2500 * time.Millisecond)
+	if err := d2.Lock("refresh"); err == nil {
+		t.Error("Expected the refreshed lock to be held still")
+		d2.Unlock("refresh")
+	}
+	if err := d1.lockLost(file.File{}); err != nil {
+		t.Errorf("Expected the lock to be refreshed, got %v", err)
+	}
+	if err := d1.Unlock("refresh"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock("refresh"); err != nil {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+	d2.Unlock("refresh")
+}
+
+func TestCounterVersion(t *testing.T) {
+	if version, err := counterVersion("", 1); err != nil || version != 0 {
+		t.Errorf("Expected version 0 of counter 1, got %v, %v", version, err)
+	}
+	if version, err := counterVersion("tenant1", 43); err != nil || version != 42 {
+		t.Errorf("Expected version 42 of counter 43, got %v, %v", version, err)
+	}
+	for _, counter := range []int64{0, -1} {
+		if version, err := counterVersion("tenant1", counter); err == nil {
+			t.Errorf("Expected error for counter %v, got version %v", counter, version)
+		}
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	d := &Driver{}
+	if err := d.setOptions("cassandra://localhost/migratetest"); err != nil {
+		t.Fatal(err)
+	}
+	if d.versionRetries != defaultVersionRetries || d.versionRetryBackoff != defaultVersionRetryBackoff {
+		t.Errorf("Expected default retry options, got %v, %v", d.versionRetries, d.versionRetryBackoff)
+	}
+
+	if err := d.setOptions("cassandra://localhost/migratetest?version_retries=2&version_retry_backoff=1s"); err != nil {
+		t.Fatal(err)
+	}
+	if d.versionRetries != 2 || d.versionRetryBackoff != time.Second {
+		t.Errorf("Expected retry options from url, got %v, %v", d.versionRetries, d.versionRetryBackoff)
+	}
+
+	if !d.seed {
+		t.Error("Expected seeding to be enabled by default")
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?seed=false"); err != nil {
+		t.Fatal(err)
+	}
+	if d.seed {
+		t.Error("Expected seeding to be disabled")
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?seed=maybe"); err == nil {
+		t.Error("Expected error for invalid seed")
+	}
+
+	if err := d.setOptions("cassandra://localhost/migratetest?version_retries=-1"); err == nil {
+		t.Error("Expected error for negative version_retries")
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?version_retry_backoff=soon"); err == nil {
+		t.Error("Expected error for invalid version_retry_backoff")
+	}
+
+	if d.lockTable != defaultLockTable || d.lockTTL != defaultLockTTL {
+		t.Errorf("Expected default lock options, got %v, %v", d.lockTable, d.lockTTL)
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?lock_table=ops.locks&lock_ttl=1h"); err != nil {
+		t.Fatal(err)
+	}
+	if d.lockTable != "ops.locks" || d.lockTTL != time.Hour {
+		t.Errorf("Expected lock options from url, got %v, %v", d.lockTable, d.lockTTL)
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?lock_table=a.b.c"); err == nil {
+		t.Error("Expected error for invalid lock_table")
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?lock_ttl=10ms"); err == nil {
+		t.Error("Expected error for too short lock_ttl")
+	}
+
+	if d.timeout != defaultTimeout {
+		t.Errorf("Expected default timeout, got %v", d.timeout)
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?timeout=5m"); err != nil {
+		t.Fatal(err)
+	}
+	if d.timeout != 5*time.Minute {
+		t.Errorf("Expected timeout from url, got %v", d.timeout)
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?timeout=0s"); err == nil {
+		t.Error("Expected error for a timeout of 0")
+	}
+
+	if err := d.setOptions("cassandra://localhost/migratetest"); err != nil {
+		t.Fatal(err)
+	}
+	if d.versionTable() != tableName || d.idVersionTable() != idTableName {
+		t.Errorf("Expected default version tables, got %v, %v", d.versionTable(), d.idVersionTable())
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?version_table=ops.app_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if d.versionTable() != "ops.app_migrations" || d.idVersionTable() != "ops.app_migrations_by_id" {
+		t.Errorf("Expected version tables from url, got %v, %v", d.versionTable(), d.idVersionTable())
+	}
+	if err := d.setOptions("cassandra://localhost/migratetest?version_table=users%3B%20DROP%20TABLE%20users"); err == nil {
+		t.Error("Expected error for invalid version_table")
+	}
+}
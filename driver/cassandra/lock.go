@@ -0,0 +1,168 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	"github.com/gocql/gocql"
+)
+
+const (
+	defaultLockTable = "schema_migrations_lock"
+	defaultLockTTL   = 15 * time.Minute
+)
+
+// lockTableRegex matches a table name, optionally qualified by a keyspace
+var lockTableRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// heldLock is a lock taken by Lock, whose TTL is refreshed until Unlock.
+type heldLock struct {
+	owner string
+
+	// the run context while the lock is held, cancelled by Unlock or
+	// once refreshing the lock fails
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// closed once the refresh stopped
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// fail records why refreshing the lock failed and cancels the run.
+func (l *heldLock) fail(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+	l.cancel()
+}
+
+// lost returns why refreshing the lock failed, nil if it didn't or no
+// lock is held.
+func (l *heldLock) lost() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Lock takes the migration lock for id using a lightweight transaction
+// on the lock table. The lock expires after lock_ttl, so a crashed
+// migrator doesn't hold it forever. While held, its TTL is refreshed
+// every third of lock_ttl; if that fails, e.g. because the lock expired
+// and was taken by someone else, the running query is cancelled and the
+// run is aborted. Lock fails right away if another migrator holds it.
+func (driver *Driver) Lock(id string) error {
+	if err := driver.ensureLockTableExists(); err != nil {
+		return err
+	}
+	owner, err := gocql.RandomUUID()
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]interface{})
+	acquired := time.Now()
+	applied, err := driver.session.Query(`INSERT INTO `+driver.lockTable+` (id, owner, acquired)
+		VALUES (?, ?, ?) IF NOT EXISTS USING TTL ?`,
+		id, owner.String(), acquired, int(driver.lockTTL/time.Second)).MapScanCAS(existing)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return fmt.Errorf("Migration lock for id '%s' is held by %v since %v. It expires %v after it was taken, or clear it with 'migrate unlock'.",
+			id, existing["owner"], existing["acquired"], driver.lockTTL)
+	}
+	l := &heldLock{owner: owner.String(), done: make(chan struct{})}
+	l.ctx, l.cancel = context.WithCancel(driver.runContext())
+	driver.lock = l
+	go driver.keepLock(l, id, acquired)
+	return nil
+}
+
+// keepLock refreshes the TTL of l until it is cancelled, or fails it
+// once a refresh fails.
+func (driver *Driver) keepLock(l *heldLock, id string, acquired time.Time) {
+	defer close(l.done)
+	ticker := time.NewTicker(driver.lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		existing := make(map[string]interface{})
+		applied, err := driver.session.Query(`UPDATE `+driver.lockTable+` USING TTL ?
+			SET owner = ?, acquired = ? WHERE id = ? IF owner = ?`,
+			int(driver.lockTTL/time.Second), l.owner, acquired, id, l.owner).WithContext(l.ctx).MapScanCAS(existing)
+		if l.ctx.Err() != nil {
+			return
+		}
+		if err == nil && !applied {
+			err = fmt.Errorf("it expired and is held by %v now", existing["owner"])
+		}
+		if err != nil {
+			l.fail(fmt.Errorf("Refreshing the migration lock for id '%s' failed, aborting the run: %v", id, err))
+			return
+		}
+	}
+}
+
+// lockLost returns a Lock error for f if refreshing the lock failed,
+// so that no further files run.
+func (driver *Driver) lockLost(f file.File) *migrationerror.Error {
+	if err := driver.lock.lost(); err != nil {
+		return &migrationerror.Error{File: &f, Category: migrationerror.Lock, Err: err}
+	}
+	return nil
+}
+
+// Unlock releases the lock taken by Lock, unless it expired and was
+// taken by someone else meanwhile.
+func (driver *Driver) Unlock(id string) error {
+	if driver.lock == nil {
+		return nil
+	}
+	l := driver.lock
+	driver.lock = nil
+	l.cancel()
+	<-l.done
+	owner := l.owner
+
+	existing := make(map[string]interface{})
+	applied, err := driver.session.Query(`DELETE FROM `+driver.lockTable+` WHERE id = ? IF owner = ?`,
+		id, owner).MapScanCAS(existing)
+	if err != nil {
+		return err
+	}
+	if !applied && existing["owner"] != nil {
+		return fmt.Errorf("Migration lock for id '%s' expired and was taken by %v meanwhile.", id, existing["owner"])
+	}
+	return nil
+}
+
+// ForceUnlock clears the lock for id no matter who holds it.
+func (driver *Driver) ForceUnlock(id string) error {
+	if err := driver.ensureLockTableExists(); err != nil {
+		return err
+	}
+	return driver.session.Query(`DELETE FROM `+driver.lockTable+` WHERE id = ?`, id).Exec()
+}
+
+func (driver *Driver) ensureLockTableExists() error {
+	return driver.session.Query(`CREATE TABLE IF NOT EXISTS ` + driver.lockTable + ` (
+		id text primary key,
+		owner text,
+		acquired timestamp
+	)`).Exec()
+}
@@ -0,0 +1,76 @@
+package cockroachdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	"github.com/lib/pq"
+)
+
+func TestSetOptions(t *testing.T) {
+	var tests = []struct {
+		url           string
+		expectUrl     string
+		expectRetries int
+		expectError   bool
+	}{
+		{"cockroachdb://root@localhost:26257/db", "postgres://root@localhost:26257/db", defaultRetries, false},
+		{"cockroach://root@localhost:26257/db?sslmode=disable&retries=2", "postgres://root@localhost:26257/db?sslmode=disable", 2, false},
+		{"cockroachdb://root@localhost:26257/db?retries=0", "postgres://root@localhost:26257/db", 0, false},
+		{"cockroachdb://root@localhost:26257/db?retries=-1", "", 0, true},
+	}
+
+	for _, test := range tests {
+		d := &Driver{}
+		u, err := d.setOptions(test.url)
+		if (err != nil) != test.expectError {
+			t.Errorf("Expected error for %v: %v, got %v", test.url, test.expectError, err)
+			continue
+		}
+		if err == nil && (u != test.expectUrl || d.retries != test.expectRetries) {
+			t.Errorf("Expected %q, %v for %v, got %q, %v", test.expectUrl, test.expectRetries, test.url, u, d.retries)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	retryBackoff = 0
+	f := file.File{FileName: "001_foo.up.sql"}
+	serializationFailure := migrationerror.New(f, retryableCode, &pq.Error{Code: retryableCode})
+
+	d := &Driver{retries: 2}
+	attempts := 0
+	pipe := make(chan interface{})
+	go d.retry(f, pipe, func(pipe chan interface{}) {
+		defer close(pipe)
+		attempts += 1
+		pipe <- f
+		if attempts < 3 {
+			pipe <- serializationFailure
+		}
+	})
+	errs := 0
+	for item := range pipe {
+		if _, ok := item.(error); ok {
+			errs += 1
+		}
+	}
+	if attempts != 3 || errs != 0 {
+		t.Errorf("Expected 3 attempts and no errors, got %v attempts and %v errors", attempts, errs)
+	}
+
+	attempts = 0
+	pipe = make(chan interface{})
+	go d.retry(f, pipe, func(pipe chan interface{}) {
+		defer close(pipe)
+		attempts += 1
+		pipe <- errors.New("syntax error")
+	})
+	for range pipe {
+	}
+	if attempts != 1 {
+		t.Errorf("Expected other errors not to be retried, got %v attempts", attempts)
+	}
+}
@@ -2,14 +2,26 @@
 package driver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	neturl "net/url" // alias to allow `url string` func signature in New
 
 	"github.com/PlanitarInc/migrate/driver/bash"
+	"github.com/PlanitarInc/migrate/driver/bigquery"
 	"github.com/PlanitarInc/migrate/driver/cassandra"
+	"github.com/PlanitarInc/migrate/driver/cockroachdb"
+	"github.com/PlanitarInc/migrate/driver/dynamodb"
+	"github.com/PlanitarInc/migrate/driver/mock"
+	"github.com/PlanitarInc/migrate/driver/mongodb"
+	"github.com/PlanitarInc/migrate/driver/neo4j"
+	"github.com/PlanitarInc/migrate/driver/oracle"
 	"github.com/PlanitarInc/migrate/driver/postgres"
+	"github.com/PlanitarInc/migrate/driver/redis"
+	"github.com/PlanitarInc/migrate/driver/sqlserver"
 	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/history"
+	"github.com/PlanitarInc/migrate/migrate/journal"
 )
 
 // Driver is the interface type that needs to implemented by all drivers.
@@ -37,10 +49,203 @@ type Driver interface {
 
 	// Version returns the current migration version.
 	Version(id string) (uint64, error)
+
+	// SupportsTransactions reports whether a failed migration is
+	// rolled back as a whole.
+	SupportsTransactions() bool
+
+	// SupportsLocking reports whether concurrent migrators are
+	// prevented from running at the same time.
+	SupportsLocking() bool
+
+	// SupportsVersionListing reports whether every applied version is
+	// recorded, rather than only the current one.
+	SupportsVersionListing() bool
+
+	// SupportsDirtyState reports whether a partially applied migration
+	// is tracked as dirty.
+	SupportsDirtyState() bool
+
+	// SupportsMultiStatement reports whether a migration file may
+	// contain more than one statement. If not, files with several
+	// statements fail before running if the driver is a StatementCounter.
+	SupportsMultiStatement() bool
+}
+
+// Executor is implemented by drivers that can run a migration file
+// without recording its version, leaving bookkeeping to the caller.
+type Executor interface {
+	// Execute applies the file like Migrate, but does not touch the
+	// version table.
+	Execute(file file.File, pipe chan interface{})
+}
+
+// BatchMigrator is implemented by drivers that can apply several
+// migration files all or nothing, see Migrator.AllInOneTx.
+type BatchMigrator interface {
+	// MigrateBatch applies files like Migrate, one after another, in
+	// a single transaction: if one of them fails, none is applied.
+	MigrateBatch(id string, files file.Files, pipe chan interface{})
+}
+
+// Locker is implemented by drivers that prevent concurrent migrators
+// from running migrations of the same id at the same time, e.g. with
+// advisory locks in postgres. The migrator holds the lock while applying
+// migrations; drivers that don't implement Locker aren't locked.
+type Locker interface {
+	// Lock takes the migration lock for id. It fails if the lock is
+	// held by someone else, possibly after waiting for a while.
+	Lock(id string) error
+
+	// Unlock releases the lock taken by Lock.
+	Unlock(id string) error
+}
+
+// LockBreaker is implemented by drivers whose locks outlive a crashed
+// migrator, so that a stale lock can be cleared by hand.
+type LockBreaker interface {
+	// ForceUnlock clears the lock for id, no matter who holds it.
+	ForceUnlock(id string) error
+}
+
+// DirtyTracker is implemented by drivers that mark a version as dirty
+// while its migration is applied and clear the mark once it succeeded.
+// A dirty version left behind means the migration stopped halfway.
+// Drivers that are also a VersionForcer clear the mark in ForceVersion.
+type DirtyTracker interface {
+	// Dirty reports whether the current version is dirty.
+	Dirty(id string) (bool, error)
+}
+
+// VersionForcer is implemented by drivers whose version can be set by
+// hand, e.g. after fixing a failed migration manually.
+type VersionForcer interface {
+	// ForceVersion makes version the current version of id without
+	// running any migrations.
+	ForceVersion(id string, version uint64) error
+}
+
+// Baseliner is implemented by drivers that can record versions as
+// applied without running them, e.g. when adopting migrate on an
+// existing database.
+type Baseliner interface {
+	// Baseline records versions, given in ascending order, as applied.
+	Baseline(id string, versions []uint64) error
+}
+
+// VersionLister is implemented by drivers that record every applied
+// version, see SupportsVersionListing.
+type VersionLister interface {
+	// ListVersions returns the applied versions of id in ascending order.
+	ListVersions(id string) ([]uint64, error)
+}
+
+// ContextSetter is implemented by drivers that run migrations with a
+// context, so that a hung migration can be cancelled.
+type ContextSetter interface {
+	// SetContext sets the context of all following migrations. Once it
+	// is done, the running migration is aborted and rolled back where
+	// possible.
+	SetContext(ctx context.Context)
+}
+
+// Checksummer is implemented by drivers that record a checksum
+// of the content of every applied migration file.
+type Checksummer interface {
+	// Checksums returns the recorded checksum of every applied version.
+	Checksums(id string) (map[uint64]string, error)
+}
+
+// ChecksumFiller is implemented by Checksummers that can record the
+// checksums of versions applied before checksums were recorded.
+type ChecksumFiller interface {
+	// FillChecksum records the checksum of an applied version unless one
+	// is recorded already, and reports whether it did.
+	FillChecksum(id string, version uint64, checksum string) (bool, error)
+}
+
+// SyntaxChecker is implemented by drivers that can validate the syntax
+// of a migration file without executing it.
+type SyntaxChecker interface {
+	// CheckSyntax returns the first syntax error in the file, if any.
+	CheckSyntax(file file.File) error
+}
+
+// IdLister is implemented by drivers that keep track of the versions
+// of several migration ids in the same database, e.g. one per tenant.
+type IdLister interface {
+	// IdVersions returns the current version of every id
+	// that has applied migrations.
+	IdVersions() (map[string]uint64, error)
+}
+
+// Historian is implemented by drivers that record when every version
+// was applied.
+type Historian interface {
+	// History returns the applied versions of id in the order they
+	// were applied, versions of unknown time first.
+	History(id string) ([]history.AppliedMigration, error)
+}
+
+// StatementCounter is implemented by drivers that can tell how many
+// statements the content of a migration file consists of.
+type StatementCounter interface {
+	// CountStatements returns the number of statements in content.
+	CountStatements(content []byte) int
+}
+
+// Journaler is implemented by drivers that can record every statement
+// they execute, see journal.Journal.
+type Journaler interface {
+	// SetJournal sets the journal statements are recorded in.
+	SetJournal(j *journal.Journal)
+}
+
+// DryRunner is implemented by drivers that can show the statements of
+// migrations without executing them.
+type DryRunner interface {
+	// SetDryRun makes all following migrations send their statements
+	// down the pipe instead of executing them and leave the version
+	// table alone.
+	SetDryRun(dryRun bool)
+}
+
+// Capabilities summarizes what a driver supports.
+type Capabilities struct {
+	Transactions   bool
+	Locking        bool
+	VersionListing bool
+	DirtyState     bool
+	MultiStatement bool
+}
+
+// CapabilitiesOf returns the capabilities reported by a driver.
+func CapabilitiesOf(d Driver) Capabilities {
+	return Capabilities{
+		Transactions:   d.SupportsTransactions(),
+		Locking:        d.SupportsLocking(),
+		VersionListing: d.SupportsVersionListing(),
+		DirtyState:     d.SupportsDirtyState(),
+		MultiStatement: d.SupportsMultiStatement(),
+	}
 }
 
 // New returns Driver and calls Initialize on it
 func New(instance interface{}, url string) (Driver, error) {
+	d, err := Lookup(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Initialize(instance, url); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Lookup returns the Driver for the url's scheme without initializing it.
+// Only methods that don't need a connection, like FilenameExtension,
+// may be called on it.
+func Lookup(url string) (Driver, error) {
 	u, err := neturl.Parse(url)
 	if err != nil {
 		return nil, err
@@ -50,25 +255,61 @@ func New(instance interface{}, url string) (Driver, error) {
 	case "postgres":
 		d := &postgres.Driver{}
 		verifyFilenameExtension("postgres", d)
-		if err := d.Initialize(instance, url); err != nil {
-			return nil, err
-		}
 		return d, nil
 
 	case "bash":
 		d := &bash.Driver{}
 		verifyFilenameExtension("bash", d)
-		if err := d.Initialize(instance, url); err != nil {
-			return nil, err
-		}
+		return d, nil
+
+	case "bigquery":
+		d := &bigquery.Driver{}
+		verifyFilenameExtension("bigquery", d)
 		return d, nil
 
 	case "cassandra":
 		d := &cassandra.Driver{}
 		verifyFilenameExtension("cassanda", d)
-		if err := d.Initialize(instance, url); err != nil {
-			return nil, err
-		}
+		return d, nil
+
+	case "cockroachdb", "cockroach":
+		d := &cockroachdb.Driver{}
+		verifyFilenameExtension("cockroachdb", d)
+		return d, nil
+
+	case "dynamodb":
+		d := &dynamodb.Driver{}
+		verifyFilenameExtension("dynamodb", d)
+		return d, nil
+
+	case "mock":
+		d := &mock.Driver{}
+		verifyFilenameExtension("mock", d)
+		return d, nil
+
+	case "mongodb", "mongodb+srv":
+		d := &mongodb.Driver{}
+		verifyFilenameExtension("mongodb", d)
+		return d, nil
+
+	case "neo4j", "neo4j+s", "bolt", "bolt+s":
+		d := &neo4j.Driver{}
+		verifyFilenameExtension("neo4j", d)
+		return d, nil
+
+	case "oracle":
+		d := &oracle.Driver{}
+		verifyFilenameExtension("oracle", d)
+		return d, nil
+
+	case "redis":
+		d := &redis.Driver{}
+		verifyFilenameExtension("redis", d)
+		return d, nil
+
+	case "sqlserver":
+		d := &sqlserver.Driver{}
+		verifyFilenameExtension("sqlserver", d)
 		return d, nil
 	default:
 		return nil, errors.New(fmt.Sprintf("Driver '%s' not found.", u.Scheme))
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -9,3 +10,34 @@ func TestNew(t *testing.T) {
 		t.Error("no error although driver unknown")
 	}
 }
+
+func TestCapabilitiesOf(t *testing.T) {
+	d, err := New(nil, "bash://url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps := CapabilitiesOf(d); caps != (Capabilities{}) {
+		t.Errorf("Expected bash driver to support nothing, got %+v", caps)
+	}
+}
+
+func TestNewWithRetry(t *testing.T) {
+	retries := 0
+	retrying := func(err error, wait time.Duration) { retries += 1 }
+
+	if _, err := NewWithRetry(nil, "unknown://url", time.Second, retrying); err == nil || retries != 0 {
+		t.Errorf("Expected unknown driver to fail without retries, got %v retries", retries)
+	}
+
+	start := time.Now()
+	_, err := NewWithRetry(nil, "postgres://localhost:1/db?sslmode=disable&connect_timeout=1", 500*time.Millisecond, retrying)
+	if err == nil {
+		t.Fatal("Expected connecting to a closed port to fail")
+	}
+	if retries < 1 {
+		t.Errorf("Expected retries, got %v", retries)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Expected retries for 500ms, gave up after %v", elapsed)
+	}
+}
@@ -0,0 +1,99 @@
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// the version of the DynamoDB API in the X-Amz-Target of requests
+const targetPrefix = "DynamoDB_20120810."
+
+// client calls actions of the DynamoDB API, signed with AWS Signature
+// Version 4.
+type client struct {
+	http        *http.Client
+	endpoint    string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+}
+
+// apiError is an error response of the API, e.g. for a table that
+// exists already.
+type apiError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.code() + ": " + e.Message
+}
+
+// code returns the name of the error, e.g. ResourceInUseException.
+func (e *apiError) code() string {
+	return e.Type[strings.LastIndex(e.Type, "#")+1:]
+}
+
+// isNotFound reports whether err says that a table doesn't exist.
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.code() == "ResourceNotFoundException"
+}
+
+// do calls action with body encoded as JSON, unless it is raw JSON
+// already, and decodes the response into v.
+func (c *client) do(ctx context.Context, action string, body, v interface{}) error {
+	b, ok := body.(json.RawMessage)
+	if !ok {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", targetPrefix+action)
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(b)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "dynamodb", c.region, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var apiErr apiError
+		if err := json.Unmarshal(b, &apiErr); err != nil || apiErr.Type == "" {
+			return fmt.Errorf("Unexpected DynamoDB response %s: %s", resp.Status, bytes.TrimSpace(b))
+		}
+		return &apiErr
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,43 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awscredentials "github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// Credentials are the AWS access keys requests are signed with. Pass
+// them as instance to use them instead of the default credentials.
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+
+	// set for temporary credentials, e.g. of an assumed role
+	SessionToken string
+}
+
+// provider returns a provider of the static credentials.
+func (c Credentials) provider() aws.CredentialsProvider {
+	return awscredentials.NewStaticCredentialsProvider(c.AccessKeyId, c.SecretAccessKey, c.SessionToken)
+}
+
+// defaultCredentials returns the default credential chain of the AWS SDK:
+// the environment variables, the profile AWS_PROFILE in the shared config
+// and credentials files (including SSO, credential_process and assumed
+// roles), web identity tokens, e.g. of EKS service accounts, and the
+// roles of ECS tasks and EC2 instances. The credentials are retrieved
+// once, so that missing ones fail Initialize, and cached until they
+// expire.
+func defaultCredentials(ctx context.Context, region string) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("Loading the AWS config failed: %v", err)
+	}
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return nil, fmt.Errorf("No AWS credentials found: %v", err)
+	}
+	return cfg.Credentials, nil
+}
@@ -0,0 +1,414 @@
+// Package dynamodb implements the Driver interface for DynamoDB, running
+// control plane operations like CreateTable described by JSON files.
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+type Driver struct {
+	client *client
+
+	// the table holding the versions, set by ?metadata_table=
+	table string
+
+	// how often tables are described while waiting for them
+	pollInterval time.Duration
+
+	// the context migrations run with, see SetContext
+	ctx context.Context
+}
+
+const (
+	defaultMetadataTable = "migrate_metadata"
+	defaultPollInterval  = 2 * time.Second
+
+	// the key of the item holding the versions, followed by /id for
+	// ids other than ""
+	versionKey = "schema_migrations"
+)
+
+// tableNameRegex matches the names DynamoDB allows for tables
+var tableNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,255}$`)
+
+// the operations migration files may consist of
+var actions = map[string]bool{
+	"CreateTable":             true,
+	"UpdateTable":             true,
+	"DeleteTable":             true,
+	"UpdateTimeToLive":        true,
+	"UpdateContinuousBackups": true,
+}
+
+// DynamoDB Driver URL format:
+// dynamodb://region/[?endpoint=http://localhost:8000&metadata_table=migrate_metadata]
+//
+// Requests are signed with the default credential chain of the AWS SDK,
+// unless instance is a Credentials value.
+// endpoint overrides the regional endpoint, e.g. for DynamoDB Local.
+func (driver *Driver) Initialize(instance interface{}, url string) error {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	region := u.Host
+	if region == "" || strings.Trim(u.Path, "/") != "" {
+		return fmt.Errorf("Invalid DynamoDB url, expected dynamodb://region/.")
+	}
+	q := u.Query()
+
+	c := &client{http: http.DefaultClient, signer: v4.NewSigner(), region: region, endpoint: "https://dynamodb." + region + ".amazonaws.com"}
+	if strings.HasPrefix(region, "cn-") {
+		c.endpoint += ".cn"
+	}
+	if v := q.Get("endpoint"); v != "" {
+		endpoint, err := neturl.Parse(v)
+		if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return fmt.Errorf("Invalid endpoint %q, expected a url like http://localhost:8000.", v)
+		}
+		c.endpoint = strings.TrimRight(v, "/")
+	}
+
+	driver.table = defaultMetadataTable
+	if v := q.Get("metadata_table"); v != "" {
+		if !tableNameRegex.MatchString(v) {
+			return fmt.Errorf("Invalid metadata_table %q.", v)
+		}
+		driver.table = v
+	}
+	if driver.pollInterval == 0 {
+		driver.pollInterval = defaultPollInterval
+	}
+
+	switch instance := instance.(type) {
+	case nil:
+		if c.credentials, err = defaultCredentials(driver.runContext(), region); err != nil {
+			return err
+		}
+	case Credentials:
+		c.credentials = instance.provider()
+	default:
+		return fmt.Errorf("Expected instance of dynamodb.Credentials, got %#v", instance)
+	}
+
+	driver.client = c
+	if err := driver.ensureMetadataTableExists(); err != nil {
+		if _, ok := err.(*apiError); !ok {
+			return migrationerror.Wrap(migrationerror.Connection, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (driver *Driver) Close() error {
+	return nil
+}
+
+// ensureMetadataTableExists creates the metadata table, billed per
+// request, unless it exists, and waits for it to be active.
+func (driver *Driver) ensureMetadataTableExists() error {
+	ctx := driver.runContext()
+	if _, err := driver.describeTable(ctx, driver.table); err == nil || !isNotFound(err) {
+		return err
+	}
+	err := driver.client.do(ctx, "CreateTable", map[string]interface{}{
+		"TableName":            driver.table,
+		"AttributeDefinitions": []map[string]string{{"AttributeName": "key", "AttributeType": "S"}},
+		"KeySchema":            []map[string]string{{"AttributeName": "key", "KeyType": "HASH"}},
+		"BillingMode":          "PAY_PER_REQUEST",
+	}, nil)
+	if apiErr, ok := err.(*apiError); ok && apiErr.code() == "ResourceInUseException" {
+		// created by another migrator meanwhile
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return driver.waitForTable(ctx, driver.table, false)
+}
+
+func (driver *Driver) FilenameExtension() string {
+	return "json"
+}
+
+// operation is a control plane call of a migration file, e.g.
+// {"CreateTable": {"TableName": "users", ...}}, whose request is
+// passed on as is.
+type operation struct {
+	action  string
+	table   string
+	request json.RawMessage
+}
+
+// parseOperations parses the content of a migration file, a JSON array
+// of operations. Empty files have no operations.
+func parseOperations(content []byte) ([]operation, error) {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return []operation{}, nil
+	}
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("Invalid migration file, expected a JSON array of operations: %v", err)
+	}
+	operations := make([]operation, 0, len(raw))
+	for i, o := range raw {
+		if len(o) != 1 {
+			return nil, fmt.Errorf("Operation %d must have exactly one action, e.g. {\"CreateTable\": {...}}.", i+1)
+		}
+		for action, request := range o {
+			if !actions[action] {
+				return nil, fmt.Errorf("Operation %d has the unsupported action %s.", i+1, action)
+			}
+			var r struct {
+				TableName string
+			}
+			if err := json.Unmarshal(request, &r); err != nil || r.TableName == "" {
+				return nil, fmt.Errorf("Operation %d (%s) has no TableName.", i+1, action)
+			}
+			operations = append(operations, operation{action: action, table: r.TableName, request: request})
+		}
+	}
+	return operations, nil
+}
+
+// Migrate runs the operations of the file one by one and records the
+// version once all of them succeeded. Operations that succeeded before
+// a failing one stay applied.
+func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	start := time.Now()
+	if err := driver.execute(f, pipe); err != nil {
+		pipe <- err
+		return
+	}
+	if err := driver.recordVersion(id, f.Version, f.Direction); err != nil {
+		pipe <- err
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// Execute runs the operations of the file without recording its version.
+func (driver *Driver) Execute(f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	start := time.Now()
+	if err := driver.execute(f, pipe); err != nil {
+		pipe <- err
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// execute runs the operations of a migration file one by one, waiting
+// for the table of each to be active (or deleted) before the next.
+func (driver *Driver) execute(f file.File, pipe chan interface{}) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	operations, err := parseOperations(f.Content)
+	if err != nil {
+		return migrationerror.New(f, "", err)
+	}
+	ctx := driver.runContext()
+	for i, o := range operations {
+		pipe <- file.StatementProgress{File: f, Current: i + 1, Total: len(operations)}
+		err := driver.client.do(ctx, o.action, o.request, nil)
+		if err == nil {
+			err = driver.waitForTable(ctx, o.table, o.action == "DeleteTable")
+		}
+		if err != nil {
+			if cerr := migrationerror.Cancelled(ctx, f); cerr != nil {
+				return cerr
+			}
+			code := ""
+			if apiErr, ok := err.(*apiError); ok {
+				code = apiErr.code()
+			}
+			return migrationerror.New(f, code, fmt.Errorf("Operation %d of %d (%s %s) failed: %w", i+1, len(operations), o.action, o.table, err))
+		}
+	}
+	return nil
+}
+
+type tableDescription struct {
+	TableStatus            string
+	GlobalSecondaryIndexes []struct {
+		IndexName   string
+		IndexStatus string
+	}
+}
+
+func (driver *Driver) describeTable(ctx context.Context, table string) (*tableDescription, error) {
+	var resp struct {
+		Table tableDescription
+	}
+	if err := driver.client.do(ctx, "DescribeTable", map[string]string{"TableName": table}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Table, nil
+}
+
+// waitForTable waits until table and all its global secondary indexes
+// are active or, if deleted is set, until table doesn't exist anymore.
+func (driver *Driver) waitForTable(ctx context.Context, table string, deleted bool) error {
+	for {
+		desc, err := driver.describeTable(ctx, table)
+		switch {
+		case deleted && isNotFound(err):
+			return nil
+		case err != nil:
+			return err
+		case !deleted && desc.active():
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(driver.pollInterval):
+		}
+	}
+}
+
+func (desc *tableDescription) active() bool {
+	if desc.TableStatus != "ACTIVE" {
+		return false
+	}
+	for _, index := range desc.GlobalSecondaryIndexes {
+		if index.IndexStatus != "ACTIVE" {
+			return false
+		}
+	}
+	return true
+}
+
+// itemKey returns the key of the item holding the versions of id.
+func (driver *Driver) itemKey(id string) map[string]interface{} {
+	key := versionKey
+	if id != "" {
+		key += "/" + id
+	}
+	return map[string]interface{}{"key": map[string]string{"S": key}}
+}
+
+// recordVersion adds (up) or removes (down) a version in the set of
+// applied versions of id.
+func (driver *Driver) recordVersion(id string, version uint64, d direction.Direction) error {
+	var expression string
+	switch d {
+	case direction.Up:
+		expression = "ADD versions :v"
+	case direction.Down:
+		expression = "DELETE versions :v"
+	default:
+		return errors.New("Unsupported direction.Direction Type")
+	}
+	return driver.client.do(driver.runContext(), "UpdateItem", map[string]interface{}{
+		"TableName":                 driver.table,
+		"Key":                       driver.itemKey(id),
+		"UpdateExpression":          expression,
+		"ExpressionAttributeValues": map[string]interface{}{":v": map[string][]string{"NS": {strconv.FormatUint(version, 10)}}},
+	}, nil)
+}
+
+// SetContext makes the operations of all following migrations run with
+// ctx. Once it is done, waiting for a table is aborted; operations that
+// were sent already aren't rolled back.
+func (driver *Driver) SetContext(ctx context.Context) {
+	driver.ctx = ctx
+}
+
+// runContext returns the context set by SetContext, context.Background()
+// if unset
+func (driver *Driver) runContext() context.Context {
+	if driver.ctx == nil {
+		return context.Background()
+	}
+	return driver.ctx
+}
+
+func (driver *Driver) Version(id string) (uint64, error) {
+	versions, err := driver.ListVersions(id)
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// ListVersions returns the applied versions of id in ascending order.
+func (driver *Driver) ListVersions(id string) ([]uint64, error) {
+	var resp struct {
+		Item struct {
+			Versions struct {
+				NS []string
+			} `json:"versions"`
+		}
+	}
+	err := driver.client.do(driver.runContext(), "GetItem", map[string]interface{}{
+		"TableName":      driver.table,
+		"Key":            driver.itemKey(id),
+		"ConsistentRead": true,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]uint64, 0, len(resp.Item.Versions.NS))
+	for _, v := range resp.Item.Versions.NS {
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected version %q in %s.", v, driver.table)
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// CountStatements returns the number of operations in content.
+func (driver *Driver) CountStatements(content []byte) int {
+	operations, err := parseOperations(content)
+	if err != nil {
+		return 0
+	}
+	return len(operations)
+}
+
+func (driver *Driver) SupportsTransactions() bool {
+	return false
+}
+
+func (driver *Driver) SupportsLocking() bool {
+	return false
+}
+
+func (driver *Driver) SupportsVersionListing() bool {
+	return true
+}
+
+func (driver *Driver) SupportsDirtyState() bool {
+	return false
+}
+
+func (driver *Driver) SupportsMultiStatement() bool {
+	return true
+}
@@ -0,0 +1,305 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+func TestSign(t *testing.T) {
+	var auth, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, token = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Security-Token")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	creds := Credentials{AccessKeyId: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+	c := &client{http: server.Client(), signer: v4.NewSigner(), endpoint: server.URL, region: "us-east-1", credentials: creds.provider()}
+	if err := c.do(context.Background(), "ListTables", map[string]string{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/dynamodb/aws4_request") {
+		t.Errorf("Unexpected Authorization %q", auth)
+	}
+	if token != "token" || !strings.Contains(auth, "x-amz-security-token") || !strings.Contains(auth, "x-amz-target") {
+		t.Errorf("Expected the session token and target to be signed, got %q", auth)
+	}
+}
+
+func TestParseOperations(t *testing.T) {
+	var tests = []struct {
+		content      string
+		expectTables []string
+		expectErr    bool
+	}{
+		{"", []string{}, false},
+		{`[{"CreateTable": {"TableName": "users"}}, {"UpdateTable": {"TableName": "users"}}]`, []string{"users", "users"}, false},
+		{`[{"DeleteTable": {"TableName": "old"}}]`, []string{"old"}, false},
+		{`{"CreateTable": {"TableName": "users"}}`, nil, true},
+		{`[{"PutItem": {"TableName": "users"}}]`, nil, true},
+		{`[{"CreateTable": {"TableName": "a"}, "DeleteTable": {"TableName": "b"}}]`, nil, true},
+		{`[{"CreateTable": {}}]`, nil, true},
+	}
+
+	for _, test := range tests {
+		operations, err := parseOperations([]byte(test.content))
+		if (err != nil) != test.expectErr {
+			t.Errorf("Expected error for %q: %v, got %v", test.content, test.expectErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		tables := make([]string, 0)
+		for _, o := range operations {
+			tables = append(tables, o.table)
+		}
+		if !reflect.DeepEqual(tables, test.expectTables) {
+			t.Errorf("Expected tables %v for %q, got %v", test.expectTables, test.content, tables)
+		}
+	}
+}
+
+func TestDefaultCredentials(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	err := ioutil.WriteFile(config, []byte(`[profile deploy]
+credential_process = echo '{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "secret"}'
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", config)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	if id := retrieveDefault(t); id != "AKIDENV" {
+		t.Errorf("Expected the credentials of the environment, got %v", id)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "deploy")
+	if id := retrieveDefault(t); id != "AKIDPROCESS" {
+		t.Errorf("Expected the credentials of the credential_process of deploy, got %v", id)
+	}
+
+	t.Setenv("AWS_PROFILE", "")
+	if _, err := defaultCredentials(context.Background(), "us-east-1"); err == nil {
+		t.Error("Expected error without credentials")
+	}
+}
+
+func retrieveDefault(t *testing.T) string {
+	provider, err := defaultCredentials(context.Background(), "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creds.AccessKeyID
+}
+
+// fakeDynamoDB answers the actions of the driver, keeping tables and
+// versions in memory. Tables are CREATING when described for the first
+// time; tables named "fails" can't be created.
+type fakeDynamoDB struct {
+	mu        sync.Mutex
+	tables    map[string]string
+	versions  map[string]map[string]bool
+	actions   []string
+	describes int
+}
+
+func (s *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazon.coral.service#UnrecognizedClientException","message":"The security token included in the request is invalid."}`))
+		return
+	}
+	action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), targetPrefix)
+	s.actions = append(s.actions, action)
+	var req struct {
+		TableName        string
+		UpdateExpression string
+		Key              struct {
+			Key struct{ S string }
+		}
+		ExpressionAttributeValues map[string]struct{ NS []string }
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	fail := func(code, message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#" + code, "message": message})
+	}
+	switch action {
+	case "DescribeTable":
+		status, ok := s.tables[req.TableName]
+		if !ok {
+			fail("ResourceNotFoundException", "Requested resource not found")
+			return
+		}
+		s.describes += 1
+		s.tables[req.TableName] = "ACTIVE"
+		json.NewEncoder(w).Encode(map[string]interface{}{"Table": map[string]string{"TableName": req.TableName, "TableStatus": status}})
+	case "CreateTable":
+		if req.TableName == "fails" {
+			fail("ValidationException", "One or more parameter values were invalid")
+			return
+		}
+		if _, ok := s.tables[req.TableName]; ok {
+			fail("ResourceInUseException", "Table already exists: "+req.TableName)
+			return
+		}
+		s.tables[req.TableName] = "CREATING"
+		w.Write([]byte(`{}`))
+	case "DeleteTable":
+		delete(s.tables, req.TableName)
+		w.Write([]byte(`{}`))
+	case "UpdateItem":
+		key := req.Key.Key.S
+		if s.versions[key] == nil {
+			s.versions[key] = make(map[string]bool)
+		}
+		for _, v := range req.ExpressionAttributeValues[":v"].NS {
+			if strings.HasPrefix(req.UpdateExpression, "ADD") {
+				s.versions[key][v] = true
+			} else {
+				delete(s.versions[key], v)
+			}
+		}
+		w.Write([]byte(`{}`))
+	case "GetItem":
+		item := map[string]interface{}{}
+		if versions := s.versions[req.Key.Key.S]; len(versions) > 0 {
+			ns := make([]string, 0)
+			for v := range versions {
+				ns = append(ns, v)
+			}
+			item["versions"] = map[string][]string{"NS": ns}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Item": item})
+	default:
+		fail("UnknownOperationException", "")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	fake := &fakeDynamoDB{tables: make(map[string]string), versions: make(map[string]map[string]bool)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	d := &Driver{pollInterval: time.Millisecond}
+	if err := d.Initialize(Credentials{AccessKeyId: "AKID", SecretAccessKey: "secret"}, "dynamodb://us-east-1/?endpoint="+server.URL); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if fake.tables[defaultMetadataTable] != "ACTIVE" {
+		t.Fatalf("Expected the metadata table to be created, got %v", fake.tables)
+	}
+
+	files := []file.File{
+		{
+			FileName:  "001_users.up.json",
+			Version:   1,
+			Name:      "users",
+			Direction: direction.Up,
+			Content:   []byte(`[{"CreateTable": {"TableName": "users", "BillingMode": "PAY_PER_REQUEST"}}, {"CreateTable": {"TableName": "events"}}]`),
+		},
+		{
+			FileName:  "002_fails.up.json",
+			Version:   2,
+			Name:      "fails",
+			Direction: direction.Up,
+			Content:   []byte(`[{"CreateTable": {"TableName": "fails"}}]`),
+		},
+		{
+			FileName:  "001_users.down.json",
+			Version:   1,
+			Name:      "users",
+			Direction: direction.Down,
+			Content:   []byte(`[{"DeleteTable": {"TableName": "events"}}, {"DeleteTable": {"TableName": "users"}}]`),
+		},
+	}
+
+	pipe := pipep.New()
+	go d.Migrate("", files[0], pipe)
+	progress := 0
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			t.Fatal(item)
+		case file.StatementProgress:
+			progress += 1
+		}
+	}
+	if progress != 2 || fake.tables["users"] != "ACTIVE" || fake.tables["events"] != "ACTIVE" {
+		t.Errorf("Expected both tables to be created and waited for, got %v progress, %v", progress, fake.tables)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate("", files[1], pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	merr, ok := errs[0].(*migrationerror.Error)
+	if !ok || merr.Code != "ValidationException" || !strings.HasPrefix(merr.Err.Error(), "Operation 1 of 1 (CreateTable fails) failed: ") {
+		t.Errorf("Expected a migration error with the exception as code, got %#v", errs[0])
+	}
+	if versions, err := d.ListVersions(""); err != nil || !reflect.DeepEqual(versions, []uint64{1}) {
+		t.Errorf("Expected only version 1 to be recorded, got %v, %v", versions, err)
+	}
+	if version, err := d.Version("tenant"); err != nil || version != 0 {
+		t.Errorf("Expected version 0 of another id, got %v, %v", version, err)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate("", files[2], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, ok := fake.tables["users"]; ok {
+		t.Errorf("Expected users to be deleted, got %v", fake.tables)
+	}
+	if version, err := d.Version(""); err != nil || version != 0 {
+		t.Errorf("Expected version 0 after down, got %v, %v", version, err)
+	}
+
+	if err := d.Initialize(Credentials{AccessKeyId: "other", SecretAccessKey: "secret"}, "dynamodb://us-east-1/?endpoint="+server.URL); err == nil {
+		t.Error("Expected error for invalid credentials")
+	}
+	for _, url := range []string{"dynamodb:///", "dynamodb://us-east-1/table", "dynamodb://us-east-1/?endpoint=localhost:8000", "dynamodb://us-east-1/?metadata_table=a"} {
+		if err := d.Initialize(Credentials{AccessKeyId: "AKID"}, url); err == nil {
+			t.Errorf("Expected error for %v", url)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package mock implements the Driver interface in memory, so that code
+// using a Migrator can be tested without a database.
+package mock
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+)
+
+// Database holds the applied versions of every id in memory.
+type Database struct {
+	mu       sync.Mutex
+	versions map[string]map[uint64]bool
+
+	// ids whose current version is dirty
+	dirty map[string]bool
+}
+
+// NewDatabase returns an empty database, to be passed as instance.
+func NewDatabase() *Database {
+	return &Database{versions: make(map[string]map[uint64]bool), dirty: make(map[string]bool)}
+}
+
+var (
+	mu        sync.Mutex
+	databases = make(map[string]*Database)
+)
+
+// Open returns the database of mock://name urls, creating it if needed.
+// Drivers with the same name share it, like connections to a server.
+func Open(name string) *Database {
+	mu.Lock()
+	defer mu.Unlock()
+	db, ok := databases[name]
+	if !ok {
+		db = NewDatabase()
+		databases[name] = db
+	}
+	return db
+}
+
+// Reset drops the databases of all names, e.g. between tests.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	databases = make(map[string]*Database)
+}
+
+// Versions returns the applied versions of id in ascending order.
+func (db *Database) Versions(id string) []uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	versions := make([]uint64, 0, len(db.versions[id]))
+	for v := range db.versions[id] {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// Dirty reports whether the current version of id is dirty, i.e. a
+// migration stopped halfway.
+func (db *Database) Dirty(id string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.dirty[id]
+}
+
+// setDirty sets or clears the dirty marker of id.
+func (db *Database) setDirty(id string, dirty bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if dirty {
+		db.dirty[id] = true
+	} else {
+		delete(db.dirty, id)
+	}
+}
+
+// set records (applied) or removes a version of id.
+func (db *Database) set(id string, version uint64, applied bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.versions[id] == nil {
+		db.versions[id] = make(map[uint64]bool)
+	}
+	if applied {
+		db.versions[id][version] = true
+	} else {
+		delete(db.versions[id], version)
+	}
+}
+
+type Driver struct {
+	db *Database
+
+	// migrations of this version fail, set by ?fail_on=
+	failOn uint64
+
+	// migrations of this version stop halfway, set by ?stop_on=
+	stopOn uint64
+}
+
+// Mock Driver URL format:
+// mock://name[?fail_on=version][&stop_on=version]
+//
+// Drivers of the same name share their versions, unless instance is a
+// *Database to use instead. Migrations of the version fail_on fail in
+// both directions without being recorded. Migrations of the version
+// stop_on fail as if the migrator died halfway: the version is left
+// dirty, up migrations recorded and down migrations not yet removed.
+func (driver *Driver) Initialize(instance interface{}, url string) error {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	for name, setting := range map[string]*uint64{"fail_on": &driver.failOn, "stop_on": &driver.stopOn} {
+		if v := u.Query().Get(name); v != "" {
+			version, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || version == 0 {
+				return fmt.Errorf("Invalid %s %q, expected a version.", name, v)
+			}
+			*setting = version
+		}
+	}
+
+	switch instance := instance.(type) {
+	case nil:
+		driver.db = Open(u.Host)
+	case *Database:
+		driver.db = instance
+	default:
+		return fmt.Errorf("Expected instance of *mock.Database, got %#v", instance)
+	}
+	return nil
+}
+
+func (driver *Driver) Close() error {
+	return nil
+}
+
+func (driver *Driver) FilenameExtension() string {
+	return "sql"
+}
+
+// Migrate reads the content of the file and records its version,
+// nothing is executed. The version is marked dirty while the file runs.
+func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	if f.Direction != direction.Up && f.Direction != direction.Down {
+		pipe <- errors.New("Unsupported direction.Direction Type")
+		return
+	}
+	start := time.Now()
+	if f.Direction == direction.Up {
+		driver.db.set(id, f.Version, true)
+	}
+	driver.db.setDirty(id, true)
+	if err := driver.execute(f); err != nil {
+		if driver.stopOn == 0 || f.Version != driver.stopOn {
+			// as if rolled back
+			if f.Direction == direction.Up {
+				driver.db.set(id, f.Version, false)
+			}
+			driver.db.setDirty(id, false)
+		}
+		pipe <- err
+		return
+	}
+	if f.Direction == direction.Down {
+		driver.db.set(id, f.Version, false)
+	}
+	driver.db.setDirty(id, false)
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// Execute reads the content of the file without recording its version.
+func (driver *Driver) Execute(f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	start := time.Now()
+	if err := driver.execute(f); err != nil {
+		pipe <- err
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// execute reads the content of f and fails if its version is fail_on
+// or stop_on.
+func (driver *Driver) execute(f file.File) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	if driver.failOn != 0 && f.Version == driver.failOn {
+		return migrationerror.New(f, "", fmt.Errorf("Version %v fails, as configured by fail_on.", f.Version))
+	}
+	if driver.stopOn != 0 && f.Version == driver.stopOn {
+		return migrationerror.New(f, "", fmt.Errorf("Version %v stopped halfway, as configured by stop_on.", f.Version))
+	}
+	return nil
+}
+
+func (driver *Driver) Version(id string) (uint64, error) {
+	versions := driver.db.Versions(id)
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1], nil
+}
+
+// ListVersions returns the applied versions of id in ascending order.
+func (driver *Driver) ListVersions(id string) ([]uint64, error) {
+	return driver.db.Versions(id), nil
+}
+
+// IdVersions returns the current version of every id with applied versions.
+func (driver *Driver) IdVersions() (map[string]uint64, error) {
+	driver.db.mu.Lock()
+	ids := make([]string, 0, len(driver.db.versions))
+	for id := range driver.db.versions {
+		ids = append(ids, id)
+	}
+	driver.db.mu.Unlock()
+
+	versions := make(map[string]uint64)
+	for _, id := range ids {
+		if version, _ := driver.Version(id); version > 0 {
+			versions[id] = version
+		}
+	}
+	return versions, nil
+}
+
+// Dirty reports whether the current version of id is dirty.
+func (driver *Driver) Dirty(id string) (bool, error) {
+	return driver.db.Dirty(id), nil
+}
+
+// ForceVersion removes the versions of id above version and records
+// version, unless it is 0. The dirty marker is cleared.
+func (driver *Driver) ForceVersion(id string, version uint64) error {
+	driver.db.setDirty(id, false)
+	for _, v := range driver.db.Versions(id) {
+		if v > version {
+			driver.db.set(id, v, false)
+		}
+	}
+	if version > 0 {
+		driver.db.set(id, version, true)
+	}
+	return nil
+}
+
+// Baseline records versions as applied.
+func (driver *Driver) Baseline(id string, versions []uint64) error {
+	for _, v := range versions {
+		driver.db.set(id, v, true)
+	}
+	return nil
+}
+
+// SupportsTransactions is true, since a failed migration is not
+// recorded, as if it was rolled back.
+func (driver *Driver) SupportsTransactions() bool {
+	return true
+}
+
+func (driver *Driver) SupportsLocking() bool {
+	return false
+}
+
+func (driver *Driver) SupportsVersionListing() bool {
+	return true
+}
+
+func (driver *Driver) SupportsDirtyState() bool {
+	return true
+}
+
+func (driver *Driver) SupportsMultiStatement() bool {
+	return true
+}
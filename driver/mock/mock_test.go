@@ -0,0 +1,121 @@
+package mock
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+func TestMigrate(t *testing.T) {
+	defer Reset()
+
+	d := &Driver{}
+	if err := d.Initialize(nil, "mock://test?fail_on=2"); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	files := []file.File{
+		{FileName: "001_foo.up.sql", Version: 1, Name: "foo", Direction: direction.Up, Content: []byte("CREATE TABLE foo;")},
+		{FileName: "002_bar.up.sql", Version: 2, Name: "bar", Direction: direction.Up, Content: []byte("CREATE TABLE bar;")},
+		{FileName: "001_foo.down.sql", Version: 1, Name: "foo", Direction: direction.Down, Content: []byte("DROP TABLE foo;")},
+	}
+
+	pipe := pipep.New()
+	go d.Migrate("tenant1", files[0], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version("tenant1"); err != nil || version != 1 {
+		t.Errorf("Expected version 1, got %v, %v", version, err)
+	}
+	if version, err := d.Version(""); err != nil || version != 0 {
+		t.Errorf("Expected version 0 of another id, got %v, %v", version, err)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate("tenant1", files[1], pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if merr, ok := errs[0].(*migrationerror.Error); !ok || merr.File.Version != 2 {
+		t.Errorf("Expected a migration error of version 2, got %#v", errs[0])
+	}
+	if versions, _ := d.ListVersions("tenant1"); !reflect.DeepEqual(versions, []uint64{1}) {
+		t.Errorf("Expected the failed version not to be recorded, got %v", versions)
+	}
+
+	other := &Driver{}
+	if err := other.Initialize(nil, "mock://test"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := other.IdVersions(); !reflect.DeepEqual(versions, map[string]uint64{"tenant1": 1}) {
+		t.Errorf("Expected drivers of the same name to share versions, got %v", versions)
+	}
+	if !reflect.DeepEqual(Open("other").Versions("tenant1"), []uint64{}) {
+		t.Error("Expected drivers of another name not to share versions")
+	}
+
+	pipe = pipep.New()
+	go other.Migrate("tenant1", files[2], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, _ := d.Version("tenant1"); version != 0 {
+		t.Errorf("Expected version 0 after down, got %v", version)
+	}
+
+	db := NewDatabase()
+	if err := d.Initialize(db, "mock://"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Baseline("", []uint64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ForceVersion("", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(db.Versions(""), []uint64{1, 2}) {
+		t.Errorf("Expected versions 1 and 2 in the instance, got %v", db.Versions(""))
+	}
+
+	if err := d.Initialize(nil, "mock://test?fail_on=never"); err == nil {
+		t.Error("Expected error for an invalid fail_on")
+	}
+}
+
+func TestDirty(t *testing.T) {
+	db := NewDatabase()
+	d := &Driver{}
+	if err := d.Initialize(db, "mock://?stop_on=2"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []file.File{
+		{FileName: "001_foo.up.sql", Version: 1, Name: "foo", Direction: direction.Up, Content: []byte("CREATE TABLE foo;")},
+		{FileName: "002_bar.up.sql", Version: 2, Name: "bar", Direction: direction.Up, Content: []byte("CREATE TABLE bar;")},
+	}
+	for _, f := range files {
+		pipe := pipep.New()
+		go d.Migrate("", f, pipe)
+		pipep.ReadErrors(pipe)
+	}
+	if version, _ := d.Version(""); version != 2 {
+		t.Errorf("Expected the stopped version 2 to be recorded, got %v", version)
+	}
+	if dirty, err := d.Dirty(""); err != nil || !dirty {
+		t.Errorf("Expected version 2 to be dirty, got %v, %v", dirty, err)
+	}
+
+	if err := d.ForceVersion("", 1); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, _ := d.Dirty(""); dirty || !reflect.DeepEqual(db.Versions(""), []uint64{1}) {
+		t.Errorf("Expected version 1 without dirty marker, got %v, %v", db.Versions(""), dirty)
+	}
+}
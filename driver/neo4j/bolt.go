@@ -0,0 +1,232 @@
+package neo4j
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Bolt message signatures
+const (
+	msgHello    = 0x01
+	msgGoodbye  = 0x02
+	msgReset    = 0x0F
+	msgRun      = 0x10
+	msgBegin    = 0x11
+	msgCommit   = 0x12
+	msgRollback = 0x13
+	msgPull     = 0x3F
+
+	msgSuccess = 0x70
+	msgRecord  = 0x71
+	msgIgnored = 0x7E
+	msgFailure = 0x7F
+)
+
+// boltMagic starts the handshake
+var boltMagic = []byte{0x60, 0x60, 0xB0, 0x17}
+
+// boltVersions are the versions offered in the handshake: 4.4 down to
+// 4.0 for servers that understand ranges, else 4.2, 4.1 or 4.0.
+var boltVersions = []byte{
+	0x00, 0x04, 0x04, 0x04,
+	0x00, 0x00, 0x02, 0x04,
+	0x00, 0x00, 0x01, 0x04,
+	0x00, 0x00, 0x00, 0x04,
+}
+
+// conn speaks just enough of Bolt 4 to run queries one at a time.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// neo4jError is a FAILURE of the server, e.g. for a syntax error.
+type neo4jError struct {
+	Code    string
+	Message string
+}
+
+func (e *neo4jError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+const dialTimeout = 10 * time.Second
+
+// dial connects to addr, with TLS if useTLS is set, and negotiates the
+// Bolt version.
+func dial(addr string, useTLS bool) (*conn, error) {
+	var c net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		c, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		c, err = net.DialTimeout("tcp", addr, dialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bc := newConn(c)
+	if err := bc.handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+func (c *conn) handshake() error {
+	c.w.Write(boltMagic)
+	c.w.Write(boltVersions)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	version := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, version); err != nil {
+		return err
+	}
+	if version[3] != 4 {
+		return errors.New("The server doesn't support Bolt 4, which requires Neo4j 4.0 or later.")
+	}
+	return nil
+}
+
+// hello authenticates with basic auth, or none if user is empty.
+func (c *conn) hello(user, password string) error {
+	extra := map[string]interface{}{"user_agent": "migrate/1", "scheme": "none"}
+	if user != "" {
+		extra["scheme"] = "basic"
+		extra["principal"] = user
+		extra["credentials"] = password
+	}
+	_, _, err := c.request(msgHello, extra)
+	return err
+}
+
+// Close says goodbye and closes the connection.
+func (c *conn) Close() error {
+	c.send(msgGoodbye)
+	return c.c.Close()
+}
+
+// send writes a message in chunks of at most 65535 bytes, terminated by
+// an empty chunk.
+func (c *conn) send(signature byte, fields ...interface{}) error {
+	var buf bytes.Buffer
+	if err := pack(&buf, structure{signature: signature, fields: fields}); err != nil {
+		return err
+	}
+	for b := buf.Bytes(); len(b) > 0; {
+		n := len(b)
+		if n > 0xFFFF {
+			n = 0xFFFF
+		}
+		binary.Write(c.w, binary.BigEndian, uint16(n))
+		c.w.Write(b[:n])
+		b = b[n:]
+	}
+	c.w.Write([]byte{0x00, 0x00})
+	return c.w.Flush()
+}
+
+// receive reads a message from its chunks.
+func (c *conn) receive() (structure, error) {
+	var buf bytes.Buffer
+	for {
+		var n uint16
+		if err := binary.Read(c.r, binary.BigEndian, &n); err != nil {
+			return structure{}, err
+		}
+		if n == 0 {
+			if buf.Len() == 0 {
+				// a keep-alive between messages
+				continue
+			}
+			break
+		}
+		if _, err := io.CopyN(&buf, c.r, int64(n)); err != nil {
+			return structure{}, err
+		}
+	}
+	v, err := unpack(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return structure{}, err
+	}
+	msg, ok := v.(structure)
+	if !ok {
+		return structure{}, fmt.Errorf("Unexpected Bolt message %#v.", v)
+	}
+	return msg, nil
+}
+
+// request sends a message and reads the response: the metadata of its
+// SUCCESS and the fields of the RECORDs before it. A FAILURE is returned
+// as *neo4jError; the connection has to be reset afterwards.
+func (c *conn) request(signature byte, fields ...interface{}) (map[string]interface{}, [][]interface{}, error) {
+	if err := c.send(signature, fields...); err != nil {
+		return nil, nil, err
+	}
+	records := make([][]interface{}, 0)
+	for {
+		msg, err := c.receive()
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata, _ := firstField(msg).(map[string]interface{})
+		switch msg.signature {
+		case msgRecord:
+			record, _ := firstField(msg).([]interface{})
+			records = append(records, record)
+		case msgSuccess:
+			return metadata, records, nil
+		case msgFailure:
+			code, _ := metadata["code"].(string)
+			message, _ := metadata["message"].(string)
+			return nil, nil, &neo4jError{Code: code, Message: message}
+		case msgIgnored:
+			return nil, nil, errors.New("The server ignored the request after an earlier failure.")
+		default:
+			return nil, nil, fmt.Errorf("Unexpected Bolt message %#x.", msg.signature)
+		}
+	}
+}
+
+func firstField(msg structure) interface{} {
+	if len(msg.fields) == 0 {
+		return nil
+	}
+	return msg.fields[0]
+}
+
+// run runs query with params and returns its records. extra is the
+// metadata of the RUN, e.g. the database outside of a transaction.
+func (c *conn) run(query string, params, extra map[string]interface{}) ([][]interface{}, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if extra == nil {
+		extra = map[string]interface{}{}
+	}
+	if _, _, err := c.request(msgRun, query, params, extra); err != nil {
+		return nil, err
+	}
+	_, records, err := c.request(msgPull, map[string]interface{}{"n": int64(-1)})
+	return records, err
+}
+
+// reset clears a failure, rolling back the open transaction if any.
+func (c *conn) reset() error {
+	_, _, err := c.request(msgReset)
+	return err
+}
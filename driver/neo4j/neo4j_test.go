@@ -0,0 +1,304 @@
+package neo4j
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+func TestPack(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(-16), int64(127), int64(-17), int64(-128), int64(128), int64(-32768),
+		int64(32768), int64(-2147483649), int64(20060102150405),
+		1.5, "", "version", strings.Repeat("x", 300), strings.Repeat("y", 70000),
+		[]interface{}{int64(1), "a", nil},
+		map[string]interface{}{"n": int64(-1), "db": "neo4j"},
+		structure{signature: msgRun, fields: []interface{}{"RETURN 1", map[string]interface{}{}}},
+	}
+	for _, v := range values {
+		var buf bytes.Buffer
+		if err := pack(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+		unpacked, err := unpack(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Unable to unpack %#v: %v", v, err)
+		}
+		if !reflect.DeepEqual(unpacked, v) {
+			t.Errorf("Expected %#v, got %#v", v, unpacked)
+		}
+	}
+
+	var buf bytes.Buffer
+	pack(&buf, int64(-1))
+	if !bytes.Equal(buf.Bytes(), []byte{0xFF}) {
+		t.Errorf("Expected -1 to be a tiny int, got % x", buf.Bytes())
+	}
+	if _, err := unpack(bytes.NewReader([]byte{0xA1, 0x01, 0x01})); err == nil {
+		t.Error("Expected error for a map key that is not a string")
+	}
+	if _, err := unpack(bytes.NewReader([]byte{0xD0, 0x05, 'a'})); err == nil {
+		t.Error("Expected error for a truncated string")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	content := "// users\nCREATE (u:User {name: 'a;b'});\n" +
+		"MATCH (u:User) SET u.note = \"it's \\\";\";\n" +
+		"/* a; comment */ MATCH (`odd;label`) RETURN 1\n" +
+		";\n// only a comment;\n"
+	statements := splitStatements(content)
+	queries := make([]string, 0)
+	for _, s := range statements {
+		queries = append(queries, s.Query)
+	}
+	expect := []string{
+		"// users\nCREATE (u:User {name: 'a;b'})",
+		"MATCH (u:User) SET u.note = \"it's \\\";\"",
+		"/* a; comment */ MATCH (`odd;label`) RETURN 1",
+	}
+	if !reflect.DeepEqual(queries, expect) {
+		t.Errorf("Expected %q, got %q", expect, queries)
+	}
+	if line, _ := file.LineColumnFromOffset([]byte(content), statements[1].Offset); line != 3 {
+		t.Errorf("Expected the second statement in line 3, got %v", line)
+	}
+}
+
+func TestCheckTransactionType(t *testing.T) {
+	var tests = []struct {
+		content   string
+		expectErr bool
+	}{
+		{"CREATE CONSTRAINT user_id FOR (u:User) REQUIRE u.id IS UNIQUE;\nCREATE INDEX user_name FOR (u:User) ON (u.name);", false},
+		{"CREATE (u:User {id: 1});\nMATCH (u:User) SET u.name = 'a';", false},
+		{"DROP INDEX user_name;\n// backfill\nMATCH (u:User) SET u.name = 'a';", true},
+		{"CREATE OR REPLACE FULLTEXT INDEX titles FOR (n:Post) ON EACH [n.title];\nCREATE (u:User);", true},
+	}
+	for _, test := range tests {
+		f := file.File{FileName: "001_foo.up.cypher", Content: []byte(test.content)}
+		err := checkTransactionType(f, splitStatements(test.content))
+		if (err != nil) != test.expectErr {
+			t.Errorf("Expected error for %q: %v, got %v", test.content, test.expectErr, err)
+		}
+	}
+}
+
+// fakeServer answers Bolt requests like Neo4j would for the queries of
+// the driver, keeping the versions of :SchemaMigration nodes in memory.
+// Queries containing "fails" fail with a syntax error, transactions
+// mixing schema and data statements fail like in Neo4j.
+type fakeServer struct {
+	mu       sync.Mutex
+	versions map[string][]interface{}
+}
+
+func (s *fakeServer) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *fakeServer) serveConn(nc net.Conn) {
+	defer nc.Close()
+	handshake := make([]byte, 20)
+	if _, err := io.ReadFull(nc, handshake); err != nil || !bytes.Equal(handshake[:4], boltMagic) {
+		return
+	}
+	nc.Write([]byte{0x00, 0x00, 0x04, 0x04})
+
+	c := newConn(nc)
+	failed := false
+	var tx map[string][]interface{}
+	var txSchema, txData bool
+	var records [][]interface{}
+	for {
+		msg, err := c.receive()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		success := map[string]interface{}{}
+		if failed && msg.signature != msgReset {
+			c.send(msgIgnored)
+			s.mu.Unlock()
+			continue
+		}
+		switch msg.signature {
+		case msgHello:
+			extra := msg.fields[0].(map[string]interface{})
+			if extra["principal"] != "neo4j" || extra["credentials"] != "secret" {
+				c.send(msgFailure, map[string]interface{}{"code": "Neo.ClientError.Security.Unauthorized", "message": "The client is unauthorized due to authentication failure."})
+				s.mu.Unlock()
+				return
+			}
+		case msgBegin:
+			tx = make(map[string][]interface{})
+			txSchema, txData = false, false
+			for id, versions := range s.versions {
+				tx[id] = versions
+			}
+		case msgRun:
+			query := msg.fields[0].(string)
+			params := msg.fields[1].(map[string]interface{})
+			versions := s.versions
+			if tx != nil {
+				versions = tx
+				if isSchemaStatement(statement{Query: query}) {
+					txSchema = true
+				} else {
+					txData = true
+				}
+			}
+			id, _ := params["id"].(string)
+			switch {
+			case strings.Contains(query, "fails"):
+				failed = true
+				c.send(msgFailure, map[string]interface{}{"code": "Neo.ClientError.Statement.SyntaxError", "message": "Invalid input 'fails'"})
+				s.mu.Unlock()
+				continue
+			case txSchema && txData:
+				failed = true
+				c.send(msgFailure, map[string]interface{}{"code": forbiddenDueToTransactionType, "message": "Tried to execute Write query after executing Schema modification"})
+				s.mu.Unlock()
+				continue
+			case strings.HasPrefix(query, "MERGE (m:SchemaMigration"):
+				kept := make([]interface{}, 0)
+				for _, v := range versions[id] {
+					if v != params["version"] {
+						kept = append(kept, v)
+					}
+				}
+				if strings.Contains(query, "+ $version") {
+					kept = append(kept, params["version"])
+				}
+				versions[id] = kept
+			case strings.HasPrefix(query, "MATCH (m:SchemaMigration"):
+				if v, ok := versions[id]; ok {
+					records = append(records, []interface{}{v})
+				}
+			}
+		case msgPull:
+			for _, record := range records {
+				c.send(msgRecord, record)
+			}
+			records = nil
+		case msgCommit:
+			s.versions = tx
+			tx = nil
+		case msgReset:
+			failed = false
+			tx = nil
+		case msgGoodbye:
+			s.mu.Unlock()
+			return
+		}
+		c.send(msgSuccess, success)
+		s.mu.Unlock()
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	server := &fakeServer{versions: make(map[string][]interface{})}
+	go server.serve(l)
+
+	d := &Driver{}
+	if err := d.Initialize(nil, "neo4j://neo4j:secret@"+l.Addr().String()+"?database=graph"); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	files := []file.File{
+		{
+			FileName:  "001_users.up.cypher",
+			Version:   1,
+			Name:      "users",
+			Direction: direction.Up,
+			Content:   []byte("CREATE (u:User {name: 'a'});\nCREATE (u:User {name: 'b'});"),
+		},
+		{
+			FileName:  "002_index.up.cypher",
+			Version:   2,
+			Name:      "index",
+			Direction: direction.Up,
+			Content:   []byte("CREATE INDEX user_name FOR (u:User) ON (u.name);"),
+		},
+		{
+			FileName:  "003_fails.up.cypher",
+			Version:   3,
+			Name:      "fails",
+			Direction: direction.Up,
+			Content:   []byte("CREATE (u:User);\n\nMATCH fails;"),
+		},
+		{
+			FileName:  "002_index.down.cypher",
+			Version:   2,
+			Name:      "index",
+			Direction: direction.Down,
+			Content:   []byte("DROP INDEX user_name;"),
+		},
+	}
+
+	for _, f := range files[:2] {
+		pipe := pipep.New()
+		go d.Migrate("", f, pipe)
+		if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	if versions, err := d.ListVersions(""); err != nil || !reflect.DeepEqual(versions, []uint64{1, 2}) {
+		t.Errorf("Expected versions 1 and 2, got %v, %v", versions, err)
+	}
+
+	pipe := pipep.New()
+	go d.Migrate("", files[2], pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	merr, ok := errs[0].(*migrationerror.Error)
+	if !ok || merr.Code != "Neo.ClientError.Statement.SyntaxError" || !strings.Contains(merr.Error(), "starting in line 3") {
+		t.Errorf("Expected a syntax error in line 3, got %#v", errs[0])
+	}
+	if version, err := d.Version(""); err != nil || version != 2 {
+		t.Errorf("Expected version 2 after the failure, got %v, %v", version, err)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate("", files[3], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version(""); err != nil || version != 1 {
+		t.Errorf("Expected version 1 after down, got %v, %v", version, err)
+	}
+	if version, err := d.Version("tenant"); err != nil || version != 0 {
+		t.Errorf("Expected version 0 of another id, got %v, %v", version, err)
+	}
+
+	if err := (&Driver{}).Initialize(nil, "neo4j://neo4j:wrong@"+l.Addr().String()); err == nil {
+		t.Error("Expected error for invalid credentials")
+	}
+	if err := (&Driver{}).Initialize(nil, "neo4j://"+l.Addr().String()+"/graph"); err == nil {
+		t.Error("Expected error for a database in the path")
+	}
+}
@@ -0,0 +1,256 @@
+package neo4j
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// structure is a PackStream structure, e.g. a Bolt message or a node.
+type structure struct {
+	signature byte
+	fields    []interface{}
+}
+
+// pack appends the PackStream encoding of v to buf. Values are nil,
+// bools, ints, int64s, float64s, strings, []interface{},
+// map[string]interface{} and structures.
+func pack(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xC0)
+	case bool:
+		if v {
+			buf.WriteByte(0xC3)
+		} else {
+			buf.WriteByte(0xC2)
+		}
+	case int:
+		packInt(buf, int64(v))
+	case int64:
+		packInt(buf, v)
+	case float64:
+		buf.WriteByte(0xC1)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		packHeader(buf, len(v), 0x80, 0xD0)
+		buf.WriteString(v)
+	case []interface{}:
+		packHeader(buf, len(v), 0x90, 0xD4)
+		for _, item := range v {
+			if err := pack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		packHeader(buf, len(v), 0xA0, 0xD8)
+		for key, value := range v {
+			pack(buf, key)
+			if err := pack(buf, value); err != nil {
+				return err
+			}
+		}
+	case structure:
+		if len(v.fields) > 15 {
+			return fmt.Errorf("Structure %#x has too many fields.", v.signature)
+		}
+		buf.WriteByte(0xB0 | byte(len(v.fields)))
+		buf.WriteByte(v.signature)
+		for _, field := range v.fields {
+			if err := pack(buf, field); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unable to pack %#v.", v)
+	}
+	return nil
+}
+
+func packInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= -16 && v <= 127:
+		buf.WriteByte(byte(int8(v)))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		buf.WriteByte(0xC8)
+		buf.WriteByte(byte(int8(v)))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		buf.WriteByte(0xC9)
+		binary.Write(buf, binary.BigEndian, int16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		buf.WriteByte(0xCA)
+		binary.Write(buf, binary.BigEndian, int32(v))
+	default:
+		buf.WriteByte(0xCB)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// packHeader writes the marker of a string, list or map of size n:
+// tiny|n below 16, else the 8, 16 or 32 bit marker following marker8.
+func packHeader(buf *bytes.Buffer, n int, tiny, marker8 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(tiny | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(marker8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(marker8 + 1)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(marker8 + 2)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// unpack reads a PackStream value. Ints are returned as int64, bytes as
+// []byte, lists as []interface{} and maps as map[string]interface{}.
+func unpack(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case marker <= 0x7F || marker >= 0xF0:
+		return int64(int8(marker)), nil
+	case marker&0xF0 == 0x80:
+		return unpackString(r, int(marker&0x0F))
+	case marker&0xF0 == 0x90:
+		return unpackList(r, int(marker&0x0F))
+	case marker&0xF0 == 0xA0:
+		return unpackMap(r, int(marker&0x0F))
+	case marker&0xF0 == 0xB0:
+		signature, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		fields, err := unpackList(r, int(marker&0x0F))
+		if err != nil {
+			return nil, err
+		}
+		return structure{signature: signature, fields: fields.([]interface{})}, nil
+	}
+
+	switch marker {
+	case 0xC0:
+		return nil, nil
+	case 0xC1:
+		var bits uint64
+		err := binary.Read(r, binary.BigEndian, &bits)
+		return math.Float64frombits(bits), err
+	case 0xC2:
+		return false, nil
+	case 0xC3:
+		return true, nil
+	case 0xC8:
+		var v int8
+		err := binary.Read(r, binary.BigEndian, &v)
+		return int64(v), err
+	case 0xC9:
+		var v int16
+		err := binary.Read(r, binary.BigEndian, &v)
+		return int64(v), err
+	case 0xCA:
+		var v int32
+		err := binary.Read(r, binary.BigEndian, &v)
+		return int64(v), err
+	case 0xCB:
+		var v int64
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	case 0xCC, 0xCD, 0xCE:
+		n, err := unpackSize(r, marker-0xCC)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	case 0xD0, 0xD1, 0xD2:
+		n, err := unpackSize(r, marker-0xD0)
+		if err != nil {
+			return nil, err
+		}
+		return unpackString(r, n)
+	case 0xD4, 0xD5, 0xD6:
+		n, err := unpackSize(r, marker-0xD4)
+		if err != nil {
+			return nil, err
+		}
+		return unpackList(r, n)
+	case 0xD8, 0xD9, 0xDA:
+		n, err := unpackSize(r, marker-0xD8)
+		if err != nil {
+			return nil, err
+		}
+		return unpackMap(r, n)
+	}
+	return nil, fmt.Errorf("Unknown PackStream marker %#x.", marker)
+}
+
+// unpackSize reads a size of 8 (width 0), 16 (1) or 32 (2) bits.
+func unpackSize(r *bytes.Reader, width byte) (int, error) {
+	switch width {
+	case 0:
+		n, err := r.ReadByte()
+		return int(n), err
+	case 1:
+		var n uint16
+		err := binary.Read(r, binary.BigEndian, &n)
+		return int(n), err
+	default:
+		var n uint32
+		err := binary.Read(r, binary.BigEndian, &n)
+		return int(n), err
+	}
+}
+
+func unpackString(r *bytes.Reader, n int) (interface{}, error) {
+	if n > r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func unpackList(r *bytes.Reader, n int) (interface{}, error) {
+	if n > r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	list := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := unpack(r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func unpackMap(r *bytes.Reader, n int) (interface{}, error) {
+	if n > r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := unpack(r)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("Unexpected map key %#v.", key)
+		}
+		if m[k], err = unpack(r); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,76 @@
+package neo4j
+
+import (
+	"regexp"
+	"strings"
+)
+
+// statement is a Cypher statement of a migration file and the offset of
+// its first character in the file.
+type statement struct {
+	Query  string
+	Offset int
+}
+
+// splitStatements splits content at semicolons outside of string
+// literals, quoted identifiers and comments. Statements consisting of
+// comments only are left out.
+func splitStatements(content string) []statement {
+	statements := make([]statement, 0)
+	start := 0
+	add := func(end int) {
+		query := content[start:end]
+		trimmed := strings.TrimLeft(query, " \t\r\n")
+		if stripComments(trimmed) != "" {
+			statements = append(statements, statement{Query: strings.TrimSpace(trimmed), Offset: start + len(query) - len(trimmed)})
+		}
+		start = end + 1
+	}
+
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			for i++; i < len(content) && content[i] != c; i++ {
+				if content[i] == '\\' && c != '`' {
+					i++
+				}
+			}
+		case c == '/' && strings.HasPrefix(content[i:], "//"):
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += end + 3
+			}
+		case c == ';':
+			add(i)
+		}
+	}
+	if start < len(content) {
+		add(len(content))
+	}
+	return statements
+}
+
+// leadingCommentsRegex matches the comments and white space a statement
+// starts with
+var leadingCommentsRegex = regexp.MustCompile(`^(?s)(?:\s*(?://[^\n]*|/\*.*?\*/))*\s*`)
+
+// stripComments returns query without its leading comments.
+func stripComments(query string) string {
+	return query[len(leadingCommentsRegex.FindString(query)):]
+}
+
+// schemaStatementRegex matches statements creating or dropping indexes
+// and constraints, which Neo4j can't run in a transaction that writes
+// data.
+var schemaStatementRegex = regexp.MustCompile(`(?i)^(CREATE|DROP)\s+(OR\s+REPLACE\s+)?((UNIQUE|FULLTEXT|LOOKUP|POINT|RANGE|TEXT|BTREE|VECTOR)\s+)?(CONSTRAINT|INDEX)\b`)
+
+// isSchemaStatement reports whether s changes indexes or constraints.
+func isSchemaStatement(s statement) bool {
+	return schemaStatementRegex.MatchString(stripComments(s.Query))
+}
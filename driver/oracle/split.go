@@ -0,0 +1,112 @@
+package oracle
+
+import (
+	"regexp"
+	"strings"
+)
+
+// statement is a SQL statement or PL/SQL block of a migration file.
+type statement struct {
+	Query string
+
+	// the line of the migration file the statement starts at, 1-based
+	Line int
+
+	// PL/SQL blocks keep their semicolons and end at a line of "/"
+	PLSQL bool
+}
+
+// plsqlRegex matches the start of PL/SQL blocks and of statements
+// creating stored code, which contain semicolons themselves.
+var plsqlRegex = regexp.MustCompile(`(?i)^(BEGIN|DECLARE|CREATE\s+(OR\s+REPLACE\s+)?((NON)?EDITIONABLE\s+)?(PROCEDURE|FUNCTION|PACKAGE|TRIGGER|TYPE|LIBRARY))\b`)
+
+// ddlRegex matches statements Oracle commits implicitly, together with
+// everything run before them in the transaction.
+var ddlRegex = regexp.MustCompile(`(?i)^(CREATE|ALTER|DROP|TRUNCATE|RENAME|GRANT|REVOKE|COMMENT|ANALYZE|AUDIT|NOAUDIT|PURGE|FLASHBACK)\b`)
+
+// leadingCommentsRegex matches the comments and white space a statement
+// starts with
+var leadingCommentsRegex = regexp.MustCompile(`^(?s)(?:\s*(?:--[^\n]*|/\*.*?\*/))*\s*`)
+
+// stripComments returns query without its leading comments.
+func stripComments(query string) string {
+	return query[len(leadingCommentsRegex.FindString(query)):]
+}
+
+// isDDL reports whether s is committed implicitly by Oracle.
+func isDDL(s statement) bool {
+	return ddlRegex.MatchString(stripComments(s.Query))
+}
+
+// splitStatements splits content like SQL*Plus does: SQL statements end
+// at a semicolon outside of literals and comments, which is dropped, as
+// Oracle doesn't accept it. PL/SQL blocks end at a line consisting of
+// "/". Statements consisting of comments only are left out.
+func splitStatements(content string) []statement {
+	statements := make([]statement, 0)
+	start, line, startLine := 0, 1, 1
+	add := func(end, next int) {
+		query := content[start:end]
+		trimmed := strings.TrimLeft(query, " \t\r\n")
+		startLine += strings.Count(query[:len(query)-len(trimmed)], "\n")
+		if stripComments(trimmed) != "" {
+			s := statement{Query: strings.TrimSpace(trimmed), Line: startLine}
+			s.PLSQL = plsqlRegex.MatchString(stripComments(s.Query))
+			statements = append(statements, s)
+		}
+		start, startLine = next, line
+	}
+	plsql := func() bool {
+		return plsqlRegex.MatchString(stripComments(strings.TrimLeft(content[start:], " \t\r\n")))
+	}
+
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\'' || c == '"':
+			for i++; i < len(content) && content[i] != c; i++ {
+				if content[i] == '\n' {
+					line++
+				}
+			}
+		case c == '-' && strings.HasPrefix(content[i:], "--"):
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			line++
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				end = len(content) - i - 2
+			}
+			line += strings.Count(content[i:i+2+end], "\n")
+			i += end + 3
+		case c == '/' && isSlashLine(content, i):
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				end = len(content) - i
+			}
+			add(i, i+end)
+			i += end - 1
+		case c == ';' && !plsql():
+			add(i, i+1)
+		case c == '\n':
+			line++
+		}
+	}
+	if start < len(content) {
+		add(len(content), len(content))
+	}
+	return statements
+}
+
+// isSlashLine reports whether the "/" at i is alone on its line.
+func isSlashLine(content string, i int) bool {
+	lineStart := strings.LastIndexByte(content[:i], '\n') + 1
+	lineEnd := strings.IndexByte(content[i:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(content)
+	} else {
+		lineEnd += i
+	}
+	return strings.TrimSpace(content[lineStart:lineEnd]) == "/"
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	sqldriver "database/sql/driver"
+	neturl "net/url"
+
+	"github.com/lib/pq"
+)
+
+// TokenProvider returns the password to use for a new connection.
+// It is called every time a connection is opened, so it can hand out
+// short-lived credentials, e.g. RDS or Cloud SQL IAM auth tokens.
+type TokenProvider func() (string, error)
+
+// tokenConnector opens connections to url using a fresh password
+// from a TokenProvider for each one.
+type tokenConnector struct {
+	url   string
+	token TokenProvider
+}
+
+func (c *tokenConnector) Connect(ctx context.Context) (sqldriver.Conn, error) {
+	password, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	u, err := neturl.Parse(c.url)
+	if err != nil {
+		return nil, err
+	}
+	username := ""
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	u.User = neturl.UserPassword(username, password)
+
+	connector, err := pq.NewConnector(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *tokenConnector) Driver() sqldriver.Driver {
+	return &pq.Driver{}
+}
@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+)
+
+const defaultAdvisoryLockTimeout = 15 * time.Minute
+
+// advisoryLockRetry is how often Lock retries while another session
+// holds the lock.
+var advisoryLockRetry = 500 * time.Millisecond
+
+// rowQueryer is implemented by *sql.Conn and *sql.Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Lock takes an advisory lock keyed off id, so that concurrent migrators
+// run one after another. The lock is session level and held on a
+// dedicated connection of the pool until Unlock is called; in a caller's
+// transaction it is transaction level and released when that ends.
+// Lock gives up after advisory_lock_timeout, or once the context set by
+// SetContext is done.
+func (driver *Driver) Lock(id string) error {
+	if driver.lockConn != nil {
+		return errors.New("Driver is locked already.")
+	}
+	if driver.tx != nil {
+		return driver.waitForLock(driver.tx, `SELECT pg_try_advisory_xact_lock($1)`, id)
+	}
+
+	conn, err := driver.db.Conn(driver.runContext())
+	if err != nil {
+		if cerr := lockCancelled(driver.runContext(), id); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	if err := driver.waitForLock(conn, `SELECT pg_try_advisory_lock($1)`, id); err != nil {
+		conn.Close()
+		return err
+	}
+	driver.lockConn = conn
+	return nil
+}
+
+// Unlock releases the lock taken by Lock. If that fails, e.g. because
+// the context set by SetContext is done, the connection is discarded
+// instead of going back to the pool, which ends the session and with it
+// the lock.
+func (driver *Driver) Unlock(id string) error {
+	if driver.lockConn == nil {
+		return nil
+	}
+	conn := driver.lockConn
+	driver.lockConn = nil
+	defer conn.Close()
+	_, err := conn.ExecContext(driver.runContext(), `SELECT pg_advisory_unlock($1)`, advisoryLockKey(driver.versionTable(), id))
+	if err != nil {
+		conn.Raw(func(interface{}) error {
+			return sqldriver.ErrBadConn
+		})
+		if driver.runContext().Err() != nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// waitForLock runs the try-lock query until it succeeds, advisory_lock_timeout
+// passed or the context set by SetContext is done.
+func (driver *Driver) waitForLock(q rowQueryer, query string, id string) error {
+	ctx := driver.runContext()
+	timeout := driver.lockTimeout
+	if timeout == 0 {
+		timeout = defaultAdvisoryLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := q.QueryRowContext(ctx, query, advisoryLockKey(driver.versionTable(), id)).Scan(&locked); err != nil {
+			if cerr := lockCancelled(ctx, id); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+		if locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Another migration is in progress: the advisory lock for id '%s' was not released within %v (advisory_lock_timeout).", id, timeout)
+		}
+		select {
+		case <-time.After(advisoryLockRetry):
+		case <-ctx.Done():
+			return lockCancelled(ctx, id)
+		}
+	}
+}
+
+// lockCancelled returns the migrationerror.Cancelled error of waiting
+// for the lock of id if ctx is done, nil otherwise.
+func lockCancelled(ctx context.Context, id string) error {
+	cerr := migrationerror.Cancelled(ctx, file.File{FileName: fmt.Sprintf("Waiting for the advisory lock for id '%s'", id)})
+	if cerr == nil {
+		return nil
+	}
+	// the error is not about a single file
+	cerr.File = nil
+	return cerr
+}
@@ -0,0 +1,212 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	"github.com/lib/pq"
+)
+
+// transactionDirectives can't be combined with parallel
+// execution since they only apply to a transaction.
+var transactionDirectives = []string{"isolation", "lock", "lock_timeout", "deadlock_timeout"}
+
+// migrateParallel runs the statements of a migration file declaring
+//
+// 	-- migrate:parallel N
+//
+// on up to N connections of their own at once, outside of a
+// transaction. This is
+// meant for backfills of many independent statements. It is not atomic:
+// if a statement fails, the ones not yet started are skipped, but those
+// that ran already stay applied. The version is marked dirty while the
+// file runs, so a failure leaves it dirty until it is forced.
+// It reports whether the file was applied.
+//
+// Files declaring
+//
+// 	-- migrate:transaction false
+//
+// run the same way on a single connection, i.e. one statement after
+// another in order, so that session state like SET carries over to the
+// following statements. Connections are reset before they go back to
+// the pool.
+func (driver *Driver) migrateParallel(id string, f file.File, pipe chan interface{}, bookkeeping bool) bool {
+	directive := "parallel"
+	n := 1
+	if value, ok := f.Options["parallel"]; ok {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 1 {
+			pipe <- fmt.Errorf("Invalid parallel directive '%s' in %s.", value, f.FileName)
+			return false
+		}
+	} else {
+		directive = "transaction false"
+	}
+	if driver.tx != nil {
+		pipe <- fmt.Errorf("The %s directive can't be used in the caller's transaction in %s.", directive, f.FileName)
+		return false
+	}
+	needed := n
+	if driver.lockConn != nil {
+		needed++
+	}
+	if max := driver.db.Stats().MaxOpenConnections; max > 0 && needed > max {
+		pipe <- fmt.Errorf("The %s directive needs %v connections, including the one of the advisory lock, but the pool allows only %v (x-max-open-conns) in %s.",
+			directive, needed, max, f.FileName)
+		return false
+	}
+	for _, name := range transactionDirectives {
+		if _, ok := f.Options[name]; ok {
+			pipe <- fmt.Errorf("The %s directive requires a transaction and can't be combined with %s in %s.", name, directive, f.FileName)
+			return false
+		}
+	}
+
+	skip, err := driver.skip(driver.db, f)
+	if err != nil {
+		pipe <- err
+		return false
+	}
+	if skip {
+		pipe <- fmt.Sprintf("Skipped %s, its skip-if condition is satisfied.", f.FileName)
+		if bookkeeping {
+			if err := driver.recordVersion(driver.db, id, f.Version, f.Direction, file.Checksum(f.Content)); err != nil {
+				pipe <- err
+				return false
+			}
+		}
+		return true
+	}
+
+	timeout, err := f.Timeout()
+	if err != nil {
+		pipe <- err
+		return false
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		// the timeout applies to the whole file, not to each statement
+		ctx, cancel = context.WithTimeout(driver.runContext(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(driver.runContext())
+	}
+	defer cancel()
+
+	if bookkeeping {
+		if err := driver.markDirty(id, f); err != nil {
+			pipe <- err
+			return false
+		}
+	}
+
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	statements := make(chan statement)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := driver.db.Conn(ctx)
+			if err != nil {
+				once.Do(func() {
+					firstErr = &migrationerror.Error{File: &f, Category: migrationerror.Connection, Err: err}
+					cancel()
+				})
+				return
+			}
+			defer releaseConn(conn)
+			if err := driver.setSessionTimeouts(ctx, conn, f, timeout == 0); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			for s := range statements {
+				err := driver.journal.Record(f, s.Query)
+				if err == nil {
+					_, err = conn.ExecContext(ctx, s.Query)
+				}
+				if err != nil {
+					once.Do(func() {
+						firstErr = queryError(f, err, s.Offset)
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, s := range splitStatements(string(f.Content)) {
+		select {
+		case statements <- s:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(statements)
+	wg.Wait()
+	if firstErr == nil {
+		// cancelled while no statement was running
+		if cerr := migrationerror.Cancelled(ctx, f); cerr != nil {
+			firstErr = cerr
+		}
+	}
+
+	if firstErr != nil {
+		if cerr := migrationerror.Cancelled(driver.runContext(), f); cerr != nil {
+			firstErr = cerr
+		}
+		pipe <- firstErr
+		return false
+	}
+	if bookkeeping {
+		if err := driver.clearDirty(id, f); err != nil {
+			pipe <- err
+			return false
+		}
+	}
+	return true
+}
+
+// setSessionTimeouts sets the lock_timeout and, unless statementTimeout
+// is false, the statement_timeout given in the url for the session of
+// conn, as files run outside of a transaction can't set them locally.
+// releaseConn resets them.
+func (driver *Driver) setSessionTimeouts(ctx context.Context, conn *sql.Conn, f file.File, statementTimeout bool) error {
+	settings := [][2]string{{"lock_timeout", driver.txLockTimeout}}
+	if statementTimeout {
+		settings = append(settings, [2]string{"statement_timeout", driver.txStatementTimeout})
+	}
+	for _, setting := range settings {
+		if setting[1] == "" {
+			continue
+		}
+		q := `SET ` + setting[0] + ` = ` + pq.QuoteLiteral(setting[1])
+		if err := driver.journal.Record(f, q); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseConn resets the session state a migration file may have
+// changed and returns conn to the pool.
+func releaseConn(conn *sql.Conn) {
+	// also once the context of the migration is done
+	conn.ExecContext(context.Background(), "RESET ALL")
+	conn.Close()
+}
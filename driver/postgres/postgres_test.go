@@ -1,12 +1,25 @@
 package postgres
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PlanitarInc/migrate/file"
 	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/journal"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
 	pipep "github.com/PlanitarInc/migrate/pipe"
+	"github.com/lib/pq"
 )
 
 // TestMigrate runs some additional tests on Migrate().
@@ -29,6 +42,8 @@ func TestMigrate(t *testing.T) {
 	if err := d.Initialize(nil, driverUrl); err != nil {
 		t.Fatal(err)
 	}
+	var journaled bytes.Buffer
+	d.SetJournal(journal.New(&journaled))
 
 	files := []file.File{
 		{
@@ -38,6 +53,8 @@ func TestMigrate(t *testing.T) {
 			Name:      "foobar",
 			Direction: direction.Up,
 			Content: []byte(`
+				-- migrate:isolation read committed
+				-- migrate:lock_timeout 5s
 				CREATE TABLE yolo (
 					id serial not null primary key
 				);
@@ -88,7 +105,991 @@ func TestMigrate(t *testing.T) {
 		t.Error("Expected test case to fail")
 	}
 
+	for _, expect := range []string{
+		"BEGIN ISOLATION LEVEL READ COMMITTED;",
+		"SELECT set_config('lock_timeout', '5s', true);",
+		"CREATE TABLE yolo",
+		"DROP TABLE yolo;",
+		"COMMIT;",
+		"THIS WILL CAUSE AN ERROR",
+		"ROLLBACK;",
+	} {
+		if !strings.Contains(journaled.String(), expect) {
+			t.Errorf("Expected journal to contain %q, got\n%s", expect, journaled.String())
+		}
+	}
+
 	if err := d.Close(); err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestTimestampVersion(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	// a version table of an older release, with an int version column
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS ` + tableName + `;
+				CREATE TABLE ` + tableName + ` (id text, version int not null, primary key (id, version));`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pipe := pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "20060102150405_foobar.up.sql",
+		Version:   20060102150405,
+		Direction: direction.Up,
+		Content:   []byte(`SELECT 1;`),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version("test"); err != nil || version != 20060102150405 {
+		t.Errorf("Expected version 20060102150405, got %v, %v", version, err)
+	}
+}
+
+func TestVersionTableUpToDate(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// an up to date version table is only read, DDL would fail
+	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := (&Driver{}).Initialize(tx, driverUrl); err != nil {
+		t.Errorf("Expected no DDL for an up to date version table, got %v", err)
+	}
+}
+
+func TestSetDBWithTokenProvider(t *testing.T) {
+	driverUrl := "postgres://user@localhost/migratetest?sslmode=disable"
+	tokenErr := errors.New("no token")
+	calls := 0
+
+	d := &Driver{}
+	err := d.setDB(func() (string, error) {
+		calls += 1
+		return "", tokenErr
+	}, driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.ownsDB {
+		t.Error("Expected driver to own the connection pool")
+	}
+
+	if err := d.db.Ping(); err != tokenErr {
+		t.Errorf("Expected token provider error, got %v", err)
+	}
+	if calls == 0 {
+		t.Error("Expected token provider to be called on connect")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Driver{}).setDB("nonsense", driverUrl); err == nil {
+		t.Error("Expected error for unsupported instance")
+	}
+}
+
+// fakeConnector opens connections that can't run anything, enough for
+// the pool to hand them out
+type fakeConnector struct{}
+
+func (c fakeConnector) Connect(ctx context.Context) (sqldriver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+func (c fakeConnector) Driver() sqldriver.Driver {
+	return nil
+}
+
+type fakeConn struct{}
+
+func (c fakeConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return nil, errors.New("Prepare is not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (sqldriver.Tx, error) {
+	return nil, errors.New("Begin is not supported")
+}
+
+func TestPoolSettings(t *testing.T) {
+	// takes a connection and returns it to the pool
+	useConn := func(db *sql.DB) {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	d := &Driver{}
+	url, err := d.setOptions("postgres://localhost/db?x-max-open-conns=5&x-max-idle-conns=0&sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.setDB(fakeConnector{}, url); err != nil {
+		t.Fatal(err)
+	}
+	useConn(d.db)
+	if stats := d.db.Stats(); stats.MaxOpenConnections != 5 || stats.Idle != 0 || stats.MaxIdleClosed != 1 {
+		t.Errorf("Expected at most 5 open and no idle connections, got %+v", stats)
+	}
+	d.Close()
+
+	d = &Driver{}
+	if url, err = d.setOptions("postgres://localhost/db?x-conn-max-lifetime=1ms"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.setDB(fakeConnector{}, url); err != nil {
+		t.Fatal(err)
+	}
+	useConn(d.db)
+	time.Sleep(10 * time.Millisecond)
+	useConn(d.db)
+	if stats := d.db.Stats(); stats.MaxOpenConnections != 0 || stats.MaxLifetimeClosed == 0 {
+		t.Errorf("Expected the connection to be closed after its lifetime, got %+v", stats)
+	}
+	d.Close()
+
+	// pools passed as instance are left as they are
+	db := sql.OpenDB(fakeConnector{})
+	defer db.Close()
+	d = &Driver{}
+	if url, err = d.setOptions("postgres://localhost/db?x-max-open-conns=5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.setDB(db, url); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.Stats(); stats.MaxOpenConnections != 0 {
+		t.Errorf("Expected the settings of the instance to be kept, got %+v", stats)
+	}
+}
+
+// pgError is an error like those of pgx, which have a SQLState method
+type pgError struct{ code string }
+
+func (e *pgError) Error() string    { return "ERROR: failed (SQLSTATE " + e.code + ")" }
+func (e *pgError) SQLState() string { return e.code }
+
+func TestParallelConnections(t *testing.T) {
+	d := &Driver{db: sql.OpenDB(fakeConnector{})}
+	defer d.db.Close()
+	d.db.SetMaxOpenConns(2)
+	conn, err := d.db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	d.lockConn = conn
+
+	// two workers and the lock don't fit into the pool, waiting for a
+	// connection would block forever
+	f := file.File{FileName: "001_foo.up.sql", Content: []byte("SELECT 1;"), Options: map[string]string{"parallel": "2"}}
+	pipe := pipep.New()
+	d.migrateParallel("", f, pipe, true)
+	close(pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) != 1 || !strings.Contains(errs[0].Error(), "needs 3 connections") {
+		t.Errorf("Expected an error for too few connections, got %v", errs)
+	}
+}
+
+func TestSQLState(t *testing.T) {
+	f := file.File{FileName: "001_foo.up.sql", Content: []byte("LOCK TABLE foo;")}
+
+	if code := sqlState(&pq.Error{Code: syntaxError}); code != syntaxError {
+		t.Errorf("Expected %v of a lib/pq error, got %q", syntaxError, code)
+	}
+	if code := sqlState(&pgError{code: undefinedColumn}); code != undefinedColumn {
+		t.Errorf("Expected %v of a pgx error, got %q", undefinedColumn, code)
+	}
+	if code := sqlState(errors.New("connection refused")); code != "" {
+		t.Errorf("Expected no SQLSTATE of other errors, got %q", code)
+	}
+
+	err := queryError(f, &pgError{code: lockNotAvailable}, 0)
+	if merr, ok := err.(*migrationerror.Error); !ok || merr.Code != lockNotAvailable || !strings.HasPrefix(merr.Error(), "Timed out waiting for a lock") {
+		t.Errorf("Expected a lock timeout of a pgx error, got %#v", err)
+	}
+}
+
+func TestIdVersions(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connection.Exec(`DROP TABLE IF EXISTS ` + tableName); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, v := range []struct {
+		id      string
+		version uint64
+	}{{"tenant_a", 1}, {"tenant_a", 2}, {"tenant_b", 1}} {
+		if err := d.recordVersion(d.db, v.id, v.version, direction.Up, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := d.IdVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions["tenant_a"] != 2 || versions["tenant_b"] != 1 {
+		t.Errorf("Unexpected versions %v", versions)
+	}
+}
+
+func TestCheckSyntax(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	valid := file.File{FileName: "001_foo.up.sql", Content: []byte(`
+		CREATE TABLE foo (id int);
+		INSERT INTO foo VALUES (1);
+	`)}
+	if err := d.CheckSyntax(valid); err != nil {
+		t.Errorf("Expected no error for a table that doesn't exist yet, got %v", err)
+	}
+
+	invalid := file.File{FileName: "002_foo.up.sql", Content: []byte(`
+		CREATE TABLE bar (id int);
+		CREATE TABEL baz (id int);
+	`)}
+	if err := d.CheckSyntax(invalid); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected syntax error in line 3, got %v", err)
+	}
+}
+
+func TestFillChecksum(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a version table from before checksums were recorded
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS ` + tableName + `;
+				CREATE TABLE ` + tableName + ` (id text, version int not null, primary key (id, version));
+				INSERT INTO ` + tableName + ` (id, version) VALUES ('test', 1);`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.recordVersion(d.db, "test", 2, direction.Up, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := d.Checksums("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 2 || checksums[1] != "" || checksums[2] != "abc" {
+		t.Fatalf("Unexpected checksums %v", checksums)
+	}
+
+	if ok, err := d.FillChecksum("test", 1, "def"); err != nil || !ok {
+		t.Errorf("Expected checksum of version 1 to be filled, got %v, %v", ok, err)
+	}
+	if ok, err := d.FillChecksum("test", 2, "def"); err != nil || ok {
+		t.Errorf("Expected checksum of version 2 not to be overwritten, got %v, %v", ok, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a version table from before the time of a version was recorded
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS ` + tableName + `;
+				CREATE TABLE ` + tableName + ` (id text, version int not null, checksum text, primary key (id, version));
+				INSERT INTO ` + tableName + ` (id, version) VALUES ('test', 2);`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{db: connection}
+	applied, err := d.History("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 2 || !applied[0].AppliedAt.IsZero() {
+		t.Fatalf("Unexpected history without applied_at column %v", applied)
+	}
+
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	before := time.Now().Add(-time.Minute)
+	if err := d.recordVersion(d.db, "test", 1, direction.Up, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ForceVersion("test", 3); err != nil {
+		t.Fatal(err)
+	}
+	applied, err = d.History("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 {
+		t.Fatalf("Expected 3 applied versions, got %v", applied)
+	}
+	if applied[0].Version != 2 || !applied[0].AppliedAt.IsZero() {
+		t.Errorf("Expected version 2 of unknown time first, got %v", applied[0])
+	}
+	for i, version := range []uint64{1, 3} {
+		a := applied[i+1]
+		if a.Version != version || a.AppliedAt.Before(before) {
+			t.Errorf("Expected version %v applied after %v, got %v", version, before, a)
+		}
+	}
+}
+
+func TestMigrateInCallersTx(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`DROP TABLE IF EXISTS yolo`); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := connection.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Driver{}
+	if err := d.Initialize(tx, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	pipe := pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Direction: direction.Up,
+		Content:   []byte(`CREATE TABLE yolo (id int)`),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version("test"); err != nil || version != 1 {
+		t.Errorf("Expected version 1 in the transaction, got %v, %v", version, err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	var exists bool
+	if err := connection.QueryRow(`SELECT to_regclass('yolo') IS NOT NULL`).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("Expected the caller's rollback to undo the migration")
+	}
+}
+
+func TestSkipIf(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS ` + tableName + `;
+				DROP TABLE IF EXISTS yolo;
+				CREATE TABLE yolo (id int);`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pipe := pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Direction: direction.Up,
+		Content: []byte(`-- migrate:skip-if SELECT 1 FROM pg_tables WHERE tablename = 'yolo'
+			CREATE TABLE yolo (id int);`),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version("test"); err != nil || version != 1 {
+		t.Errorf("Expected skipped version 1 to be recorded, got %v, %v", version, err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS ` + tableName + `;
+				DROP TABLE IF EXISTS yolo;`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.SetDryRun(true)
+
+	pipe := pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Direction: direction.Up,
+		Content:   []byte(`CREATE TABLE yolo (id int); INSERT INTO yolo VALUES (1);`),
+	}, pipe)
+	statements := make([]string, 0)
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			t.Fatal(item)
+		case string:
+			statements = append(statements, item)
+		}
+	}
+	if len(statements) != 2 || statements[0] != "CREATE TABLE yolo (id int);" {
+		t.Errorf("Expected the two statements, got %q", statements)
+	}
+
+	var exists bool
+	if err := connection.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_tables WHERE tablename = 'yolo')`).Scan(&exists); err != nil || exists {
+		t.Errorf("Expected the table not to be created, got %v, %v", exists, err)
+	}
+	if version, err := d.Version("test"); err != nil || version != 0 {
+		t.Errorf("Expected no version to be recorded, got %v, %v", version, err)
+	}
+}
+
+func TestNoTransaction(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS ` + tableName + `;
+				DROP TABLE IF EXISTS yolo;
+				CREATE TABLE yolo (id int);`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pipe := pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Direction: direction.Up,
+		Content: []byte(`-- migrate:transaction false
+			CREATE INDEX CONCURRENTLY yolo_id ON yolo (id);`),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version("test"); err != nil || version != 1 {
+		t.Errorf("Expected version 1, got %v, %v", version, err)
+	}
+
+	// without idle connections, every statement run on the pool would
+	// get a new session and lose the search_path
+	d.db.SetMaxIdleConns(0)
+	pipe = pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "002_foobar.up.sql",
+		Version:   2,
+		Direction: direction.Up,
+		Content: []byte(`-- migrate:transaction false
+			DROP SCHEMA IF EXISTS yolo_session CASCADE;
+			CREATE SCHEMA yolo_session;
+			SET search_path TO yolo_session;
+			CREATE TABLE yolo (id int);
+			CREATE INDEX CONCURRENTLY yolo_id ON yolo (id);`),
+	}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var count int
+	if err := connection.QueryRow(`SELECT count(*) FROM pg_indexes WHERE schemaname = 'yolo_session' AND indexname = 'yolo_id'`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the statements to share the session's search_path, got %v, %v", count, err)
+	}
+}
+
+func TestNoTransactionTimeouts(t *testing.T) {
+	d := &Driver{}
+	if err := d.Initialize(nil, "postgres://localhost/migratetest?sslmode=disable&x-lock-timeout=1500ms&x-statement-timeout=30s"); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, directive := range []string{"transaction false", "parallel 2"} {
+		pipe := pipep.New()
+		go d.Execute(file.File{
+			FileName:  "001_foobar.up.sql",
+			Version:   1,
+			Direction: direction.Up,
+			Content: []byte(`-- migrate:` + directive + `
+				DO $$ BEGIN
+					IF current_setting('lock_timeout') <> '1500ms' OR current_setting('statement_timeout') <> '30s' THEN
+						RAISE EXCEPTION 'timeouts not set';
+					END IF;
+				END $$;`),
+		}, pipe)
+		if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+			t.Errorf("Expected the timeouts to be set with %s, got %v", directive, errs)
+		}
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`DROP TABLE IF EXISTS ` + tableName + `;`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	d.SetContext(ctx)
+
+	pipe := pipep.New()
+	go d.Migrate("", file.File{
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Direction: direction.Up,
+		Content:   []byte(`SELECT pg_sleep(10);`),
+	}, pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if merr, ok := errs[0].(*migrationerror.Error); !ok || merr.Category != migrationerror.Timeout {
+		t.Errorf("Expected a timeout error, got %#v", errs[0])
+	}
+	if version, err := d.Version(""); err != nil || version != 0 {
+		t.Errorf("Expected version 0, got %v, %v", version, err)
+	}
+}
+
+func TestMigrateBatch(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS yolo;
+				DROP TABLE IF EXISTS ` + tableName + `;`); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	files := file.Files{
+		{
+			FileName:  "001_foobar.up.sql",
+			Version:   1,
+			Direction: direction.Up,
+			Content:   []byte(`CREATE TABLE yolo (id serial not null primary key);`),
+		},
+		{
+			FileName:  "002_foobar.up.sql",
+			Version:   2,
+			Direction: direction.Up,
+			Content:   []byte(`CREATE TABLE error (id THIS WILL CAUSE AN ERROR)`),
+		},
+	}
+
+	pipe := pipep.New()
+	go d.MigrateBatch("", files, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if version, err := d.Version(""); err != nil || version != 0 {
+		t.Errorf("Expected version 0 after a failed batch, got %v, %v", version, err)
+	}
+	var exists bool
+	if err := connection.QueryRow(`SELECT to_regclass('yolo') IS NOT NULL`).Scan(&exists); err != nil || exists {
+		t.Errorf("Expected the first file to be rolled back, got %v, %v", exists, err)
+	}
+
+	pipe = pipep.New()
+	go d.MigrateBatch("", files[:1], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version(""); err != nil || version != 1 {
+		t.Errorf("Expected version 1, got %v, %v", version, err)
+	}
+}
+
+func TestMigrateBatchSettings(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable&x-statement-timeout=1m"
+
+	connection, err := sql.Open("postgres", driverUrl[:strings.Index(driverUrl, "&")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`DROP TABLE IF EXISTS ` + tableName); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// the directives of the first file must not carry over to the second
+	check := `DO $$ BEGIN
+		IF current_setting('lock_timeout') <> '0' OR current_setting('statement_timeout') <> '1min' THEN
+			RAISE EXCEPTION 'settings carried over: lock_timeout %, statement_timeout %',
+				current_setting('lock_timeout'), current_setting('statement_timeout');
+		END IF;
+	END $$;`
+	files := file.Files{
+		{
+			FileName:  "001_foobar.up.sql",
+			Version:   1,
+			Direction: direction.Up,
+			Content:   []byte("-- migrate:lock_timeout 5s\n-- migrate:timeout 2h\nSELECT 1;"),
+		},
+		{
+			FileName:  "002_foobar.up.sql",
+			Version:   2,
+			Direction: direction.Up,
+			Content:   []byte(check),
+		},
+	}
+	pipe := pipep.New()
+	go d.MigrateBatch("", files, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version(""); err != nil || version != 2 {
+		t.Errorf("Expected version 2, got %v, %v", version, err)
+	}
+}
+
+func TestMigrateGzip(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS seed;
+				DROP TABLE IF EXISTS ` + tableName + `;`); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir, err := ioutil.TempDir("/tmp", "TestMigrateGzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`CREATE TABLE seed (id integer); INSERT INTO seed VALUES (1), (2);`))
+	w.Close()
+	if err := ioutil.WriteFile(path.Join(tmpdir, "001_seed.up.sql.gz"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pipe := pipep.New()
+	go d.Migrate("", file.File{Path: tmpdir, FileName: "001_seed.up.sql.gz"}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var count int
+	if err := connection.QueryRow(`SELECT COUNT(*) FROM seed`).Scan(&count); err != nil || count != 2 {
+		t.Errorf("Expected 2 seed rows, got %v, %v", count, err)
+	}
+	if version, err := d.Version(""); err != nil || version != 1 {
+		t.Errorf("Expected version 1, got %v, %v", version, err)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	var tests = []struct {
+		url         string
+		expectUrl   string
+		expectTable string
+		expectError bool
+	}{
+		{"postgres://localhost/db", "postgres://localhost/db", tableName, false},
+		{"postgres://localhost/db?sslmode=disable", "postgres://localhost/db?sslmode=disable", tableName, false},
+		{"postgres://localhost/db?sslmode=disable&version_table=meta.versions", "postgres://localhost/db?sslmode=disable", "meta.versions", false},
+		{"postgres://localhost/db?version_table=a.b.c", "", "", true},
+		{"postgres://localhost/db?advisory_lock_timeout=30s&sslmode=disable", "postgres://localhost/db?sslmode=disable", tableName, false},
+		{"postgres://localhost/db?advisory_lock_timeout=soon", "", "", true},
+		{"postgres://localhost/db?search_path=tenant1", "postgres://localhost/db?search_path=tenant1", "tenant1." + tableName, false},
+		{"postgres://localhost/db?search_path=tenant1,public&version_table=meta.versions", "postgres://localhost/db?search_path=tenant1%2Cpublic", "meta.versions", false},
+		{"postgres://localhost/db?search_path=tenant1%3BDROP", "", "", true},
+		{"postgres://localhost/db?x-lock-timeout=5s&x-statement-timeout=30s&sslmode=disable", "postgres://localhost/db?sslmode=disable", tableName, false},
+		{"postgres://localhost/db?x-lock-timeout=5", "", "", true},
+		{"postgres://localhost/db?multiStatements=true&sslmode=disable", "postgres://localhost/db?sslmode=disable", tableName, false},
+		{"postgres://localhost/db?x-max-open-conns=5&x-max-idle-conns=0&x-conn-max-lifetime=5m&sslmode=disable", "postgres://localhost/db?sslmode=disable", tableName, false},
+		{"postgres://localhost/db?x-max-open-conns=-1", "", "", true},
+		{"postgres://localhost/db?x-max-open-conns=1", "", "", true},
+		{"postgres://localhost/db?x-max-open-conns=2", "postgres://localhost/db", tableName, false},
+		{"postgres://localhost/db?x-max-idle-conns=few", "", "", true},
+		{"postgres://localhost/db?x-conn-max-lifetime=0", "", "", true},
+		{"postgres://localhost/db?multiStatements=sometimes", "", "", true},
+		{"host=localhost dbname=db", "host=localhost dbname=db", tableName, false},
+	}
+
+	for _, test := range tests {
+		d := &Driver{}
+		u, err := d.setOptions(test.url)
+		if (err != nil) != test.expectError {
+			t.Errorf("Expected error for %v: %v, got %v", test.url, test.expectError, err)
+			continue
+		}
+		if err == nil && (u != test.expectUrl || d.versionTable() != test.expectTable) {
+			t.Errorf("Expected %q, %q for %v, got %q, %q", test.expectUrl, test.expectTable, test.url, u, d.versionTable())
+		}
+	}
+}
+
+func TestCheckCopyFromStdin(t *testing.T) {
+	var tests = []struct {
+		content     string
+		expectError string
+	}{
+		{"CREATE TABLE t (a int);\n-- load t\ncopy t (a) FROM stdin;\n1\n\\.\n", "line 3"},
+		{"/* seed */ COPY t FROM STDIN WITH (FORMAT csv);", "line 1"},
+		{"COPY t FROM '/var/lib/postgresql/t.csv';", ""},
+		{"SELECT 'COPY t FROM STDIN';", ""},
+		{"COPY t TO STDOUT;", ""},
+	}
+
+	for _, test := range tests {
+		f := file.File{FileName: "001_foo.up.sql", Content: []byte(test.content)}
+		err := checkCopyFromStdin(f, splitStatements(test.content))
+		if test.expectError == "" {
+			if err != nil {
+				t.Errorf("Unexpected error for %q: %v", test.content, err)
+			}
+			continue
+		}
+		if _, ok := err.(*migrationerror.Error); !ok || !strings.Contains(err.Error(), "COPY ... FROM STDIN in "+test.expectError) {
+			t.Errorf("Expected an error in %s for %q, got %v", test.expectError, test.content, err)
+		}
+	}
+}
+
+func TestTxTimeouts(t *testing.T) {
+	d := &Driver{}
+	if _, err := d.setOptions("postgres://localhost/db?x-lock-timeout=1500ms&x-statement-timeout=30s"); err != nil {
+		t.Fatal(err)
+	}
+	if d.txLockTimeout != "1500" || d.txStatementTimeout != "30000" {
+		t.Errorf("Expected timeouts 1500 and 30000, got %q and %q", d.txLockTimeout, d.txStatementTimeout)
+	}
+
+	err := queryError(file.File{FileName: "001_foo.up.sql"}, &pq.Error{Code: lockNotAvailable, Message: "canceling statement due to lock timeout"}, 0)
+	if merr, ok := err.(*migrationerror.Error); !ok || merr.Code != lockNotAvailable || !strings.Contains(err.Error(), "Timed out waiting for a lock") {
+		t.Errorf("Expected a lock timeout error, got %#v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable&advisory_lock_timeout=1s"
+	d1, d2 := &Driver{}, &Driver{}
+	if err := d1.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d1.Close()
+	if err := d2.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Close()
+
+	if err := d1.Lock("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock("test"); err == nil || !strings.Contains(err.Error(), "Another migration is in progress") {
+		t.Errorf("Expected the lock to be held, got %v", err)
+	}
+	store, err := NewVersionStore(d2.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.LockTimeout = time.Second
+	if err := store.Lock("test"); err == nil || !strings.Contains(err.Error(), "Another migration is in progress") {
+		t.Errorf("Expected the version store to give up on the held lock, got %v", err)
+	}
+	if err := d2.Lock("other"); err != nil {
+		t.Errorf("Expected the lock of another id to be free, got %v", err)
+	}
+	d2.Unlock("other")
+
+	if err := d1.Unlock("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock("test"); err != nil {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+	d2.Unlock("test")
+}
+
+func TestLockCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	d := &Driver{db: sql.OpenDB(fakeConnector{})}
+	defer d.db.Close()
+	d.SetContext(ctx)
+	err := d.Lock("test")
+	if merr, ok := err.(*migrationerror.Error); !ok || merr.Category != migrationerror.Timeout || merr.File != nil {
+		t.Errorf("Expected a timeout error not about a file, got %#v", err)
+	}
+}
+
+func TestVersionStoreLock(t *testing.T) {
+	if advisoryLockKey("schema_migrations", "test") == advisoryLockKey("tenant1.schema_migrations", "test") {
+		t.Error("Expected the lock keys of different version tables to differ")
+	}
+	if _, err := NewVersionStoreTable(nil, "tenant1.schema_migrations;"); err == nil {
+		t.Error("Expected error for an invalid version table")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	s := &VersionStore{db: sql.OpenDB(fakeConnector{}), table: "tenant1.schema_migrations"}
+	defer s.db.Close()
+	s.SetContext(ctx)
+	err := s.Lock("test")
+	if merr, ok := err.(*migrationerror.Error); !ok || merr.Category != migrationerror.Timeout {
+		t.Errorf("Expected the version store to give up with the run context, got %#v", err)
+	}
+}
+
+func TestMigrateErrorLine(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	pipe := pipep.New()
+	go d.Migrate("test", file.File{
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Direction: direction.Up,
+		Content: []byte(`CREATE FUNCTION yolo() RETURNS text AS $$
+				SELECT 'a;b';
+			$$ LANGUAGE sql;
+
+			SELECT yolo();
+			SELECT * FROM does_not_exist;`),
+	}, pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "line 6, column 18") {
+		t.Errorf("Expected error in line 6, column 18, got %v", errs)
+	}
+}
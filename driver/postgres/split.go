@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"strings"
+)
+
+// statement is a single SQL statement of a migration file.
+type statement struct {
+	// the statement without the terminating semicolon
+	Query string
+
+	// byte offset of the statement in the migration file
+	Offset int
+}
+
+// splitStatements splits SQL into its top-level statements.
+// Semicolons inside string literals, quoted identifiers, dollar-quoted
+// bodies ($$ ... $$, $tag$ ... $tag$) and comments don't end a statement.
+// Statements that consist of whitespace or comments only are dropped.
+func splitStatements(sql string) []statement {
+	statements := make([]statement, 0)
+	start := 0
+	hasCode := false
+
+	add := func(end int) {
+		if hasCode {
+			query := strings.TrimSpace(sql[start:end])
+			offset := start + strings.Index(sql[start:end], query)
+			statements = append(statements, statement{Query: query, Offset: offset})
+		}
+		start = end + 1
+		hasCode = false
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == ';':
+			add(i)
+			continue
+
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+			continue
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+			continue
+
+		case c == '\'':
+			escapes := i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i < 2 || !isIdentChar(sql[i-2]))
+			i = skipQuoted(sql, i, '\'', escapes)
+
+		case c == '"':
+			i = skipQuoted(sql, i, '"', false)
+
+		case c == '$':
+			if tag, ok := dollarTag(sql, i); ok {
+				if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(sql)
+				}
+			}
+		}
+
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			hasCode = true
+		}
+	}
+	add(len(sql))
+
+	return statements
+}
+
+// skipQuoted returns the index of the quote closing the literal
+// starting at i. A doubled quote is an escaped quote; with escapes
+// set, so is a backslash-quote.
+func skipQuoted(sql string, i int, quote byte, escapes bool) int {
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case escapes && sql[j] == '\\':
+			j++
+		case sql[j] == quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(sql)
+}
+
+// skipBlockComment returns the index of the end of the (possibly
+// nested) block comment starting at i.
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for j := i; j < len(sql)-1; j++ {
+		if sql[j] == '/' && sql[j+1] == '*' {
+			depth++
+			j++
+		} else if sql[j] == '*' && sql[j+1] == '/' {
+			depth--
+			j++
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag returns the dollar quote tag ($$ or $tag$) starting at i.
+// Positional parameters like $1 are not tags.
+func dollarTag(sql string, i int) (string, bool) {
+	if i > 0 && isIdentChar(sql[i-1]) {
+		return "", false
+	}
+	for j := i + 1; j < len(sql); j++ {
+		c := sql[j]
+		if c == '$' {
+			return sql[i : j+1], true
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > i+1 && c >= '0' && c <= '9') {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// CountStatements returns the number of top-level statements in content.
+func (driver *Driver) CountStatements(content []byte) int {
+	return len(splitStatements(string(content)))
+}
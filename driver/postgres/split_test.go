@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	var tests = []struct {
+		sql    string
+		expect []string
+	}{
+		{"", []string{}},
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1;SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"  SELECT 1 ;\n\n  ;  -- only a comment\n", []string{"SELECT 1"}},
+		{"SELECT ';'; SELECT 'it''s;'", []string{"SELECT ';'", "SELECT 'it''s;'"}},
+		{`SELECT E'\';'; SELECT 2`, []string{`SELECT E'\';'`, "SELECT 2"}},
+		{`SELECT "a;b" FROM t; SELECT 2`, []string{`SELECT "a;b" FROM t`, "SELECT 2"}},
+		{"SELECT 1; -- comment; with semicolon\nSELECT 2", []string{"SELECT 1", "-- comment; with semicolon\nSELECT 2"}},
+		{"SELECT /* a; /* nested; */ b; */ 1; SELECT 2", []string{"SELECT /* a; /* nested; */ b; */ 1", "SELECT 2"}},
+		{`CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql; SELECT 2`,
+			[]string{`CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql`, "SELECT 2"}},
+		{`DO $body$ BEGIN PERFORM '$$;'; END $body$; SELECT 2`, []string{`DO $body$ BEGIN PERFORM '$$;'; END $body$`, "SELECT 2"}},
+		{`PREPARE p AS SELECT $1; SELECT 2`, []string{`PREPARE p AS SELECT $1`, "SELECT 2"}},
+	}
+
+	for _, test := range tests {
+		statements := splitStatements(test.sql)
+		if len(statements) != len(test.expect) {
+			t.Errorf("Expected %v statements, got %v for %q: %v", len(test.expect), len(statements), test.sql, statements)
+			continue
+		}
+		for i, s := range statements {
+			if s.Query != test.expect[i] {
+				t.Errorf("Expected statement %q, got %q", test.expect[i], s.Query)
+			}
+			if test.sql[s.Offset:s.Offset+len(s.Query)] != s.Query {
+				t.Errorf("Wrong offset %v for statement %q", s.Offset, s.Query)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/PlanitarInc/migrate/file"
+)
+
+// syntaxError is the SQLSTATE of a statement that doesn't parse
+const syntaxError = "42601"
+
+// CheckSyntax has postgres parse every statement of a migration file
+// without executing it. Only syntax errors are reported: other errors,
+// like a missing table, are expected for files that depend on earlier
+// migrations that haven't been applied yet.
+func (driver *Driver) CheckSyntax(f file.File) error {
+	if driver.tx != nil {
+		// a syntax error would abort the caller's transaction
+		return errors.New("Syntax checks can't run in the caller's transaction.")
+	}
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	statements := splitStatements(string(f.Content))
+	if err := checkCopyFromStdin(f, statements); err != nil {
+		return err
+	}
+	for _, s := range statements {
+		stmt, err := driver.db.Prepare(s.Query)
+		if err == nil {
+			stmt.Close()
+			continue
+		}
+		code := sqlState(err)
+		if code == "" {
+			return err
+		}
+		if code == syntaxError {
+			return queryError(f, err, s.Offset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/history"
+)
+
+// VersionStore keeps track of applied migrations in a postgres database,
+// which doesn't have to be the one migrations run against.
+// It implements migrate.VersionStore.
+type VersionStore struct {
+	db *sql.DB
+
+	// the possibly schema qualified version table
+	table string
+
+	// LockTimeout is how long Lock waits for another migration to
+	// release the lock, 15 minutes if zero.
+	LockTimeout time.Duration
+
+	// the context set by SetContext
+	ctx context.Context
+
+	// the driver holding the advisory lock
+	locked *Driver
+}
+
+// NewVersionStore returns a VersionStore using db and creates
+// the version table if it doesn't exist.
+func NewVersionStore(db *sql.DB) (*VersionStore, error) {
+	return NewVersionStoreTable(db, tableName)
+}
+
+// NewVersionStoreTable is NewVersionStore with another version table,
+// optionally qualified by a schema like tenant1.schema_migrations.
+func NewVersionStoreTable(db *sql.DB, table string) (*VersionStore, error) {
+	if !versionTableRegex.MatchString(table) {
+		return nil, fmt.Errorf("Invalid version table %q.", table)
+	}
+	s := &VersionStore{db: db, table: table}
+	if err := s.driver().ensureVersionTableExists(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// driver returns a driver for the version table of s
+func (s *VersionStore) driver() *Driver {
+	return &Driver{db: s.db, table: s.table, lockTimeout: s.LockTimeout, ctx: s.ctx}
+}
+
+// SetContext makes Lock give up once ctx is done.
+func (s *VersionStore) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *VersionStore) GetVersion(id string) (uint64, error) {
+	return s.driver().Version(id)
+}
+
+func (s *VersionStore) SetVersion(id string, version uint64, d direction.Direction) error {
+	return s.driver().recordVersion(s.db, id, version, d, "")
+}
+
+func (s *VersionStore) ListVersions(id string) ([]uint64, error) {
+	return s.driver().ListVersions(id)
+}
+
+func (s *VersionStore) History(id string) ([]history.AppliedMigration, error) {
+	return s.driver().History(id)
+}
+
+func (s *VersionStore) IdVersions() (map[string]uint64, error) {
+	return s.driver().idVersions(s.db)
+}
+
+// Lock takes the driver's session level advisory lock keyed off the
+// version table and id. It is held on a dedicated connection of the pool
+// until Unlock is called. Like the driver's lock, it gives up after
+// LockTimeout or once the context set by SetContext is done.
+func (s *VersionStore) Lock(id string) error {
+	if s.locked != nil {
+		return errors.New("Version store is locked already.")
+	}
+	d := s.driver()
+	if err := d.Lock(id); err != nil {
+		return err
+	}
+	s.locked = d
+	return nil
+}
+
+func (s *VersionStore) Unlock(id string) error {
+	if s.locked == nil {
+		return nil
+	}
+	d := s.locked
+	s.locked = nil
+	return d.Unlock(id)
+}
+
+// advisoryLockKey derives the advisory lock key for a migration id
+// recorded in the possibly schema qualified version table.
+func advisoryLockKey(table, id string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(table + ":" + id))
+	return int64(h.Sum64())
+}
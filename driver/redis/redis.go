@@ -0,0 +1,219 @@
+// Package redis implements the Driver interface for Redis, running Lua
+// migration files as scripts.
+package redis
+
+import (
+	"fmt"
+	"net"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+)
+
+type Driver struct {
+	conn *conn
+}
+
+const (
+	// the current version
+	versionKey = "migrate:schema_version"
+
+	// a sorted set of all applied versions, scored by version
+	versionsKey = "migrate:schema_versions"
+)
+
+const defaultPort = "6379"
+
+// keys returns the version keys of id, which prefixes them unless empty.
+func keys(id string) (string, string) {
+	if id == "" {
+		return versionKey, versionsKey
+	}
+	return id + ":" + versionKey, id + ":" + versionsKey
+}
+
+// Redis Driver URL format:
+// redis://[[user]:password@]host[:port][/database]
+func (driver *Driver) Initialize(instance interface{}, url string) error {
+	if instance != nil {
+		return fmt.Errorf("Expected no instance, the driver connects to the url itself, got %#v", instance)
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	db := strings.Trim(u.Path, "/")
+	if db != "" {
+		if _, err := strconv.ParseUint(db, 10, 0); err != nil {
+			return fmt.Errorf("Invalid redis database '%s', expected a number.", db)
+		}
+	}
+
+	c, err := dial(host)
+	if err != nil {
+		return migrationerror.Wrap(migrationerror.Connection, err)
+	}
+	if password, ok := u.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if user := u.User.Username(); user != "" {
+			args = []string{"AUTH", user, password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return migrationerror.Wrap(migrationerror.Connection, err)
+		}
+	}
+	if db != "" {
+		if _, err := c.do("SELECT", db); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	if _, err := c.do("PING"); err != nil {
+		c.Close()
+		return migrationerror.Wrap(migrationerror.Connection, err)
+	}
+	driver.conn = c
+	return nil
+}
+
+func (driver *Driver) Close() error {
+	return driver.conn.Close()
+}
+
+func (driver *Driver) FilenameExtension() string {
+	return "lua"
+}
+
+// Migrate runs the file as a Lua script, followed by updating the version
+// in the same script. Redis runs scripts atomically, so the version only
+// changes if the file succeeded. Writes of a file that fails halfway are
+// not rolled back though.
+func (driver *Driver) Migrate(id string, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+
+	start := time.Now()
+	script, err := migrationScript(f)
+	if err != nil {
+		pipe <- err
+		return
+	}
+	versionKey, versionsKey := keys(id)
+	if _, err := driver.conn.do("EVAL", script, "2", versionKey, versionsKey, strconv.FormatUint(f.Version, 10)); err != nil {
+		pipe <- migrationerror.New(f, "", err)
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+// recordVersionScript adds (up) or removes (down) ARGV[1] from the
+// applied versions in KEYS[2] and sets KEYS[1] to the highest one left.
+const recordVersionScript = `
+redis.call('%s', KEYS[2], %s)
+local current = redis.call('ZREVRANGE', KEYS[2], 0, 0)
+redis.call('SET', KEYS[1], current[1] or '0')
+`
+
+// migrationScript wraps the content of f in a function that runs before
+// its version is recorded. The function starts on the first line, so
+// line numbers of errors match the file.
+func migrationScript(f file.File) (string, error) {
+	var record string
+	switch f.Direction {
+	case direction.Up:
+		record = fmt.Sprintf(recordVersionScript, "ZADD", "ARGV[1], ARGV[1]")
+	case direction.Down:
+		record = fmt.Sprintf(recordVersionScript, "ZREM", "ARGV[1]")
+	default:
+		return "", fmt.Errorf("Unsupported direction %v.", f.Direction)
+	}
+	return "local migration = function() " + string(f.Content) + "\nend\nmigration()" + record, nil
+}
+
+// Execute runs the file as a Lua script without recording its version.
+func (driver *Driver) Execute(f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	f.ParseFileName(driver.FilenameExtension())
+	pipe <- f
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+	start := time.Now()
+	if _, err := driver.conn.do("EVAL", string(f.Content), "0"); err != nil {
+		pipe <- migrationerror.New(f, "", err)
+		return
+	}
+	pipe <- file.MigrationResult{File: f, Duration: time.Since(start)}
+}
+
+func (driver *Driver) Version(id string) (uint64, error) {
+	versionKey, _ := keys(id)
+	reply, err := driver.conn.do("GET", versionKey)
+	if err != nil {
+		return 0, err
+	}
+	if reply == nil {
+		return 0, nil
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return 0, fmt.Errorf("Unexpected version %#v in %s.", reply, versionKey)
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// ListVersions returns the applied versions of id in ascending order.
+func (driver *Driver) ListVersions(id string) ([]uint64, error) {
+	_, versionsKey := keys(id)
+	reply, err := driver.conn.do("ZRANGE", versionsKey, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]interface{})
+	versions := make([]uint64, 0, len(members))
+	for _, member := range members {
+		b, _ := member.([]byte)
+		version, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected version %q in %s.", b, versionsKey)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (driver *Driver) SupportsTransactions() bool {
+	return false
+}
+
+func (driver *Driver) SupportsLocking() bool {
+	return false
+}
+
+func (driver *Driver) SupportsVersionListing() bool {
+	return true
+}
+
+func (driver *Driver) SupportsDirtyState() bool {
+	return false
+}
+
+func (driver *Driver) SupportsMultiStatement() bool {
+	return true
+}
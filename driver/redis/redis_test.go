@@ -0,0 +1,131 @@
+package redis
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+func TestReadReply(t *testing.T) {
+	var tests = []struct {
+		reply       string
+		expectReply interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{"-ERR wrong\r\n", redisError("ERR wrong")},
+		{":42\r\n", int64(42)},
+		{"$3\r\nfoo\r\n", []byte("foo")},
+		{"$-1\r\n", nil},
+		{"*2\r\n$1\r\n1\r\n$1\r\n3\r\n", []interface{}{[]byte("1"), []byte("3")}},
+		{"*0\r\n", []interface{}{}},
+	}
+
+	for _, test := range tests {
+		reply, err := readReply(bufio.NewReader(strings.NewReader(test.reply)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reply, test.expectReply) {
+			t.Errorf("Expected %#v for %q, got %#v", test.expectReply, test.reply, reply)
+		}
+	}
+
+	if _, err := readReply(bufio.NewReader(strings.NewReader("?\r\n"))); err == nil {
+		t.Error("Expected error for an unknown reply type")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	if version, versions := keys(""); version != "migrate:schema_version" || versions != "migrate:schema_versions" {
+		t.Errorf("Unexpected keys %v, %v", version, versions)
+	}
+	if version, versions := keys("cache"); version != "cache:migrate:schema_version" || versions != "cache:migrate:schema_versions" {
+		t.Errorf("Unexpected keys %v, %v", version, versions)
+	}
+}
+
+func TestMigrationScript(t *testing.T) {
+	script, err := migrationScript(file.File{Direction: direction.Up, Content: []byte("redis.call('SET', 'a', 1)")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(script, "local migration = function() redis.call('SET', 'a', 1)\nend\nmigration()\n") {
+		t.Errorf("Expected the file to run first, on the first line, got %s", script)
+	}
+	if !strings.Contains(script, "redis.call('ZADD', KEYS[2], ARGV[1], ARGV[1])") {
+		t.Errorf("Expected the version to be added, got %s", script)
+	}
+	if _, err := migrationScript(file.File{}); err == nil {
+		t.Error("Expected error for a file without direction")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	driverUrl := "redis://localhost:6379/15"
+
+	d := &Driver{}
+	if err := d.Initialize(nil, driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.conn.do("FLUSHDB"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []file.File{
+		{
+			FileName:  "001_foobar.up.lua",
+			Direction: direction.Up,
+			Content:   []byte(`redis.call('SET', 'yolo', 1)`),
+		},
+		{
+			FileName:  "002_foobar.up.lua",
+			Direction: direction.Up,
+			Content:   []byte(`redis.call('SET', 'yolo', 2)`),
+		},
+		{
+			FileName:  "002_foobar.down.lua",
+			Direction: direction.Down,
+			Content:   []byte(`redis.call('SET', 'yolo', 1)`),
+		},
+		{
+			FileName:  "003_foobar.up.lua",
+			Direction: direction.Up,
+			Content:   []byte(`redis.call('SET', 'yolo', 3) redis.call('INCR', 'yolo', 'oops')`),
+		},
+	}
+
+	for _, f := range files[:3] {
+		pipe := pipep.New()
+		go d.Migrate("", f, pipe)
+		if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	if version, err := d.Version(""); err != nil || version != 1 {
+		t.Errorf("Expected version 1, got %v, %v", version, err)
+	}
+
+	pipe := pipep.New()
+	go d.Migrate("", files[3], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if version, err := d.Version(""); err != nil || version != 1 {
+		t.Errorf("Expected version 1 after a failed migration, got %v, %v", version, err)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate("cache", files[0], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if versions, err := d.ListVersions("cache"); err != nil || !reflect.DeepEqual(versions, []uint64{1}) {
+		t.Errorf("Expected versions [1] of id cache, got %v, %v", versions, err)
+	}
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// conn speaks just enough of the redis protocol (RESP) to run commands
+// one at a time.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+const dialTimeout = 10 * time.Second
+
+func dial(addr string) (*conn, error) {
+	c, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(c), nil
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// do sends a command and reads its reply. Replies are strings (simple
+// strings), int64s, []byte or nil (bulk strings) and []interface{} or
+// nil (arrays). Error replies are returned as error.
+func (c *conn) do(args ...string) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	reply, err := readReply(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := reply.(redisError); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// readReply reads a single reply. Error replies nested in arrays are
+// returned as their redisError value.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("Malformed redis reply %q.", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return redisError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("Unknown redis reply type %q.", kind)
+}
@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"time"
+)
+
+const (
+	initialRetryWait = 250 * time.Millisecond
+	maxRetryWait     = 8 * time.Second
+)
+
+// NewWithRetry is like New, but retries initializing the driver with
+// exponential backoff while it fails, e.g. because the database isn't
+// up yet, for up to maxDuration. retrying, if not nil, is called with
+// the error of every failed attempt that will be retried and the time
+// until the next one. A maxDuration of 0 disables retries.
+func NewWithRetry(instance interface{}, url string, maxDuration time.Duration, retrying func(err error, wait time.Duration)) (Driver, error) {
+	deadline := time.Now().Add(maxDuration)
+	wait := initialRetryWait
+	for {
+		// a fresh driver for every attempt, failed ones may be half initialized
+		d, err := Lookup(url)
+		if err != nil {
+			return nil, err
+		}
+		err = d.Initialize(instance, url)
+		if err == nil {
+			return d, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, err
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		if retrying != nil {
+			retrying(err, wait)
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
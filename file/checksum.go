@@ -0,0 +1,13 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Checksum returns the hex encoded SHA-256 of a migration file's content,
+// as recorded by drivers that keep track of checksums.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
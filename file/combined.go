@@ -0,0 +1,71 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// combinedMarkerRegex matches the line starting a section of a combined
+// migration file, e.g. `-- +migrate Up`
+var combinedMarkerRegex = regexp.MustCompile(`(?i)^--\s*\+migrate\s+(up|down)(?:\s|$)`)
+
+// SplitCombined splits the content of a combined migration file into its
+// up and down sections, see CombinedLayout. The lines before a section are
+// kept as empty lines, so that line numbers in errors match the file.
+// The up section is required, a missing down section is empty.
+func SplitCombined(content []byte) (up, down []byte, err error) {
+	var sections [2]bytes.Buffer
+	started := [2]bool{}
+	current := -1
+	for i, line := range bytes.SplitAfter(content, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if matches := combinedMarkerRegex.FindSubmatch(trimmed); matches != nil {
+			current = 0
+			if strings.EqualFold(string(matches[1]), "down") {
+				current = 1
+			}
+			if started[current] {
+				return nil, nil, fmt.Errorf("Line %d starts a second %s section.", i+1, matches[1])
+			}
+			started[current] = true
+			line = []byte("\n")
+		} else if current < 0 && len(trimmed) > 0 && !bytes.HasPrefix(trimmed, []byte("--")) {
+			return nil, nil, fmt.Errorf("Line %d comes before the first -- +migrate Up or Down line.", i+1)
+		}
+
+		for s := range sections {
+			switch {
+			case s == current:
+				sections[s].Write(line)
+			case !started[s] && bytes.HasSuffix(line, []byte("\n")):
+				sections[s].WriteByte('\n')
+			}
+		}
+	}
+	if !started[0] {
+		return nil, nil, fmt.Errorf("No -- +migrate Up section found.")
+	}
+	if !started[1] {
+		return sections[0].Bytes(), []byte{}, nil
+	}
+	return sections[0].Bytes(), sections[1].Bytes(), nil
+}
+
+// JoinCombined returns the content of a combined migration file with the
+// up and down sections. A nil down omits the down section.
+func JoinCombined(up, down []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("-- +migrate Up\n")
+	buf.Write(up)
+	if down == nil {
+		return buf.Bytes()
+	}
+	if len(up) > 0 && !bytes.HasSuffix(up, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("\n-- +migrate Down\n")
+	buf.Write(down)
+	return buf.Bytes()
+}
@@ -0,0 +1,124 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/migrate/direction"
+)
+
+func TestSplitCombined(t *testing.T) {
+	var tests = []struct {
+		content    string
+		expectUp   string
+		expectDown string
+		expectErr  bool
+	}{
+		{"-- +migrate Up\nCREATE TABLE a;\n-- +migrate Down\nDROP TABLE a;\n", "\nCREATE TABLE a;\n", "\n\n\nDROP TABLE a;\n", false},
+		{"-- header\n\n-- +migrate up\nSELECT 1;", "\n\n\nSELECT 1;", "", false},
+		{"-- +migrate Down\nDROP TABLE a;\n--+migrate Up\nCREATE TABLE a;\n", "\n\n\nCREATE TABLE a;\n", "\nDROP TABLE a;\n", false},
+		{"-- +migrate Up\n-- migrate:irreversible\nSELECT 1;\n", "\n-- migrate:irreversible\nSELECT 1;\n", "", false},
+		{"SELECT 1;\n-- +migrate Up\n", "", "", true},
+		{"-- +migrate Up\nSELECT 1;\n-- +migrate Up\nSELECT 2;\n", "", "", true},
+		{"-- +migrate Down\nDROP TABLE a;\n", "", "", true},
+		{"-- +migrate Upgrade\nSELECT 1;\n", "", "", true},
+	}
+
+	for _, test := range tests {
+		up, down, err := SplitCombined([]byte(test.content))
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("Expected error for %q", test.content)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.content, err)
+			continue
+		}
+		if string(up) != test.expectUp || string(down) != test.expectDown {
+			t.Errorf("Expected %q and %q for %q, got %q and %q", test.expectUp, test.expectDown, test.content, up, down)
+		}
+		if down == nil {
+			t.Errorf("Expected an empty down section for %q, got nil", test.content)
+		}
+	}
+}
+
+func TestJoinCombined(t *testing.T) {
+	content := JoinCombined([]byte("CREATE TABLE a;"), []byte("DROP TABLE a;\n"))
+	if string(content) != "-- +migrate Up\nCREATE TABLE a;\n\n-- +migrate Down\nDROP TABLE a;\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	up, down, err := SplitCombined(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(up) != "\nCREATE TABLE a;\n\n" || string(down) != "\n\n\n\nDROP TABLE a;\n" {
+		t.Errorf("Expected the sections back, got %q and %q", up, down)
+	}
+
+	if content := JoinCombined([]byte("SELECT 1;\n"), nil); string(content) != "-- +migrate Up\nSELECT 1;\n" {
+		t.Errorf("Expected no down section, got %q", content)
+	}
+}
+
+func TestReadCombinedFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestReadCombinedFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_users.sql"), []byte("-- +migrate Up\nCREATE TABLE users;\n-- +migrate Down\nDROP TABLE users;\n"), 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_backfill.sql"), []byte("-- +migrate Up\n-- migrate:irreversible\nUPDATE users;\n"), 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "0003_other.up.sql"), nil, 0755)
+
+	options := ReadOptions{Layout: CombinedLayout}
+	files, err := ReadMigrationFilesFromStoreWithOptions(&FSStore{}, tmpdir, CombinedFilenameRegex("sql", "", DefaultNameSeparator), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 migration files, got %v", len(files))
+	}
+	mf := files[0]
+	if mf.Version != 1 || mf.UpFile.Name != "users" || mf.UpFile.Direction != direction.Up || mf.DownFile.Direction != direction.Down {
+		t.Errorf("Unexpected migration file %#v", mf)
+	}
+	if err := mf.UpFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mf.DownFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(mf.UpFile.Content) != "\nCREATE TABLE users;\n" || string(mf.DownFile.Content) != "\n\n\nDROP TABLE users;\n" {
+		t.Errorf("Expected the sections as content, got %q and %q", mf.UpFile.Content, mf.DownFile.Content)
+	}
+
+	if _, err := files.ToFirstFrom(2); err == nil {
+		t.Error("Expected error rolling back the irreversible version 2")
+	}
+	down, err := files.ToFirstFrom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(down) != 1 || down[0].Version != 1 {
+		t.Errorf("Expected the down file of version 1, got %v", down)
+	}
+
+	ioutil.WriteFile(path.Join(tmpdir, "0002_duplicate.sql"), nil, 0755)
+	if _, err := ReadMigrationFilesFromStoreWithOptions(&FSStore{}, tmpdir, CombinedFilenameRegex("sql", "", DefaultNameSeparator), options); err == nil {
+		t.Error("Expected error for duplicate version 2")
+	}
+
+	files, err = ReadMigrationFilesFromStore(&FSStore{}, tmpdir, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Version != 3 {
+		t.Errorf("Expected only the split file by default, got %v", files)
+	}
+}
@@ -3,9 +3,13 @@ package file
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"go/token"
+	"io/ioutil"
+	"path"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -14,12 +18,52 @@ import (
 	"github.com/PlanitarInc/migrate/migrate/direction"
 )
 
-var filenameRegex = `^([0-9]+)_(.*)\.(up|down)\.%s$`
+var filenameRegex = `^(%s)%s(.*)\.(up|down)\.%s(?:\.gz)?$`
+
+var combinedFilenameRegex = `^(%s)%s(.*)\.%s(?:\.gz)?$`
+
+// DefaultNameSeparator separates the version and the name in filenames.
+const DefaultNameSeparator = "_"
+
+// GzipExtension is appended to the filename of gzip compressed migration
+// files, e.g. 001_seed.up.sql.gz. Their content is decompressed when read.
+const GzipExtension = ".gz"
+
+// versionRegex matches sequential and plain timestamp versions
+var versionRegex = `[0-9]+`
 
 // FilenameRegex builds regular expression stmt with given
 // filename extension from driver.
 func FilenameRegex(filenameExtension string) *regexp.Regexp {
-	return regexp.MustCompile(fmt.Sprintf(filenameRegex, filenameExtension))
+	return FilenameRegexWithSeparator(filenameExtension, "", DefaultNameSeparator)
+}
+
+// FilenameRegexWithVersion is like FilenameRegex, but additionally
+// accepts versions matching versionPattern, e.g. `\d{4}_\d{2}_\d{2}`.
+// Any non-digits in matching versions are ignored when parsing them.
+func FilenameRegexWithVersion(filenameExtension, versionPattern string) *regexp.Regexp {
+	return FilenameRegexWithSeparator(filenameExtension, versionPattern, DefaultNameSeparator)
+}
+
+// FilenameRegexWithSeparator is like FilenameRegexWithVersion, but the
+// version and the name are separated by separator, e.g. "-", instead of
+// DefaultNameSeparator. versionPattern may be empty.
+func FilenameRegexWithSeparator(filenameExtension, versionPattern, separator string) *regexp.Regexp {
+	version := versionRegex
+	if versionPattern != "" {
+		version = "(?:" + versionPattern + ")|" + versionRegex
+	}
+	return regexp.MustCompile(fmt.Sprintf(filenameRegex, version, regexp.QuoteMeta(separator), filenameExtension))
+}
+
+// CombinedFilenameRegex is like FilenameRegexWithSeparator, but matches
+// the files of CombinedLayout, e.g. 001_initial.sql.
+func CombinedFilenameRegex(filenameExtension, versionPattern, separator string) *regexp.Regexp {
+	version := versionRegex
+	if versionPattern != "" {
+		version = "(?:" + versionPattern + ")|" + versionRegex
+	}
+	return regexp.MustCompile(fmt.Sprintf(combinedFilenameRegex, version, regexp.QuoteMeta(separator), filenameExtension))
 }
 
 // File represents one file on disk.
@@ -46,6 +90,14 @@ type File struct {
 	// the store used to read the file contents;
 	// defaults to FSStore (a regular file system)
 	Store FileStore
+
+	// directives parsed from the leading comments of the content,
+	// see ParseOptions; set by ReadContent
+	Options map[string]string
+
+	// the file holds both directions, see CombinedLayout; its content
+	// is the section of Direction
+	Combined bool
 }
 
 // Files is a slice of Files
@@ -67,6 +119,7 @@ type MigrationFile struct {
 type MigrationFiles []MigrationFile
 
 // ReadContent reads the file's content if the content is empty
+// and parses its options.
 func (f *File) ReadContent() error {
 	if len(f.Content) == 0 {
 		store := f.Store
@@ -77,24 +130,111 @@ func (f *File) ReadContent() error {
 		if err != nil {
 			return err
 		}
+		if strings.HasSuffix(f.FileName, GzipExtension) {
+			if content, err = gunzip(content); err != nil {
+				return fmt.Errorf("Unable to decompress %s: %v", f.FileName, err)
+			}
+		}
+		if f.Combined {
+			up, down, err := SplitCombined(content)
+			if err != nil {
+				return fmt.Errorf("Unable to split %s: %v", f.FileName, err)
+			}
+			content = up
+			if f.Direction == direction.Down {
+				content = down
+			}
+		}
 		f.Content = content
 	}
+	if f.Options == nil {
+		f.Options = ParseOptions(f.Content)
+	}
 	return nil
 }
 
+// WriteToStore writes the up file and, if set, the down file to store,
+// or to the file system if store isn't a WritableStore. Combined files
+// are written as one file holding both sections.
+func (mf *MigrationFile) WriteToStore(store FileStore) error {
+	files := []*File{mf.UpFile, mf.DownFile}
+	if mf.UpFile != nil && mf.UpFile.Combined {
+		var down []byte
+		if mf.DownFile != nil {
+			down = append([]byte{}, mf.DownFile.Content...)
+		}
+		combined := *mf.UpFile
+		combined.Content = JoinCombined(mf.UpFile.Content, down)
+		files = []*File{&combined}
+	}
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		p := path.Join(f.Path, f.FileName)
+		var err error
+		if w, ok := store.(WritableStore); ok {
+			err = w.Write(p, f.Content)
+		} else {
+			err = ioutil.WriteFile(p, f.Content, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gunzip decompresses gzip compressed content.
+func gunzip(content []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Reverse sorts the migration files by version, most recent first.
+func (mf *MigrationFiles) Reverse() {
+	sort.Sort(sort.Reverse(mf))
+}
+
 // ToFirstFrom fetches all (down) migration files including the migration file
 // of the current version to the very first migration file.
+// It fails if one of the migrations is irreversible.
 func (mf *MigrationFiles) ToFirstFrom(version uint64) (Files, error) {
-	sort.Sort(sort.Reverse(mf))
+	mf.Reverse()
 	files := make(Files, 0)
 	for _, migrationFile := range *mf {
-		if migrationFile.Version <= version && migrationFile.DownFile != nil {
+		if migrationFile.Version > version {
+			continue
+		}
+		if err := migrationFile.checkReversible(); err != nil {
+			return nil, err
+		}
+		if migrationFile.DownFile != nil {
 			files = append(files, *migrationFile.DownFile)
 		}
 	}
 	return files, nil
 }
 
+// checkReversible fails if the migration has no down file because
+// its up file is marked irreversible.
+func (mf MigrationFile) checkReversible() error {
+	if mf.UpFile == nil || mf.DownFile != nil && !mf.DownFile.Combined {
+		return nil
+	}
+	if err := mf.UpFile.ReadContent(); err != nil {
+		return err
+	}
+	if mf.UpFile.Irreversible() {
+		return fmt.Errorf("Version %v (%s) is irreversible and can't be rolled back.", mf.Version, mf.UpFile.FileName)
+	}
+	return nil
+}
+
 // ToLastFrom fetches all (up) migration files to the most recent migration file.
 // The migration file of the current version is not included.
 func (mf *MigrationFiles) ToLastFrom(version uint64) (Files, error) {
@@ -116,6 +256,8 @@ func (mf *MigrationFiles) ToLastFrom(version uint64) (Files, error) {
 // 		-1 will fetch the the previous down migration file
 // 		-2 will fetch the next two previous down migration files
 //		-n will fetch ...
+//
+// Fetching down migration files fails at an irreversible migration.
 func (mf *MigrationFiles) From(version uint64, relativeN int) (Files, error) {
 	var d direction.Direction
 	if relativeN > 0 {
@@ -127,7 +269,7 @@ func (mf *MigrationFiles) From(version uint64, relativeN int) (Files, error) {
 	}
 
 	if d == direction.Down {
-		sort.Sort(sort.Reverse(mf))
+		mf.Reverse()
 	} else {
 		sort.Sort(mf)
 	}
@@ -145,9 +287,14 @@ func (mf *MigrationFiles) From(version uint64, relativeN int) (Files, error) {
 			if d == direction.Up && migrationFile.Version > version && migrationFile.UpFile != nil {
 				files = append(files, *migrationFile.UpFile)
 				counter -= 1
-			} else if d == direction.Down && migrationFile.Version <= version && migrationFile.DownFile != nil {
-				files = append(files, *migrationFile.DownFile)
-				counter -= 1
+			} else if d == direction.Down && migrationFile.Version <= version {
+				if err := migrationFile.checkReversible(); err != nil {
+					return nil, err
+				}
+				if migrationFile.DownFile != nil {
+					files = append(files, *migrationFile.DownFile)
+					counter -= 1
+				}
 			}
 		} else {
 			break
@@ -156,8 +303,109 @@ func (mf *MigrationFiles) From(version uint64, relativeN int) (Files, error) {
 	return files, nil
 }
 
+// Between fetches the migration files that take the database from
+// version from (the current one) to version to, no matter the gaps
+// between versions. These are the up migration files after from up to
+// and including to in ascending order if to > from, or the down
+// migration files from from down to, but excluding, to in descending
+// order if to < from, which fails at an irreversible migration.
+func (mf *MigrationFiles) Between(from, to uint64) (Files, error) {
+	files := make(Files, 0)
+	if to > from {
+		sort.Sort(mf)
+		for _, migrationFile := range *mf {
+			if migrationFile.Version > from && migrationFile.Version <= to && migrationFile.UpFile != nil {
+				files = append(files, *migrationFile.UpFile)
+			}
+		}
+	} else if to < from {
+		mf.Reverse()
+		for _, migrationFile := range *mf {
+			if migrationFile.Version <= from && migrationFile.Version > to {
+				if err := migrationFile.checkReversible(); err != nil {
+					return nil, err
+				}
+				if migrationFile.DownFile != nil {
+					files = append(files, *migrationFile.DownFile)
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// ToVersion fetches the migration files that migrate the database from
+// the current version to the target version, in the order to apply them,
+// as Goto does. See Between for how gaps and irreversible migrations are
+// handled.
+func (mf *MigrationFiles) ToVersion(current, target uint64) (Files, error) {
+	return mf.Between(current, target)
+}
+
+// CheckSequence fails if there is a gap between the versions of the
+// migration files, e.g. 0003 is missing between 0002 and 0004. It only
+// makes sense for sequentially numbered migrations.
+func (mf *MigrationFiles) CheckSequence() error {
+	sort.Sort(mf)
+	for i := 1; i < len(*mf); i++ {
+		prev, next := (*mf)[i-1].Version, (*mf)[i].Version
+		if next != prev+1 {
+			return fmt.Errorf("Gap in migration versions: %v is followed by %v, expected %v.", prev, next, prev+1)
+		}
+	}
+	return nil
+}
+
+// FilterByName returns the migration files whose name matches pattern,
+// with filepath.Match semantics, e.g. "*_tenant_*". The versions of the
+// result may have gaps.
+func (mf MigrationFiles) FilterByName(pattern string) (MigrationFiles, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid name filter %q: %v", pattern, err)
+	}
+	files := make(MigrationFiles, 0)
+	for _, migrationFile := range mf {
+		f := migrationFile.UpFile
+		if f == nil {
+			f = migrationFile.DownFile
+		}
+		if f == nil {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, f.Name); ok {
+			files = append(files, migrationFile)
+		}
+	}
+	return files, nil
+}
+
+// Layout tells how the up and down migrations of a version are kept.
+type Layout int
+
+const (
+	// SplitLayout keeps them in two files, e.g. 001_initial.up.sql
+	// and 001_initial.down.sql.
+	SplitLayout Layout = iota
+
+	// CombinedLayout keeps them in one file, e.g. 001_initial.sql,
+	// in sections following -- +migrate Up and -- +migrate Down lines.
+	// The filename regex has to match its files, see CombinedFilenameRegex.
+	CombinedLayout
+)
+
+// ReadOptions tell how migration files are read.
+type ReadOptions struct {
+	Layout Layout
+}
+
 // ReadMigrationFilesFromStore reads all migration files from a given file store
 func ReadMigrationFilesFromStore(store FileStore, path string, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
+	return ReadMigrationFilesFromStoreWithOptions(store, path, filenameRegex, ReadOptions{})
+}
+
+// ReadMigrationFilesFromStoreWithOptions is like ReadMigrationFilesFromStore,
+// reading the files in the layout of options.
+func ReadMigrationFilesFromStoreWithOptions(store FileStore, path string, filenameRegex *regexp.Regexp, options ReadOptions) (files MigrationFiles, err error) {
 	if store == nil {
 		store = &FSStore{}
 	}
@@ -166,6 +414,13 @@ func ReadMigrationFilesFromStore(store FileStore, path string, filenameRegex *re
 	if err != nil {
 		return nil, err
 	}
+	ignore, err := readIgnoreFile(store, path, dirFiles)
+	if err != nil {
+		return nil, err
+	}
+	if options.Layout == CombinedLayout {
+		return readCombinedFiles(store, path, dirFiles, ignore, filenameRegex)
+	}
 	type tmpFile struct {
 		version  uint64
 		name     string
@@ -174,12 +429,38 @@ func ReadMigrationFilesFromStore(store FileStore, path string, filenameRegex *re
 	}
 	tmpFiles := make([]*tmpFile, 0)
 	for _, file := range dirFiles {
+		if ignore.Match(file) {
+			continue
+		}
 		version, name, d, err := parseFilenameSchema(file, filenameRegex)
 		if err == nil {
 			tmpFiles = append(tmpFiles, &tmpFile{version, name, file, d})
 		}
 	}
 
+	// refuse versions shared by several files of the same direction,
+	// e.g. after a bad merge, instead of picking one of them
+	type versionDirection struct {
+		version uint64
+		d       direction.Direction
+	}
+	filenames := make(map[versionDirection][]string)
+	for _, file := range tmpFiles {
+		key := versionDirection{file.version, file.d}
+		filenames[key] = append(filenames[key], file.filename)
+	}
+	conflicts := make([]string, 0)
+	for key, names := range filenames {
+		if len(names) > 1 {
+			sort.Strings(names)
+			conflicts = append(conflicts, fmt.Sprintf("version %v is used by %s", key.version, strings.Join(names, ", ")))
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("Duplicate migration versions in %s: %s.", path, strings.Join(conflicts, "; "))
+	}
+
 	// put tmpFiles into MigrationFile struct
 	parsedVersions := make(map[uint64]bool)
 	newFiles := make(MigrationFiles, 0)
@@ -254,6 +535,80 @@ func ReadMigrationFilesFromStore(store FileStore, path string, filenameRegex *re
 	return newFiles, nil
 }
 
+// readCombinedFiles returns both directions of every file in dirFiles
+// matching filenameRegex, see CombinedLayout.
+func readCombinedFiles(store FileStore, path string, dirFiles []string, ignore ignorePatterns, filenameRegex *regexp.Regexp) (MigrationFiles, error) {
+	files := make(MigrationFiles, 0)
+	filenames := make(map[uint64]string)
+	for _, filename := range dirFiles {
+		if ignore.Match(filename) {
+			continue
+		}
+		matches := filenameRegex.FindStringSubmatch(filename)
+		if len(matches) != 3 || strings.HasSuffix(matches[2], ".up") || strings.HasSuffix(matches[2], ".down") {
+			continue
+		}
+		version, err := strconv.ParseUint(strings.Map(digitsOnly, matches[1]), 10, 0)
+		if err != nil {
+			continue
+		}
+		if other, ok := filenames[version]; ok {
+			return nil, fmt.Errorf("Duplicate migration versions in %s: version %v is used by %s, %s.", path, version, other, filename)
+		}
+		filenames[version] = filename
+
+		mf := MigrationFile{Version: version}
+		for _, d := range []direction.Direction{direction.Up, direction.Down} {
+			f := &File{
+				Path:      path,
+				FileName:  filename,
+				Version:   version,
+				Name:      matches[2],
+				Direction: d,
+				Store:     store,
+				Combined:  true,
+			}
+			if d == direction.Up {
+				mf.UpFile = f
+			} else {
+				mf.DownFile = f
+			}
+		}
+		files = append(files, mf)
+	}
+	sort.Sort(files)
+	return files, nil
+}
+
+// ReadMigrationFilesFromStores reads the migration files of several paths
+// of a given file store and merges them, sorted by version. Every version
+// may only be used in one of the paths.
+func ReadMigrationFilesFromStores(store FileStore, paths []string, filenameRegex *regexp.Regexp) (MigrationFiles, error) {
+	return ReadMigrationFilesFromStoresWithOptions(store, paths, filenameRegex, ReadOptions{})
+}
+
+// ReadMigrationFilesFromStoresWithOptions is like ReadMigrationFilesFromStores,
+// reading the files in the layout of options.
+func ReadMigrationFilesFromStoresWithOptions(store FileStore, paths []string, filenameRegex *regexp.Regexp, options ReadOptions) (MigrationFiles, error) {
+	files := make(MigrationFiles, 0)
+	versionPaths := make(map[uint64]string)
+	for _, path := range paths {
+		pathFiles, err := ReadMigrationFilesFromStoreWithOptions(store, path, filenameRegex, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, mf := range pathFiles {
+			if other, ok := versionPaths[mf.Version]; ok {
+				return nil, fmt.Errorf("Migration version %v is used in both %s and %s.", mf.Version, other, path)
+			}
+			versionPaths[mf.Version] = path
+			files = append(files, mf)
+		}
+	}
+	sort.Sort(files)
+	return files, nil
+}
+
 // ReadMigrationFiles reads all migration files from a given path
 func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
 	return ReadMigrationFilesFromStore(&FSStore{}, path, filenameRegex)
@@ -266,7 +621,7 @@ func parseFilenameSchema(filename string, filenameRegex *regexp.Regexp) (version
 		return 0, "", 0, errors.New("Unable to parse filename schema")
 	}
 
-	version, err = strconv.ParseUint(matches[1], 10, 0)
+	version, err = strconv.ParseUint(strings.Map(digitsOnly, matches[1]), 10, 0)
 	if err != nil {
 		return 0, "", 0, errors.New(fmt.Sprintf("Unable to parse version '%v' in filename schema", matches[0]))
 	}
@@ -282,6 +637,39 @@ func parseFilenameSchema(filename string, filenameRegex *regexp.Regexp) (version
 	return version, matches[2], d, nil
 }
 
+// anyVersionPattern matches sequential and any timestamp versions
+const anyVersionPattern = `[0-9][0-9_-]*`
+
+// ParseFileName fills in the Version, Name and Direction of f from its
+// FileName where they are unset, e.g. for files built by hand instead of
+// read from a store. Filenames that don't follow the schema are left alone.
+func (f *File) ParseFileName(filenameExtension string) {
+	if f.Version != 0 && f.Name != "" && f.Direction != 0 {
+		return
+	}
+	version, name, d, err := parseFilenameSchema(f.FileName, FilenameRegexWithVersion(filenameExtension, anyVersionPattern))
+	if err != nil {
+		return
+	}
+	if f.Version == 0 {
+		f.Version = version
+	}
+	if f.Name == "" {
+		f.Name = name
+	}
+	if f.Direction == 0 {
+		f.Direction = d
+	}
+}
+
+// digitsOnly drops all but digits, for use with strings.Map
+func digitsOnly(r rune) rune {
+	if r >= '0' && r <= '9' {
+		return r
+	}
+	return -1
+}
+
 // Len is the number of elements in the collection.
 // Required by Sort Interface{}
 func (mf MigrationFiles) Len() int {
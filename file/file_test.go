@@ -1,6 +1,8 @@
 package file
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -23,6 +25,9 @@ func TestParseFilenameSchema(t *testing.T) {
 		{"001_test_file.up.sql", "sql", 1, "test_file", direction.Up, false},
 		{"001_test_file.down.sql", "sql", 1, "test_file", direction.Down, false},
 		{"10034_test_file.down.sql", "sql", 10034, "test_file", direction.Down, false},
+		{"002_seed.up.sql.gz", "sql", 2, "seed", direction.Up, false},
+		{"002_seed.down.cql.gz", "cql", 2, "seed", direction.Down, false},
+		{"002_seed.up.gz", "sql", 0, "", direction.Up, true},
 		{"-1_test_file.down.sql", "sql", 0, "", direction.Up, true},
 		{"test_file.down.sql", "sql", 0, "", direction.Up, true},
 		{"100_test_file.down", "sql", 0, "", direction.Up, true},
@@ -57,6 +62,19 @@ func TestParseFilenameSchema(t *testing.T) {
 	}
 }
 
+func TestFilenameRegexWithSeparator(t *testing.T) {
+	version, name, d, err := parseFilenameSchema("0001-add_users.up.sql", FilenameRegexWithSeparator("sql", "", "-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || name != "add_users" || d != direction.Up {
+		t.Errorf("Expected 1, add_users, up, got %v, %v, %v", version, name, d)
+	}
+	if _, _, _, err := parseFilenameSchema("0001_add_users.up.sql", FilenameRegexWithSeparator("sql", "", "-")); err == nil {
+		t.Error("Expected error for the default separator")
+	}
+}
+
 func TestFSFiles(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("/tmp", "TestLookForMigrationFilesInSearchPath")
 	if err != nil {
@@ -213,6 +231,145 @@ func TestFSFiles(t *testing.T) {
 
 }
 
+func TestBetween(t *testing.T) {
+	files := MigrationFiles{}
+	for _, version := range []uint64{10, 1, 5} {
+		files = append(files, MigrationFile{
+			Version:  version,
+			UpFile:   &File{Version: version, Direction: direction.Up},
+			DownFile: &File{Version: version, Direction: direction.Down},
+		})
+	}
+
+	var tests = []struct {
+		from, to       uint64
+		expectVersions []uint64
+		expectDir      direction.Direction
+	}{
+		{0, 10, []uint64{1, 5, 10}, direction.Up},
+		{1, 7, []uint64{5}, direction.Up},
+		{5, 5, []uint64{}, 0},
+		{10, 1, []uint64{10, 5}, direction.Down},
+		{7, 0, []uint64{5, 1}, direction.Down},
+		// 7 was forced, it has no file
+		{7, 10, []uint64{10}, direction.Up},
+		{7, 5, []uint64{}, 0},
+	}
+
+	for _, test := range tests {
+		between, err := files.Between(test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(between) != len(test.expectVersions) {
+			t.Errorf("Expected %v files between %v and %v, got %v", len(test.expectVersions), test.from, test.to, len(between))
+			continue
+		}
+		for i, f := range between {
+			if f.Version != test.expectVersions[i] || f.Direction != test.expectDir {
+				t.Errorf("Expected %v (%v) at %v between %v and %v, got %v (%v)", test.expectVersions[i], test.expectDir, i, test.from, test.to, f.Version, f.Direction)
+			}
+		}
+	}
+}
+
+func TestToVersion(t *testing.T) {
+	files := MigrationFiles{}
+	for _, version := range []uint64{1, 2, 3} {
+		files = append(files, MigrationFile{
+			Version:  version,
+			UpFile:   &File{Version: version, Direction: direction.Up},
+			DownFile: &File{Version: version, Direction: direction.Down},
+		})
+	}
+
+	up, err := files.ToVersion(1, 3)
+	if err != nil || len(up) != 2 || up[0].Version != 2 || up[1].Version != 3 || up[0].Direction != direction.Up {
+		t.Errorf("Expected the up files of 2 and 3, got %v, %v", up, err)
+	}
+	down, err := files.ToVersion(3, 1)
+	if err != nil || len(down) != 2 || down[0].Version != 3 || down[1].Version != 2 || down[0].Direction != direction.Down {
+		t.Errorf("Expected the down files of 3 and 2, got %v, %v", down, err)
+	}
+
+	files[1].DownFile = nil
+	files[1].UpFile.Options = map[string]string{"irreversible": ""}
+	if _, err := files.ToVersion(3, 0); err == nil {
+		t.Error("Expected error for an irreversible migration")
+	}
+}
+
+func TestParseFileName(t *testing.T) {
+	f := File{FileName: "0003_add_users.down.sql"}
+	f.ParseFileName("sql")
+	if f.Version != 3 || f.Name != "add_users" || f.Direction != direction.Down {
+		t.Errorf("Expected version 3, name add_users, direction down, got %v, %v, %v", f.Version, f.Name, f.Direction)
+	}
+
+	f = File{FileName: "2006_01_02_150405_backfill.up.sql", Name: "keep"}
+	f.ParseFileName("sql")
+	if f.Version != 20060102150405 || f.Name != "keep" || f.Direction != direction.Up {
+		t.Errorf("Expected timestamp version, the given name and direction up, got %v, %v, %v", f.Version, f.Name, f.Direction)
+	}
+
+	f = File{FileName: "notes.txt"}
+	f.ParseFileName("sql")
+	if f.Version != 0 || f.Name != "" || f.Direction != 0 {
+		t.Errorf("Expected nothing to be filled in, got %+v", f)
+	}
+}
+
+func TestCheckSequence(t *testing.T) {
+	files := MigrationFiles{{Version: 2}, {Version: 1}, {Version: 3}}
+	if err := files.CheckSequence(); err != nil {
+		t.Error(err)
+	}
+	files = append(files, MigrationFile{Version: 5})
+	if err := files.CheckSequence(); err == nil {
+		t.Error("Expected error for missing version 4")
+	}
+}
+
+func TestFilterByName(t *testing.T) {
+	files := MigrationFiles{
+		{Version: 1, UpFile: &File{Name: "users"}, DownFile: &File{Name: "users"}},
+		{Version: 2, UpFile: &File{Name: "add_tenant_id"}},
+		{Version: 3, DownFile: &File{Name: "drop_tenant_table"}},
+		{Version: 4},
+	}
+	filtered, err := files.FilterByName("*_tenant_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 2 || filtered[0].Version != 2 || filtered[1].Version != 3 {
+		t.Errorf("Expected versions 2 and 3, got %v", filtered)
+	}
+	if _, err := files.FilterByName("[users"); err == nil {
+		t.Error("Expected error for an invalid pattern")
+	}
+}
+
+func TestFSFilesDuplicateVersion(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestFSFilesDuplicateVersion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_init.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_a.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_b.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_a.down.sql"), nil, 0755)
+
+	_, err = ReadMigrationFiles(tmpdir, FilenameRegex("sql"))
+	if err == nil {
+		t.Fatal("Expected error for duplicate version 2")
+	}
+	if !strings.Contains(err.Error(), "version 2 is used by 0002_a.up.sql, 0002_b.up.sql") {
+		t.Errorf("Expected error to list the conflicting files, got %v", err)
+	}
+}
+
 func TestAssetFiles(t *testing.T) {
 	assetFiles := map[string][]byte{
 		"tmp/002_migrationfile.up.sql":   []byte{},
@@ -376,3 +533,72 @@ func TestAssetFiles(t *testing.T) {
 		t.Error("ToFirstFrom() did not return UpFiles")
 	}
 }
+
+func TestReadContentGzip(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestReadContentGzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("INSERT INTO seed VALUES (1);"))
+	w.Close()
+	ioutil.WriteFile(path.Join(tmpdir, "001_seed.up.sql.gz"), buf.Bytes(), 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "002_broken.up.sql.gz"), []byte("not gzipped"), 0755)
+
+	files, err := ReadMigrationFiles(tmpdir, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 migration files, got %v", len(files))
+	}
+	if err := files[0].UpFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if content := string(files[0].UpFile.Content); content != "INSERT INTO seed VALUES (1);" {
+		t.Errorf("Expected decompressed content, got %q", content)
+	}
+	if err := files[1].UpFile.ReadContent(); err == nil {
+		t.Error("Expected error for content that isn't gzipped")
+	}
+}
+
+func TestReadMigrationFilesFromStores(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestReadMigrationFilesFromStores")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	users, billing := path.Join(tmpdir, "users"), path.Join(tmpdir, "billing")
+	os.Mkdir(users, 0755)
+	os.Mkdir(billing, 0755)
+	ioutil.WriteFile(path.Join(users, "001_users.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(users, "003_emails.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(billing, "002_invoices.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(billing, "002_invoices.down.sql"), nil, 0755)
+
+	files, err := ReadMigrationFilesFromStores(&FSStore{}, []string{users, billing}, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 migration files, got %v", len(files))
+	}
+	for i, mf := range files {
+		if mf.Version != uint64(i+1) {
+			t.Errorf("Expected version %v at %v, got %v", i+1, i, mf.Version)
+		}
+	}
+	if files[1].UpFile.Path != billing || files[1].DownFile.Path != billing {
+		t.Errorf("Expected version 2 in %s, got %s", billing, files[1].UpFile.Path)
+	}
+
+	ioutil.WriteFile(path.Join(billing, "003_payments.up.sql"), nil, 0755)
+	if _, err := ReadMigrationFilesFromStores(&FSStore{}, []string{users, billing}, FilenameRegex("sql")); err == nil {
+		t.Error("Expected error for a version used in both paths")
+	}
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"io/fs"
 	"io/ioutil"
 	"path"
 )
@@ -14,16 +15,50 @@ type FileStore interface {
 	ReadDir(string) ([]string, error)
 }
 
-// FSStore is a regular file system store
-type FSStore struct{}
+// WritableStore is a FileStore that new migration files can be written
+// to, e.g. by Migrator.Create.
+type WritableStore interface {
+	FileStore
+	// Write content to the file at path, replacing it if it exists
+	Write(path string, content []byte) error
+}
+
+// FSStore is a regular file system store, or if FS is set, a store
+// backed by an fs.FS, e.g. migrations embedded with go:embed:
+//
+// 	//go:embed migrations
+// 	var migrations embed.FS
+//
+// 	m.Store = file.FSStore{FS: migrations, Dir: "migrations"}
+type FSStore struct {
+	// FS is the file system to read from, the regular one if nil
+	FS fs.FS
+
+	// Dir is prepended to all paths read from FS
+	Dir string
+}
 
 // Read contents of a file
 func (s FSStore) ReadFile(f *File) ([]byte, error) {
+	if s.FS != nil {
+		return fs.ReadFile(s.FS, path.Join(s.Dir, f.Path, f.FileName))
+	}
 	return ioutil.ReadFile(path.Join(f.Path, f.FileName))
 }
 
 // List file in a given dir
 func (s FSStore) ReadDir(dirname string) ([]string, error) {
+	if s.FS != nil {
+		entries, err := fs.ReadDir(s.FS, path.Join(s.Dir, dirname))
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, len(entries))
+		for i := range entries {
+			res[i] = entries[i].Name()
+		}
+		return res, nil
+	}
 	if fs, err := ioutil.ReadDir(dirname); err != nil {
 		return nil, err
 	} else {
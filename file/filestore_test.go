@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"testing/fstest"
 
 	. "github.com/onsi/gomega"
 )
@@ -78,6 +79,39 @@ func TestFSStore(t *testing.T) {
 	Ω(bs).Should(BeNil())
 }
 
+func TestFSStoreWithFS(t *testing.T) {
+	RegisterTestingT(t)
+
+	store := FSStore{
+		FS: fstest.MapFS{
+			"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+			"migrations/0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+			"migrations/x/b":             {Data: []byte("b")},
+			"other/0002_b.up.sql":        {Data: []byte("CREATE TABLE b ();")},
+		},
+		Dir: "migrations",
+	}
+
+	files, err := store.ReadDir(".")
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(files).Should(ConsistOf("0001_a.up.sql", "0001_a.down.sql", "x"))
+
+	files, err = store.ReadDir("XXX")
+	Ω(err).Should(HaveOccurred())
+	Ω(files).Should(BeNil())
+
+	bs, err := store.ReadFile(&File{Path: "x", FileName: "b"})
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(bs).Should(Equal([]byte("b")))
+
+	migrationFiles, err := ReadMigrationFilesFromStore(store, ".", FilenameRegex("sql"))
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(migrationFiles).Should(HaveLen(1))
+	up := migrationFiles[0].UpFile
+	Ω(up.ReadContent()).Should(Succeed())
+	Ω(up.Content).Should(Equal([]byte("CREATE TABLE a ();")))
+}
+
 func TestAssetStore(t *testing.T) {
 	RegisterTestingT(t)
 
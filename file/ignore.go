@@ -0,0 +1,77 @@
+package file
+
+import (
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the name of the file listing patterns of files
+// in the migrations path that should not be treated as migrations.
+const IgnoreFileName = ".migrateignore"
+
+// ignorePattern is a single parsed line of an ignore file.
+type ignorePattern struct {
+	pattern string
+	negate  bool
+}
+
+// ignorePatterns is an ordered list of gitignore-style patterns.
+// Patterns are matched against the base filename only.
+type ignorePatterns []ignorePattern
+
+// parseIgnoreFile parses the content of an ignore file.
+// Empty lines and lines starting with # are skipped, a leading !
+// negates the pattern, leading and trailing slashes are dropped.
+func parseIgnoreFile(content []byte) ignorePatterns {
+	patterns := make(ignorePatterns, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// escaped leading ! or #
+			line = line[1:]
+		}
+		line = strings.Trim(line, "/")
+		if line == "" {
+			continue
+		}
+		p.pattern = line
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// Match reports whether filename should be ignored.
+// As in gitignore, the last matching pattern wins.
+func (ps ignorePatterns) Match(filename string) bool {
+	name := path.Base(filename)
+	ignored := false
+	for _, p := range ps {
+		if ok, err := path.Match(p.pattern, name); err == nil && ok {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// readIgnoreFile reads the ignore file from a given path of the store,
+// if dirFiles lists one.
+func readIgnoreFile(store FileStore, path string, dirFiles []string) (ignorePatterns, error) {
+	for _, name := range dirFiles {
+		if name == IgnoreFileName {
+			content, err := store.ReadFile(&File{Path: path, FileName: IgnoreFileName})
+			if err != nil {
+				return nil, err
+			}
+			return parseIgnoreFile(content), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,67 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	patterns := parseIgnoreFile([]byte(`
+# scratch files
+scratch_*.sql
+/*.bak
+tmp/
+
+*.down.sql
+!001_*.down.sql
+\#literal.sql
+`))
+
+	var tests = []struct {
+		filename     string
+		expectIgnore bool
+	}{
+		{"scratch_1.sql", true},
+		{"scratch_.sql", true},
+		{"001_scratch_1.up.sql", false},
+		{"old.bak", true},
+		{"tmp", true},
+		{"002_foo.down.sql", true},
+		{"001_foo.down.sql", false},
+		{"001_foo.up.sql", false},
+		{"#literal.sql", true},
+		{"sub/scratch_2.sql", true},
+	}
+
+	for _, test := range tests {
+		if ignored := patterns.Match(test.filename); ignored != test.expectIgnore {
+			t.Errorf("Expected %v to be ignored: %v, got %v", test.filename, test.expectIgnore, ignored)
+		}
+	}
+}
+
+func TestFSFilesIgnore(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestFSFilesIgnore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, IgnoreFileName), []byte("002_*\n"), 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "001_migrationfile.up.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "001_migrationfile.down.sql"), nil, 0755)
+	ioutil.WriteFile(path.Join(tmpdir, "002_scratch.up.sql"), nil, 0755)
+
+	files, err := ReadMigrationFiles(tmpdir, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 migration file, got %v", len(files))
+	}
+	if files[0].Version != 1 {
+		t.Errorf("Expected version 1, got %v", files[0].Version)
+	}
+}
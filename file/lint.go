@@ -0,0 +1,152 @@
+package file
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LintWarning is a likely problem with a migration file found by Lint.
+type LintWarning struct {
+	Version  uint64
+	FileName string
+	Message  string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.FileName, w.Message)
+}
+
+var (
+	lintCommentRegex   = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	lintCreateRegex    = regexp.MustCompile(`^create\s+(?:or\s+replace\s+)?(?:temp(?:orary)?\s+|unlogged\s+)?(table|materialized\s+view|view|sequence|type|function|schema|extension)\s+(?:if\s+not\s+exists\s+)?([\w.]+)`)
+	lintIndexRegex     = regexp.MustCompile(`^create\s+(?:unique\s+)?index\s+(?:concurrently\s+)?(?:if\s+not\s+exists\s+)?([\w.]+)\s+on\s+(?:only\s+)?([\w.]+)`)
+	lintAlterRegex     = regexp.MustCompile(`^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w.]+)\s+(.*)$`)
+	lintAddRegex       = regexp.MustCompile(`(?:^|,)\s*add\s+(?:(constraint)\s+([\w]+)|(?:column\s+)?(?:if\s+not\s+exists\s+)?([\w]+))`)
+	lintDropRegex      = regexp.MustCompile(`^drop\s+(table|materialized\s+view|view|sequence|type|function|schema|extension|index)\s+(?:concurrently\s+)?(?:if\s+exists\s+)?([\w.,\s]+)`)
+	lintDropPartRegex  = regexp.MustCompile(`(?:^|,)\s*drop\s+(?:(constraint)\s+(?:if\s+exists\s+)?([\w]+)|(?:column\s+)?(?:if\s+exists\s+)?([\w]+))`)
+	lintWhitespaceExpr = regexp.MustCompile(`\s+`)
+)
+
+// lintUnnamedConstraints are keywords following ADD that add a
+// constraint without a name rather than a column.
+var lintUnnamedConstraints = map[string]bool{
+	"primary": true, "unique": true, "foreign": true, "check": true, "exclude": true,
+}
+
+// Lint reads the content of all migration files and checks, on a best
+// effort basis, that the objects an up file creates (tables, indexes,
+// columns, ...) are dropped again by the down file of the same version.
+func Lint(files MigrationFiles) ([]LintWarning, error) {
+	warnings := make([]LintWarning, 0)
+	for _, mf := range files {
+		if mf.UpFile == nil || mf.DownFile == nil {
+			continue
+		}
+		if err := mf.UpFile.ReadContent(); err != nil {
+			return nil, err
+		}
+		if err := mf.DownFile.ReadContent(); err != nil {
+			return nil, err
+		}
+		for _, object := range lintDownSymmetry(mf.UpFile.Content, mf.DownFile.Content) {
+			warnings = append(warnings, LintWarning{
+				Version:  mf.Version,
+				FileName: mf.DownFile.FileName,
+				Message:  fmt.Sprintf("%s is created in %s, but does not seem to be dropped", object, mf.UpFile.FileName),
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// lintObject is a database object created by a migration
+type lintObject struct {
+	kind  string
+	name  string
+	table string // for columns, constraints and indexes
+}
+
+func (o lintObject) String() string {
+	if o.table != "" && o.kind != "index" {
+		return o.kind + " " + o.table + "." + o.name
+	}
+	return o.kind + " " + o.name
+}
+
+// lintDownSymmetry returns the objects created in up that are not
+// dropped in down. Dropping a table drops its columns, constraints
+// and indexes as well.
+func lintDownSymmetry(up, down []byte) []lintObject {
+	created := make([]lintObject, 0)
+	for _, stmt := range lintStatements(up) {
+		if m := lintCreateRegex.FindStringSubmatch(stmt); m != nil {
+			created = append(created, lintObject{kind: m[1], name: lintName(m[2])})
+		} else if m := lintIndexRegex.FindStringSubmatch(stmt); m != nil {
+			created = append(created, lintObject{kind: "index", name: lintName(m[1]), table: lintName(m[2])})
+		} else if m := lintAlterRegex.FindStringSubmatch(stmt); m != nil {
+			for _, add := range lintAddRegex.FindAllStringSubmatch(m[2], -1) {
+				if add[1] != "" {
+					created = append(created, lintObject{kind: "constraint", name: add[2], table: lintName(m[1])})
+				} else if !lintUnnamedConstraints[add[3]] {
+					created = append(created, lintObject{kind: "column", name: add[3], table: lintName(m[1])})
+				}
+			}
+		}
+	}
+
+	dropped := make(map[lintObject]bool)
+	for _, stmt := range lintStatements(down) {
+		if m := lintDropRegex.FindStringSubmatch(stmt); m != nil {
+			for _, name := range strings.Split(m[2], ",") {
+				name = strings.Fields(strings.TrimSpace(name) + " ")[0]
+				dropped[lintObject{kind: m[1], name: lintName(name)}] = true
+			}
+		} else if m := lintAlterRegex.FindStringSubmatch(stmt); m != nil {
+			for _, drop := range lintDropPartRegex.FindAllStringSubmatch(m[2], -1) {
+				if drop[1] != "" {
+					dropped[lintObject{kind: "constraint", name: drop[2], table: lintName(m[1])}] = true
+				} else {
+					dropped[lintObject{kind: "column", name: drop[3], table: lintName(m[1])}] = true
+				}
+			}
+		}
+	}
+
+	missing := make([]lintObject, 0)
+	for _, o := range created {
+		if o.table != "" && dropped[lintObject{kind: "table", name: o.table}] {
+			continue
+		}
+		if o.kind == "index" && dropped[lintObject{kind: "index", name: o.name}] {
+			continue
+		}
+		if !dropped[o] {
+			missing = append(missing, o)
+		}
+	}
+	return missing
+}
+
+// lintStatements returns the lower cased statements of content with
+// comments, quotes and redundant whitespace removed.
+func lintStatements(content []byte) []string {
+	sql := lintCommentRegex.ReplaceAllString(string(content), " ")
+	sql = strings.NewReplacer(`"`, "", "`", "").Replace(strings.ToLower(sql))
+	statements := make([]string, 0)
+	for _, stmt := range strings.Split(sql, ";") {
+		stmt = strings.TrimSpace(lintWhitespaceExpr.ReplaceAllString(stmt, " "))
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+// lintName drops the schema of a qualified name
+func lintName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,84 @@
+package file
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintDownSymmetry(t *testing.T) {
+	var tests = []struct {
+		up            string
+		down          string
+		expectMissing []string
+	}{
+		{"", "", []string{}},
+		{
+			`CREATE TABLE IF NOT EXISTS "Users" (id serial); -- CREATE TABLE commented;
+			CREATE UNIQUE INDEX CONCURRENTLY users_id_idx ON public.users (id);`,
+			`DROP TABLE users;`,
+			[]string{},
+		},
+		{
+			`CREATE TABLE users (id serial); CREATE INDEX users_id_idx ON users (id);`,
+			`DROP INDEX users_id_idx;`,
+			[]string{"table users"},
+		},
+		{
+			`ALTER TABLE users ADD COLUMN email text, ADD name text, ADD CONSTRAINT users_email_key UNIQUE (email), ADD PRIMARY KEY (id);`,
+			`ALTER TABLE users DROP COLUMN IF EXISTS email;`,
+			[]string{"column users.name", "constraint users.users_email_key"},
+		},
+		{
+			`ALTER TABLE users ADD COLUMN email text;`,
+			`ALTER TABLE users DROP email; /* DROP TABLE users; */`,
+			[]string{},
+		},
+		{
+			`CREATE OR REPLACE FUNCTION f() RETURNS int AS 'select 1' LANGUAGE sql;
+			CREATE MATERIALIZED VIEW mv AS SELECT 1;
+			CREATE TYPE mood AS ENUM ('sad', 'ok');`,
+			`DROP FUNCTION IF EXISTS f(); DROP TYPE mood, other;`,
+			[]string{"materialized view mv"},
+		},
+	}
+
+	for _, test := range tests {
+		missing := make([]string, 0)
+		for _, o := range lintDownSymmetry([]byte(test.up), []byte(test.down)) {
+			missing = append(missing, o.String())
+		}
+		if !reflect.DeepEqual(missing, test.expectMissing) {
+			t.Errorf("Expected missing %v, got %v for up %q", test.expectMissing, missing, test.up)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	files := MigrationFiles{
+		{
+			Version:  1,
+			UpFile:   &File{FileName: "001_a.up.sql", Content: []byte("CREATE TABLE a (id int);")},
+			DownFile: &File{FileName: "001_a.down.sql", Content: []byte("DROP TABLE a;")},
+		},
+		{
+			Version:  2,
+			UpFile:   &File{FileName: "002_b.up.sql", Content: []byte("ALTER TABLE a ADD COLUMN b int;")},
+			DownFile: &File{FileName: "002_b.down.sql", Content: []byte("SELECT 1;")},
+		},
+		{
+			Version: 3,
+			UpFile:  &File{FileName: "003_c.up.sql", Content: []byte("CREATE TABLE c (id int);")},
+		},
+	}
+
+	warnings, err := Lint(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", warnings)
+	}
+	if warnings[0].Version != 2 || warnings[0].FileName != "002_b.down.sql" {
+		t.Errorf("Unexpected warning %v", warnings[0])
+	}
+}
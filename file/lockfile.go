@@ -0,0 +1,118 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LockFileName is the default name of a lock file.
+const LockFileName = "migrate.lock"
+
+// LockEntry is the checksum of one migration file in a lock file.
+type LockEntry struct {
+	Version  uint64
+	FileName string
+	Checksum string
+}
+
+// LockFile lists the checksums of a reviewed set of migration files,
+// so that tampered, added or missing files can be detected later on.
+// It is written in the format of sha256sum, one file per line:
+//
+//	9f86d081884c7d65...  0001_users.up.sql
+type LockFile []LockEntry
+
+// LockMismatch is a difference between a lock file and what it is
+// compared against.
+type LockMismatch struct {
+	FileName string
+	Message  string
+}
+
+func (m LockMismatch) String() string {
+	return fmt.Sprintf("%s: %s", m.FileName, m.Message)
+}
+
+// NewLockFile reads the content of all migration files
+// and returns their lock file.
+func NewLockFile(files MigrationFiles) (LockFile, error) {
+	lock := make(LockFile, 0)
+	for _, mf := range files {
+		for _, f := range []*File{mf.UpFile, mf.DownFile} {
+			if f == nil {
+				continue
+			}
+			if err := f.ReadContent(); err != nil {
+				return nil, err
+			}
+			lock = append(lock, LockEntry{f.Version, f.FileName, Checksum(f.Content)})
+		}
+	}
+	sort.Slice(lock, func(i, j int) bool { return lock[i].FileName < lock[j].FileName })
+	return lock, nil
+}
+
+// ParseLockFile parses a lock file. Versions are parsed from the
+// filenames, which have to match filenameRegex.
+func ParseLockFile(content []byte, filenameRegex *regexp.Regexp) (LockFile, error) {
+	lock := make(LockFile, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Unable to parse line %v of lock file.", lineNo)
+		}
+		version, _, _, err := parseFilenameSchema(fields[1], filenameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse filename in line %v of lock file: %v", lineNo, err)
+		}
+		lock = append(lock, LockEntry{version, fields[1], fields[0]})
+	}
+	return lock, scanner.Err()
+}
+
+// Bytes returns the content of the lock file.
+func (l LockFile) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Checksums of the reviewed migration files, verify with 'migrate verify-lock'.\n")
+	for _, e := range l {
+		fmt.Fprintf(&buf, "%s  %s\n", e.Checksum, e.FileName)
+	}
+	return buf.Bytes()
+}
+
+// Compare returns every file whose checksum differs between the lock
+// file and files, or which is listed in only one of them.
+func (l LockFile) Compare(files LockFile) []LockMismatch {
+	locked := make(map[string]string, len(l))
+	for _, e := range l {
+		locked[e.FileName] = e.Checksum
+	}
+	mismatches := make([]LockMismatch, 0)
+	seen := make(map[string]bool, len(files))
+	for _, e := range files {
+		seen[e.FileName] = true
+		checksum, ok := locked[e.FileName]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, LockMismatch{e.FileName, "not in lock file"})
+		case checksum != e.Checksum:
+			mismatches = append(mismatches, LockMismatch{e.FileName, fmt.Sprintf("checksum %s differs from %s in lock file", e.Checksum, checksum)})
+		}
+	}
+	for _, e := range l {
+		if !seen[e.FileName] {
+			mismatches = append(mismatches, LockMismatch{e.FileName, "in lock file, but missing"})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].FileName < mismatches[j].FileName })
+	return mismatches
+}
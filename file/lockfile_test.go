@@ -0,0 +1,50 @@
+package file
+
+import (
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	files := MigrationFiles{
+		{Version: 1,
+			UpFile:   &File{Version: 1, FileName: "0001_foo.up.sql", Content: []byte("CREATE TABLE foo ();")},
+			DownFile: &File{Version: 1, FileName: "0001_foo.down.sql", Content: []byte("DROP TABLE foo;")}},
+		{Version: 2,
+			UpFile: &File{Version: 2, FileName: "0002_bar.up.sql", Content: []byte("CREATE TABLE bar ();")}},
+	}
+	lock, err := NewLockFile(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseLockFile(lock.Bytes(), FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 3 || parsed[2].Version != 2 || parsed[2].Checksum != Checksum([]byte("CREATE TABLE bar ();")) {
+		t.Fatalf("Unexpected lock file %v", parsed)
+	}
+	if mismatches := parsed.Compare(lock); len(mismatches) != 0 {
+		t.Errorf("Expected no mismatches, got %v", mismatches)
+	}
+
+	files[0].UpFile.Content = []byte("CREATE TABLE foo (id int);")
+	files[1].UpFile = nil
+	files = append(files, MigrationFile{Version: 3,
+		UpFile: &File{Version: 3, FileName: "0003_baz.up.sql", Content: []byte("SELECT 1;")}})
+	tampered, err := NewLockFile(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches := parsed.Compare(tampered)
+	if len(mismatches) != 3 ||
+		mismatches[0].FileName != "0001_foo.up.sql" ||
+		mismatches[1].FileName != "0002_bar.up.sql" ||
+		mismatches[2].FileName != "0003_baz.up.sql" {
+		t.Errorf("Unexpected mismatches %v", mismatches)
+	}
+
+	if _, err := ParseLockFile([]byte("abc\n"), FilenameRegex("sql")); err == nil {
+		t.Error("Expected error for invalid line")
+	}
+}
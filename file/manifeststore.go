@@ -0,0 +1,93 @@
+package file
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ManifestStore is a store backed by a single JSON manifest mapping
+// file paths to their base64 encoded content:
+//
+//	{
+//	  "0001_users.up.sql": "Q1JFQVRFIFRBQkxFIHVzZXJzICguLi4pOw==",
+//	  "0001_users.down.sql": "RFJPUCBUQUJMRSB1c2Vyczs="
+//	}
+//
+// Paths are relative to the manifest; use "." (or "") as migrations
+// path for files at its top level.
+type ManifestStore struct {
+	files map[string][]byte
+}
+
+// NewManifestStore parses a manifest and decodes the content of its files.
+func NewManifestStore(manifest []byte) (*ManifestStore, error) {
+	encoded := make(map[string]string)
+	if err := json.Unmarshal(manifest, &encoded); err != nil {
+		return nil, fmt.Errorf("Unable to parse manifest: %v", err)
+	}
+	s := &ManifestStore{files: make(map[string][]byte, len(encoded))}
+	for name, content := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode content of %s in manifest: %v", name, err)
+		}
+		s.files[path.Clean(name)] = decoded
+	}
+	return s, nil
+}
+
+// ReadManifestFile reads a manifest from the file system.
+func ReadManifestFile(filename string) (*ManifestStore, error) {
+	manifest, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewManifestStore(manifest)
+}
+
+// Read contents of a file
+func (s ManifestStore) ReadFile(f *File) ([]byte, error) {
+	name := path.Join(f.Path, f.FileName)
+	content, ok := s.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return content, nil
+}
+
+// List files and subdirectories in a given dir
+func (s ManifestStore) ReadDir(dirname string) ([]string, error) {
+	dir := path.Clean(dirname)
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	found := make(map[string]bool)
+	for name := range s.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i]
+		}
+		found[rest] = true
+	}
+	if len(found) == 0 {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+
+	res := make([]string, 0, len(found))
+	for name := range found {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
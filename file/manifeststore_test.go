@@ -0,0 +1,47 @@
+package file
+
+import (
+	"testing"
+)
+
+func TestManifestStore(t *testing.T) {
+	// CREATE TABLE foo (); / DROP TABLE foo; / SELECT 1;
+	s, err := NewManifestStore([]byte(`{
+		"db/0002_bar.up.sql": "U0VMRUNUIDE7",
+		"db/0001_foo.up.sql": "Q1JFQVRFIFRBQkxFIGZvbyAoKTs=",
+		"db/0001_foo.down.sql": "RFJPUCBUQUJMRSBmb287",
+		"README": ""
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := s.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "README" || names[1] != "db" {
+		t.Errorf("Unexpected top level entries %v", names)
+	}
+
+	files, err := ReadMigrationFilesFromStore(s, "db/", FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Version != 1 || files[1].Version != 2 {
+		t.Fatalf("Expected versions 1 and 2, got %v", files)
+	}
+	if err := files[0].DownFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(files[0].DownFile.Content) != "DROP TABLE foo;" {
+		t.Errorf("Unexpected content %q", files[0].DownFile.Content)
+	}
+
+	if _, err := s.ReadDir("other"); err == nil {
+		t.Error("Expected error for unknown directory")
+	}
+	if _, err := NewManifestStore([]byte(`{"0001_foo.up.sql": "not base64!"}`)); err == nil {
+		t.Error("Expected error for invalid content")
+	}
+}
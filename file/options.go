@@ -0,0 +1,105 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// optionRegex matches a directive comment like `-- migrate:<key> <value>`
+var optionRegex = regexp.MustCompile(`^--\s*migrate:([a-zA-Z0-9_-]+)(?:\s+(.*))?$`)
+
+// ParseOptions reads the `-- migrate:<key> <value>` directives from the
+// leading comment lines of a migration file. Parsing stops at the first
+// line which is neither empty nor a comment. A key without a value
+// maps to an empty string.
+//
+// Example:
+// 	-- migrate:lock_timeout 5s
+// 	-- migrate:isolation read committed
+// 	ALTER TABLE ...
+func ParseOptions(content []byte) map[string]string {
+	options := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if matches := optionRegex.FindStringSubmatch(line); matches != nil {
+			options[matches[1]] = strings.TrimSpace(matches[2])
+		}
+	}
+	return options
+}
+
+// SkipIf returns the query of a skip-if directive, which makes drivers
+// skip the migration, but still record it, if the query returns a row:
+//
+//	-- migrate:skip-if SELECT 1 FROM information_schema.columns WHERE ...
+//
+// Options have to be parsed already, see ReadContent.
+func (f *File) SkipIf() (query string, ok bool) {
+	query, ok = f.Options["skip-if"]
+	query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	return query, ok && query != ""
+}
+
+// Timeout returns the duration of a timeout directive, which overrides
+// the statement timeout for the migration, or 0 if there is none:
+//
+//	-- migrate:timeout 2h
+//
+// Options have to be parsed already, see ReadContent.
+func (f *File) Timeout() (time.Duration, error) {
+	value, ok := f.Options["timeout"]
+	if !ok {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid timeout directive '%s' in %s.", value, f.FileName)
+	}
+	return timeout, nil
+}
+
+// Transaction reports whether the migration should run in a transaction,
+// which it does unless it opts out with a transaction directive, e.g. for
+// statements that can't run in one:
+//
+//	-- migrate:transaction false
+//
+// Options have to be parsed already, see ReadContent.
+func (f *File) Transaction() (bool, error) {
+	value, ok := f.Options["transaction"]
+	if !ok {
+		return true, nil
+	}
+	transaction, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid transaction directive '%s' in %s.", value, f.FileName)
+	}
+	return transaction, nil
+}
+
+// IrreversibleDirective marks a migration that has no down file on
+// purpose, e.g. a data backfill. Rolling it back fails instead of
+// skipping it.
+const IrreversibleDirective = "-- migrate:irreversible"
+
+// Irreversible reports whether the migration is marked irreversible:
+//
+//	-- migrate:irreversible
+//
+// Options have to be parsed already, see ReadContent.
+func (f *File) Irreversible() bool {
+	_, ok := f.Options["irreversible"]
+	return ok
+}
@@ -0,0 +1,106 @@
+package file
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	var tests = []struct {
+		content       string
+		expectOptions map[string]string
+	}{
+		{"", map[string]string{}},
+		{"CREATE TABLE foo ();", map[string]string{}},
+		{"-- migrate:lock_timeout 5s\nCREATE TABLE foo ();", map[string]string{"lock_timeout": "5s"}},
+		{`
+			-- header comment
+			--migrate:isolation   read committed
+			-- migrate:transaction false
+
+			-- migrate:no-value
+			SELECT 1;
+			-- migrate:ignored after statement
+		`, map[string]string{"isolation": "read committed", "transaction": "false", "no-value": ""}},
+		{"SELECT 1;\n-- migrate:lock_timeout 5s", map[string]string{}},
+	}
+
+	for _, test := range tests {
+		if options := ParseOptions([]byte(test.content)); !reflect.DeepEqual(options, test.expectOptions) {
+			t.Errorf("Expected options %v, got %v for %q", test.expectOptions, options, test.content)
+		}
+	}
+}
+
+func TestSkipIf(t *testing.T) {
+	var tests = []struct {
+		content     string
+		expectQuery string
+		expectOk    bool
+	}{
+		{"ALTER TABLE foo ADD COLUMN bar int;", "", false},
+		{"-- migrate:skip-if\nSELECT 1;", "", false},
+		{"-- migrate:skip-if SELECT 1 FROM information_schema.columns WHERE column_name = 'bar';\nALTER TABLE foo ADD COLUMN bar int;",
+			"SELECT 1 FROM information_schema.columns WHERE column_name = 'bar'", true},
+	}
+
+	for _, test := range tests {
+		f := File{Content: []byte(test.content)}
+		if err := f.ReadContent(); err != nil {
+			t.Fatal(err)
+		}
+		if query, ok := f.SkipIf(); query != test.expectQuery || ok != test.expectOk {
+			t.Errorf("Expected %q, %v, got %q, %v for %q", test.expectQuery, test.expectOk, query, ok, test.content)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var tests = []struct {
+		content       string
+		expectTimeout time.Duration
+		expectError   bool
+	}{
+		{"UPDATE foo SET bar = 1;", 0, false},
+		{"-- migrate:timeout 2h\nUPDATE foo SET bar = 1;", 2 * time.Hour, false},
+		{"-- migrate:timeout 1m30s\nUPDATE foo SET bar = 1;", 90 * time.Second, false},
+		{"-- migrate:timeout forever\nUPDATE foo SET bar = 1;", 0, true},
+		{"-- migrate:timeout -1s\nUPDATE foo SET bar = 1;", 0, true},
+	}
+
+	for _, test := range tests {
+		f := File{Content: []byte(test.content)}
+		if err := f.ReadContent(); err != nil {
+			t.Fatal(err)
+		}
+		timeout, err := f.Timeout()
+		if (err != nil) != test.expectError || timeout != test.expectTimeout {
+			t.Errorf("Expected %v (error %v), got %v, %v for %q", test.expectTimeout, test.expectError, timeout, err, test.content)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	var tests = []struct {
+		content           string
+		expectTransaction bool
+		expectError       bool
+	}{
+		{"CREATE INDEX foo ON bar (baz);", true, false},
+		{"-- migrate:transaction false\nCREATE INDEX CONCURRENTLY foo ON bar (baz);", false, false},
+		{"-- migrate:transaction true\nCREATE INDEX foo ON bar (baz);", true, false},
+		{"-- migrate:transaction\nCREATE INDEX foo ON bar (baz);", false, true},
+	}
+
+	for _, test := range tests {
+		f := File{Content: []byte(test.content)}
+		if err := f.ReadContent(); err != nil {
+			t.Fatal(err)
+		}
+		transaction, err := f.Transaction()
+		if (err != nil) != test.expectError || transaction != test.expectTransaction {
+			t.Errorf("Expected %v (error %v), got %v, %v for %q", test.expectTransaction, test.expectError, transaction, err, test.content)
+		}
+	}
+}
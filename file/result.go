@@ -0,0 +1,24 @@
+package file
+
+import (
+	"time"
+)
+
+// MigrationResult is sent down the pipe by drivers that time their
+// migrations, after a migration file was applied successfully.
+type MigrationResult struct {
+	// the applied migration file
+	File File
+
+	// how long applying it took, including the transaction
+	Duration time.Duration
+}
+
+// StatementProgress is sent down the pipe by drivers that run the
+// statements of a migration file one by one, before each statement:
+// it is the Current one of Total statements of File.
+type StatementProgress struct {
+	File    File
+	Current int
+	Total   int
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/PlanitarInc/migrate/migrate"
+	"github.com/fatih/color"
+)
+
+// fleetResult is the outcome of migrating one database of a fleet
+type fleetResult struct {
+	url  string
+	errs []error
+}
+
+// readUrlsFile reads one url per line, skipping empty lines
+// and lines starting with #.
+func readUrlsFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// upFleet applies the pending migrations to every database listed in
+// the -urls-file, on up to -concurrency databases at once, and prints a
+// report once all are done. It reports whether all of them succeeded.
+func (cli CliOptions) upFleet(since uint64) bool {
+	urls, err := readUrlsFile(*urlsFile)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	n := *concurrency
+	if n < 1 {
+		n = 1
+	}
+
+	results := make([]fleetResult, len(urls))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		m := cli.M
+		m.Url = url
+		results[i].url = m.RedactedUrl()
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, m migrate.Migrator) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fmt.Printf("> %s\n", results[i].url)
+			results[i].errs, _ = m.UpSinceSync(since)
+		}(i, m)
+	}
+	wg.Wait()
+
+	failed := 0
+	fmt.Println()
+	for _, result := range results {
+		if len(result.errs) == 0 {
+			color.New(color.FgGreen).Print("ok  ")
+			fmt.Printf("  %s\n", result.url)
+			continue
+		}
+		failed += 1
+		color.New(color.FgRed).Print("fail")
+		fmt.Printf("  %s\n", result.url)
+		for _, err := range result.errs {
+			fmt.Printf("      %v\n", err)
+		}
+	}
+	fmt.Printf("%v of %v database(s) failed\n", failed, len(results))
+	return failed == 0
+}
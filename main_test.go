@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+)
+
+func TestJSONError(t *testing.T) {
+	f := file.File{FileName: "0002_users.up.sql", Version: 2, Direction: direction.Up}
+	var tests = []struct {
+		err    error
+		expect string
+	}{
+		{
+			&migrate.MigrationError{File: &f, Code: "42P07", Category: migrate.SQLError, Err: errors.New(`relation "users" already exists`)},
+			`{"version":2,"file":"0002_users.up.sql","direction":"up","code":"42P07","category":"sql","message":"relation \"users\" already exists"}`,
+		},
+		{
+			&migrate.MigrationError{Category: migrate.InterruptError, Err: errors.New("interrupted")},
+			`{"category":"interrupt","message":"interrupted"}`,
+		},
+		{
+			errors.New("no such file"),
+			`{"message":"no such file"}`,
+		},
+	}
+
+	for _, test := range tests {
+		encoded, err := json.Marshal(newJSONError(test.err))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != test.expect {
+			t.Errorf("Expected %s, got %s", test.expect, encoded)
+		}
+	}
+}
+
+func TestErrorEvent(t *testing.T) {
+	f := file.File{FileName: "0002_users.down.sql", Version: 2, Direction: direction.Down}
+	err := &migrate.MigrationError{File: &f, Code: "42P01", Category: migrate.SQLError, Err: errors.New(`table "users" does not exist`)}
+	encoded, _ := json.Marshal(newErrorEvent(err))
+	expect := `{"type":"error","direction":"down","name":"0002_users.down.sql","code":"42P01","category":"sql","message":"table \"users\" does not exist"}`
+	if string(encoded) != expect {
+		t.Errorf("Expected %s, got %s", expect, encoded)
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	stderr := os.Stderr
+	os.Stderr = tmp
+	writeJSONError(errors.New("no such file"))
+	os.Stderr = stderr
+	tmp.Close()
+
+	// one line per error, so that a caller can read them as they come
+	if out, _ := ioutil.ReadFile(tmp.Name()); string(out) != "{\"message\":\"no such file\"}\n" {
+		t.Errorf("Expected a single line of JSON, got %q", out)
+	}
+}
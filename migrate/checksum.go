@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PlanitarInc/migrate/driver"
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+)
+
+// ChecksumMismatch is a version that was applied to two databases
+// with different file contents.
+type ChecksumMismatch struct {
+	Version       uint64
+	Checksum      string
+	OtherChecksum string
+}
+
+func (c ChecksumMismatch) String() string {
+	return fmt.Sprintf("version %v: checksum %s differs from %s", c.Version, c.Checksum, c.OtherChecksum)
+}
+
+// CompareChecksums compares the checksums recorded for the versions
+// applied to both the migrator's database and other's database.
+// Versions without a checksum on either side are skipped.
+func (m Migrator) CompareChecksums(other Migrator) ([]ChecksumMismatch, error) {
+	checksums, err := m.checksums()
+	if err != nil {
+		return nil, err
+	}
+	otherChecksums, err := other.checksums()
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]ChecksumMismatch, 0)
+	for version, checksum := range checksums {
+		otherChecksum, ok := otherChecksums[version]
+		if !ok || checksum == "" || otherChecksum == "" {
+			continue
+		}
+		if checksum != otherChecksum {
+			mismatches = append(mismatches, ChecksumMismatch{version, checksum, otherChecksum})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Version < mismatches[j].Version
+	})
+	return mismatches, nil
+}
+
+// checksums reads the recorded checksums from the migrator's database
+func (m Migrator) checksums() (map[uint64]string, error) {
+	d, err := m.newDriver()
+	if err != nil {
+		return nil, err
+	}
+	defer m.closeDriver(d)
+
+	c, ok := d.(driver.Checksummer)
+	if !ok {
+		return nil, fmt.Errorf("Driver does not record checksums.")
+	}
+	return c.Checksums(m.Id)
+}
+
+// BackfillChecksums records the checksums of the up files of applied
+// versions that were applied before checksums were recorded. Recorded
+// checksums are never overwritten; those not matching the up file are
+// returned as mismatches instead, with the file's checksum as
+// OtherChecksum. Applied versions without an up file are skipped.
+func (m Migrator) BackfillChecksums() (filled []uint64, mismatches []ChecksumMismatch, err error) {
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := m.newDriver()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer m.closeDriver(d)
+
+	c, ok := d.(driver.Checksummer)
+	if !ok {
+		return nil, nil, fmt.Errorf("Driver does not record checksums.")
+	}
+	filler, ok := d.(driver.ChecksumFiller)
+	if !ok {
+		return nil, nil, fmt.Errorf("Driver does not support filling in checksums.")
+	}
+	if locker, ok := d.(driver.Locker); ok {
+		if err := locker.Lock(m.Id); err != nil {
+			return nil, nil, migrationerror.Wrap(migrationerror.Lock, err)
+		}
+		defer locker.Unlock(m.Id)
+	}
+	checksums, err := c.Checksums(m.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filled = make([]uint64, 0)
+	mismatches = make([]ChecksumMismatch, 0)
+	for _, mf := range files {
+		recorded, applied := checksums[mf.Version]
+		if !applied || mf.UpFile == nil {
+			continue
+		}
+		if err := mf.UpFile.ReadContent(); err != nil {
+			return filled, mismatches, err
+		}
+		checksum := file.Checksum(mf.UpFile.Content)
+
+		if recorded != "" {
+			if recorded != checksum {
+				mismatches = append(mismatches, ChecksumMismatch{mf.Version, recorded, checksum})
+			}
+			continue
+		}
+		ok, err := filler.FillChecksum(m.Id, mf.Version, checksum)
+		if err != nil {
+			return filled, mismatches, err
+		}
+		if ok {
+			filled = append(filled, mf.Version)
+		}
+	}
+	return filled, mismatches, nil
+}
+
+// Verify compares the up files of applied versions with the checksums
+// recorded when they were applied and returns an error for every file
+// that was edited since. Versions without a recorded checksum (see
+// BackfillChecksums) or without an up file are skipped.
+func (m Migrator) Verify() (errs []error, ok bool) {
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return []error{err}, false
+	}
+	checksums, err := m.checksums()
+	if err != nil {
+		return []error{err}, false
+	}
+
+	errs = make([]error, 0)
+	for _, mf := range files {
+		recorded := checksums[mf.Version]
+		if recorded == "" || mf.UpFile == nil {
+			continue
+		}
+		if err := mf.UpFile.ReadContent(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if checksum := file.Checksum(mf.UpFile.Content); checksum != recorded {
+			errs = append(errs, fmt.Errorf("%s was edited after it was applied: checksum %s was recorded, the file's is %s.",
+				mf.UpFile.FileName, recorded, checksum))
+		}
+	}
+	return errs, len(errs) == 0
+}
+
+var errLockFileCombined = errors.New("Lock files are not supported for combined migration files.")
+
+// LockFile returns the lock file of the migration files.
+// It does not connect to the database.
+func (m Migrator) LockFile() (file.LockFile, error) {
+	if m.Layout == file.CombinedLayout {
+		return nil, errLockFileCombined
+	}
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	return file.NewLockFile(files)
+}
+
+// VerifyLockFile compares a lock file with the migration files and, if
+// checkDatabase is set, with the checksums recorded for applied versions.
+func (m Migrator) VerifyLockFile(content []byte, checkDatabase bool) ([]file.LockMismatch, error) {
+	if m.Layout == file.CombinedLayout {
+		return nil, errLockFileCombined
+	}
+	url, err := m.driverUrl()
+	if err != nil {
+		return nil, err
+	}
+	d, err := driver.Lookup(url)
+	if err != nil {
+		return nil, err
+	}
+	filenameRegex, err := m.filenameRegex(d.FilenameExtension())
+	if err != nil {
+		return nil, err
+	}
+	lock, err := file.ParseLockFile(content, filenameRegex)
+	if err != nil {
+		return nil, err
+	}
+	current, err := m.LockFile()
+	if err != nil {
+		return nil, err
+	}
+	mismatches := lock.Compare(current)
+	if !checkDatabase {
+		return mismatches, nil
+	}
+
+	checksums, err := m.checksums()
+	if err != nil {
+		return nil, err
+	}
+	locked := make(map[uint64]file.LockEntry)
+	for _, e := range lock {
+		if strings.HasSuffix(strings.TrimSuffix(e.FileName, file.GzipExtension), ".up."+d.FilenameExtension()) {
+			locked[e.Version] = e
+		}
+	}
+	versions := make([]uint64, 0, len(checksums))
+	for version := range checksums {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	for _, version := range versions {
+		checksum := checksums[version]
+		e, ok := locked[version]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, file.LockMismatch{
+				FileName: fmt.Sprintf("version %v", version),
+				Message:  "applied, but not in lock file",
+			})
+		case checksum != "" && checksum != e.Checksum:
+			mismatches = append(mismatches, file.LockMismatch{
+				FileName: e.FileName,
+				Message:  fmt.Sprintf("applied with checksum %s, which differs from %s in lock file", checksum, e.Checksum),
+			})
+		}
+	}
+	return mismatches, nil
+}
@@ -7,3 +7,14 @@ const (
 	Up   Direction = +1
 	Down           = -1
 )
+
+// String returns "up" or "down".
+func (d Direction) String() string {
+	switch d {
+	case Up:
+		return "up"
+	case Down:
+		return "down"
+	}
+	return "unknown"
+}
@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/driver/mock"
+)
+
+func TestDoctor(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0003_c.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	failed := func(diagnoses []Diagnosis) []string {
+		checks := make([]string, 0)
+		for _, d := range diagnoses {
+			if !d.OK() {
+				if d.Hint == "" {
+					t.Errorf("Expected a hint for %s", d.Check)
+				}
+				checks = append(checks, d.Check)
+			}
+		}
+		return checks
+	}
+
+	var tests = []struct {
+		m            Migrator
+		expectChecks int
+		expectFailed []string
+	}{
+		{Migrator{Url: "mock://", Path: tmpdir, Instance: mock.NewDatabase()}, 5, []string{"Migration file versions"}},
+		{Migrator{Url: "mock://", Path: tmpdir, Instance: mock.NewDatabase(), VersionFormat: VersionTimestamp}, 5, []string{}},
+		{Migrator{Url: "mock://", Path: path.Join(tmpdir, "missing"), Instance: mock.NewDatabase()}, 4, []string{"Migrations path " + path.Join(tmpdir, "missing")}},
+		{Migrator{Url: "nosuchdriver://", Path: tmpdir}, 2, []string{"Url and driver"}},
+		{Migrator{Url: "localhost:5432", Path: tmpdir}, 2, []string{"Url and driver"}},
+		{Migrator{Url: "mock://?fail_on=never", Path: tmpdir}, 4, []string{"Migration file versions", "Database connection"}},
+	}
+	for _, test := range tests {
+		diagnoses := test.m.Doctor()
+		if len(diagnoses) != test.expectChecks {
+			t.Errorf("Expected %v checks for %v, got %v", test.expectChecks, test.m.Url, diagnoses)
+		}
+		if checks := failed(diagnoses); strings.Join(checks, ", ") != strings.Join(test.expectFailed, ", ") {
+			t.Errorf("Expected %v to fail for %v, got %v", test.expectFailed, test.m.Url, checks)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package migrate
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// ProductionEnvironment is the environment name that makes
+// destructive operations require an explicit confirmation.
+const ProductionEnvironment = "production"
+
+// environmentParam is the url query parameter the environment
+// can be inferred from, i.e. postgres://host/db?environment=production
+const environmentParam = "environment"
+
+// CurrentEnvironment returns the environment the migrator runs against.
+// Migrator.Environment takes precedence over the url query parameter.
+func (m Migrator) CurrentEnvironment() string {
+	if m.Environment != "" {
+		return m.Environment
+	}
+	url, err := m.expandedUrl()
+	if err != nil {
+		return ""
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get(environmentParam)
+}
+
+// IsProduction reports whether the migrator runs against production.
+func (m Migrator) IsProduction() bool {
+	return m.CurrentEnvironment() == ProductionEnvironment
+}
+
+// expandedUrl returns the url with $VAR and ${VAR} replaced by the
+// environment variables of these names, e.g. to keep the password out
+// of scripts. $$ stands for a literal $. Values are inserted as is, so
+// they have to be url-encoded where the url requires it.
+func (m Migrator) expandedUrl() (string, error) {
+	if !strings.Contains(m.Url, "$") {
+		return m.Url, nil
+	}
+	missing := make([]string, 0)
+	url := os.Expand(m.Url, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Environment variables referenced by the url are not set: %s.", strings.Join(missing, ", "))
+	}
+	return url, nil
+}
+
+// driverUrl returns the expanded url without the parameters that are
+// consumed by the migrator itself and must not reach a driver.
+func (m Migrator) driverUrl() (string, error) {
+	url, err := m.expandedUrl()
+	if err != nil {
+		return "", err
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return url, nil
+	}
+	q := u.Query()
+	if _, ok := q[environmentParam]; !ok {
+		return url, nil
+	}
+	q.Del(environmentParam)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// redactedPassword replaces passwords in RedactedUrl
+const redactedPassword = "xxxxx"
+
+// RedactedUrl returns the url with any password replaced by xxxxx,
+// both in the user info and in a password query parameter.
+func (m Migrator) RedactedUrl() string {
+	u, err := neturl.Parse(m.Url)
+	if err != nil {
+		return ""
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = neturl.UserPassword(u.User.Username(), redactedPassword)
+		}
+	}
+	q := u.Query()
+	if _, ok := q["password"]; ok {
+		q.Set("password", redactedPassword)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
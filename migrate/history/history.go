@@ -0,0 +1,13 @@
+// Package history holds the applied versions reported by drivers
+// that record when a version was applied.
+package history
+
+import "time"
+
+// AppliedMigration is an applied version and the time it was applied at.
+// AppliedAt is zero if the driver didn't record it, e.g. for versions
+// applied before it did.
+type AppliedMigration struct {
+	Version   uint64
+	AppliedAt time.Time
+}
@@ -0,0 +1,36 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+)
+
+// Hook is called with a migration file around its migration, see
+// Migrator.BeforeEach and Migrator.AfterEach.
+type Hook func(f file.File) error
+
+// beforeEach calls the BeforeEach hook, if any, for f. A failure is
+// returned as a MigrationError of category Hook.
+func (m Migrator) beforeEach(f file.File) error {
+	return m.callHook(m.BeforeEach, "BeforeEach", f)
+}
+
+// afterEach calls the AfterEach hook, if any, for f.
+func (m Migrator) afterEach(f file.File) error {
+	return m.callHook(m.AfterEach, "AfterEach", f)
+}
+
+func (m Migrator) callHook(hook Hook, name string, f file.File) error {
+	if hook == nil || m.DryRun {
+		return nil
+	}
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	if err := hook(f); err != nil {
+		return &MigrationError{File: &f, Category: migrationerror.Hook, Err: fmt.Errorf("%s hook of %s failed: %v", name, f.FileName, err)}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package journal records the statements drivers execute.
+package journal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+)
+
+// Journal writes every statement a driver executes, in the order they
+// ran, each preceded by a comment with the time and the migration file:
+//
+//	-- 2020-02-03T08:37:58.123Z 0001_users.up.sql
+//	CREATE TABLE users (...);
+//
+// Unlike the migration files themselves this is the exact SQL sent to
+// the database, including any statements a driver adds around them.
+type Journal struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// New returns a Journal writing to w.
+func New(w io.Writer) *Journal {
+	return &Journal{w: w, now: time.Now}
+}
+
+// Record writes a statement executed for a migration file.
+// Recording to a nil Journal does nothing, so drivers may call it
+// whether journaling is enabled or not.
+func (j *Journal) Record(f file.File, statement string) error {
+	if j == nil {
+		return nil
+	}
+	statement = strings.TrimRight(strings.TrimSpace(statement), ";")
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := fmt.Fprintf(j.w, "-- %s %s\n%s;\n\n",
+		j.now().UTC().Format(time.RFC3339Nano), f.FileName, statement)
+	return err
+}
@@ -0,0 +1,34 @@
+package journal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	j := New(&buf)
+	j.now = func() time.Time { return time.Date(2020, 2, 3, 8, 37, 58, 0, time.UTC) }
+
+	f := file.File{FileName: "0001_users.up.sql"}
+	if err := j.Record(f, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Record(f, "  CREATE TABLE users (id int);\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "-- 2020-02-03T08:37:58Z 0001_users.up.sql\nBEGIN;\n\n" +
+		"-- 2020-02-03T08:37:58Z 0001_users.up.sql\nCREATE TABLE users (id int);\n\n"
+	if buf.String() != expect {
+		t.Errorf("Expected journal\n%s\ngot\n%s", expect, buf.String())
+	}
+
+	var nilJournal *Journal
+	if err := nilJournal.Record(f, "BEGIN"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"github.com/PlanitarInc/migrate/file"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+// Logger receives the events of a migrator in addition to the pipe,
+// e.g. to route them to a structured logger; zap's SugaredLogger
+// implements it as is.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// log passes an item sent down the pipe to the migrator's logger,
+// if there is one. Errors are logged with Errorf, everything else
+// with Infof.
+func (m Migrator) log(item interface{}) {
+	if m.Logger == nil {
+		return
+	}
+	switch item := item.(type) {
+	case error:
+		m.Logger.Errorf("%v", item)
+	case file.File:
+		m.Logger.Infof("Migrating %s (version %v, %s)", item.FileName, item.Version, item.Direction)
+	case file.MigrationResult:
+		m.Logger.Infof("Migrated %s in %v", item.File.FileName, item.Duration)
+	case Progress:
+		m.Logger.Infof("Migration %v of %v", item.Current, item.Total)
+	case file.StatementProgress:
+		m.Logger.Infof("Statement %v of %v of %s", item.Current, item.Total, item.File.FileName)
+	default:
+		m.Logger.Infof("%v", item)
+	}
+}
+
+// logged returns a pipe forwarding everything sent down pipe, passing it
+// to the migrator's logger on the way, or pipe if there is no logger.
+func (m Migrator) logged(pipe chan interface{}) chan interface{} {
+	if m.Logger == nil {
+		return pipe
+	}
+
+	logged := pipep.New()
+	go func() {
+		defer close(logged)
+		for item := range pipe {
+			m.log(item)
+			logged <- item
+		}
+	}()
+	return logged
+}
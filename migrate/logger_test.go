@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger records all logged lines
+type recordingLogger struct {
+	mu    sync.Mutex
+	infos []string
+	errs  []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_b.up.sh"), nil, 0644)
+
+	logger := &recordingLogger{}
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store, Logger: logger}
+
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	expected := []string{
+		"Migration 1 of 2",
+		"Migrating 0001_a.up.sh (version 1, up)",
+		"Migration 2 of 2",
+		"Migrating 0002_b.up.sh (version 2, up)",
+	}
+	if strings.Join(logger.infos, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected infos %q, got %q", expected, logger.infos)
+	}
+
+	store.lockErr = errors.New("locked")
+	ioutil.WriteFile(path.Join(tmpdir, "0003_c.up.sh"), nil, 0644)
+	if _, ok := m.UpSync(); ok {
+		t.Fatal("Expected the lock error")
+	}
+	if len(logger.errs) != 1 || logger.errs[0] != "locked" {
+		t.Errorf("Expected the lock error to be logged, got %q", logger.errs)
+	}
+
+	// without a logger nothing breaks
+	m.Logger = nil
+	store.lockErr = nil
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+}
@@ -3,17 +3,27 @@
 package migrate
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/signal"
-	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/PlanitarInc/migrate/driver"
 	"github.com/PlanitarInc/migrate/file"
 	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/history"
+	"github.com/PlanitarInc/migrate/migrate/journal"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
 	pipep "github.com/PlanitarInc/migrate/pipe"
 )
 
@@ -33,47 +43,218 @@ type Migrator struct {
 	Instance interface{}
 	Path     string
 	Store    file.FileStore
+
+	// Paths are several directories whose migrations are merged by
+	// version, e.g. of the services in a monorepo. They replace Path
+	// if set; Create writes into the first of them.
+	Paths []string
+
+	// CreateTemplate is the text/template used for the content of
+	// files generated by Create. See TemplateData for the available fields.
+	// Generated files are empty if unset.
+	CreateTemplate string
+
+	// TemplateFuncs extends DefaultTemplateFuncs for CreateTemplate.
+	TemplateFuncs template.FuncMap
+
+	// CreateUpOnly makes Create generate only the up file, marked with
+	// file.IrreversibleDirective, for migrations that can't be rolled back.
+	CreateUpOnly bool
+
+	// VersionFormat is how Create numbers new migrations,
+	// VersionSequential (default) or VersionTimestamp.
+	VersionFormat string
+
+	// VersionDigits is the width sequential versions of Create are
+	// zero-padded to. If unset it is the width of the widest existing
+	// version, DefaultVersionDigits if there are none.
+	VersionDigits int
+
+	// NameSeparator separates the version and the name in the filenames
+	// written by Create and read back, file.DefaultNameSeparator if empty.
+	NameSeparator string
+
+	// Layout is how the up and down migrations of a version are kept,
+	// file.SplitLayout (default) or file.CombinedLayout.
+	Layout file.Layout
+
+	// TimestampFormat is the time layout of timestamp versions,
+	// DefaultTimestampFormat if empty. Filenames with versions in this
+	// format are recognized when reading migration files.
+	TimestampFormat string
+
+	// StrictSequence makes reading migration files fail if there is a
+	// gap between their versions. Ignored for VersionTimestamp.
+	StrictSequence bool
+
+	// NameFilter, if set, limits the migration files that are applied,
+	// reverted or reported as applied or pending to those whose name
+	// matches it, with
+	// filepath.Match semantics, e.g. "*_tenant_*". Applying a subset
+	// leaves gaps in the applied versions, it is meant for development.
+	NameFilter string
+
+	// VersionStore keeps track of applied migrations instead of
+	// the driver if set.
+	VersionStore VersionStore
+
+	// ExpectVersion, if set, makes migrations fail unless the
+	// current version is exactly this one before anything is applied.
+	ExpectVersion *uint64
+
+	// AllowAhead allows migrating a database whose current version is
+	// higher than the highest version of the migration files, e.g.
+	// when running the migrations of an older revision on purpose.
+	AllowAhead bool
+
+	// Journal, if set, receives every statement the driver executes,
+	// see journal.Journal. The driver has to be a driver.Journaler.
+	Journal io.Writer
+
+	// DryRun sends the statements of migrations down the pipe instead
+	// of executing them; no versions are recorded. The driver has to
+	// be a driver.DryRunner.
+	DryRun bool
+
+	// AllInOneTx applies all migrations of a run in a single transaction,
+	// so that none of them is applied if one fails. It requires a
+	// driver.BatchMigrator, other drivers apply one file at a time.
+	AllInOneTx bool
+
+	// Tracer, if set, starts a span for every applied migration file.
+	Tracer Tracer
+
+	// Logger, if set, receives everything sent down the pipe as well.
+	Logger Logger
+
+	// BeforeEach, if set, is called before every migration file is
+	// applied, e.g. to disable a feature flag. If it fails the file is
+	// not applied and the run stops with the error.
+	BeforeEach Hook
+
+	// AfterEach, if set, is called after every migration file was
+	// applied and recorded. If it fails the run stops with the error,
+	// the migration is not rolled back. With AllInOneTx the hooks of all
+	// files are called before and after the batch. Hooks are not called
+	// on DryRun.
+	AfterEach Hook
+
+	// Context, if set, lets the consumer of the pipe abandon it: once
+	// the context is done nothing is sent down the pipe anymore and
+	// migrations stop after the one currently running.
+	Context context.Context
+
+	// RunContext, if set, is passed to drivers and version stores that
+	// implement driver.ContextSetter. Once it is done the running migration is
+	// aborted, rolled back where the driver can, and reported as a
+	// timeout (or interrupt) MigrationError, e.g. with context.WithTimeout.
+	RunContext context.Context
+
+	// driver is kept open between calls after Open
+	driver driver.Driver
+
+	// Environment names the environment migrations run against,
+	// e.g. "production". Inferred from the url if empty.
+	Environment string
+
+	// ConnectRetry, if set, is how long connecting to the database is
+	// retried with exponential backoff, e.g. while it is starting up.
+	// Retries are reported down the pipe.
+	ConnectRetry time.Duration
 }
 
-// Up applies all available migrations
+// MigrationError is the error drivers send down the pipe
+// when a migration file fails.
+type MigrationError = migrationerror.Error
+
+// ErrorCategory tells what kind of failure a MigrationError is.
+type ErrorCategory = migrationerror.Category
+
+// AppliedMigration is an applied version in the History.
+type AppliedMigration = history.AppliedMigration
+
+const (
+	ConnectionError = migrationerror.Connection
+	SQLError        = migrationerror.SQL
+	LockError       = migrationerror.Lock
+	InterruptError  = migrationerror.Interrupt
+	TimeoutError    = migrationerror.Timeout
+	HookError       = migrationerror.Hook
+)
+
+// Up applies all available migrations.
+// If a previous run stopped halfway through a migration and left a
+// dirty version behind, Up refuses to continue until the dirty marker
+// is resolved, and resumes after the last completed version afterwards.
 func (m Migrator) Up(pipe chan interface{}) {
-	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion()
+	m.upSince(pipe, 0)
+}
+
+// UpSince applies the available migrations with a version greater than
+// since, e.g. the highest version of a previous deploy. Pending
+// migrations up to since are skipped.
+func (m Migrator) UpSince(pipe chan interface{}, since uint64) {
+	m.upSince(pipe, since)
+}
+
+// UpSinceSync is synchronous version of UpSince
+func (m Migrator) UpSinceSync(since uint64) (err []error, ok bool) {
+	pipe := pipep.New()
+	go m.UpSince(pipe, since)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
+func (m Migrator) upSince(pipe chan interface{}, since uint64) {
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(pipe)
 	if err != nil {
-		go pipep.Close(pipe, err)
+		go m.closePipe(pipe, err)
 		return
 	}
 
-	applyMigrationFiles, err := files.ToLastFrom(version)
+	if err := checkDirty(d, m.Id, files, version); err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
+		}
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	from := version
+	if since > from {
+		from = since
+	}
+	applyMigrationFiles, err := files.ToLastFrom(from)
 	if err != nil {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		go pipep.Close(pipe, err)
+		go m.closePipe(pipe, err)
 		return
 	}
 
 	if len(applyMigrationFiles) > 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(m.Id, f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err := d.Close(); err != nil {
-			pipe <- err
+		m.migrateFiles(d, applyMigrationFiles, pipe)
+		if err := m.closeDriver(d); err != nil {
+			m.send(pipe, err)
 		}
-		go pipep.Close(pipe, nil)
+		go m.closePipe(pipe, nil)
 		return
 	} else {
-		if err := d.Close(); err != nil {
-			pipe <- err
+		if err := m.closeDriver(d); err != nil {
+			m.send(pipe, err)
 		}
-		go pipep.Close(pipe, nil)
+		go m.closePipe(pipe, nil)
 		return
 	}
 }
 
+// UpContext is Up with m.RunContext set to ctx.
+func (m Migrator) UpContext(ctx context.Context, pipe chan interface{}) {
+	m.RunContext = ctx
+	m.Up(pipe)
+}
+
 // UpSync is synchronous version of Up
 func (m Migrator) UpSync() (err []error, ok bool) {
 	pipe := pipep.New()
@@ -82,45 +263,104 @@ func (m Migrator) UpSync() (err []error, ok bool) {
 	return err, len(err) == 0
 }
 
+// UpN applies only the next n pending migrations, e.g. for a staged
+// rollout. If fewer are pending, all of them are applied.
+func (m Migrator) UpN(pipe chan interface{}, n int) {
+	if n <= 0 {
+		go m.closePipe(pipe, fmt.Errorf("Expected a positive number of migrations to apply, got %v.", n))
+		return
+	}
+
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(pipe)
+	if err != nil {
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	if err := checkDirty(d, m.Id, files, version); err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
+		}
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	applyMigrationFiles, err := files.From(version, +n)
+	if err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
+		}
+		go m.closePipe(pipe, err)
+		return
+	}
+	if len(applyMigrationFiles) < n {
+		m.send(pipe, fmt.Sprintf("Only %v migrations are pending, applying all of them.", len(applyMigrationFiles)))
+	}
+
+	if len(applyMigrationFiles) > 0 {
+		m.migrateFiles(d, applyMigrationFiles, pipe)
+	}
+	if err2 := m.closeDriver(d); err2 != nil {
+		m.send(pipe, err2)
+	}
+	go m.closePipe(pipe, nil)
+}
+
+// UpNSync is synchronous version of UpN
+func (m Migrator) UpNSync(n int) (err []error, ok bool) {
+	pipe := pipep.New()
+	go m.UpN(pipe, n)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
 // Down rolls back all migrations
 func (m Migrator) Down(pipe chan interface{}) {
-	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion()
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(pipe)
 	if err != nil {
-		go pipep.Close(pipe, err)
+		go m.closePipe(pipe, err)
 		return
 	}
 
 	applyMigrationFiles, err := files.ToFirstFrom(version)
 	if err != nil {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		go pipep.Close(pipe, err)
+		go m.closePipe(pipe, err)
 		return
 	}
 
-	if len(applyMigrationFiles) > 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(m.Id, f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
+	if err := m.checkDownFiles(d, files, version, 0); err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	if len(applyMigrationFiles) > 0 {
+		m.migrateFiles(d, applyMigrationFiles, pipe)
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		go pipep.Close(pipe, nil)
+		go m.closePipe(pipe, nil)
 		return
 	} else {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		go pipep.Close(pipe, nil)
+		go m.closePipe(pipe, nil)
 		return
 	}
 }
 
+// DownContext is Down with m.RunContext set to ctx.
+func (m Migrator) DownContext(ctx context.Context, pipe chan interface{}) {
+	m.RunContext = ctx
+	m.Down(pipe)
+}
+
 // DownSync is synchronous version of Down
 func (m Migrator) DownSync() (err []error, ok bool) {
 	pipe := pipep.New()
@@ -129,15 +369,79 @@ func (m Migrator) DownSync() (err []error, ok bool) {
 	return err, len(err) == 0
 }
 
+// DownN rolls back the n most recently applied migrations. If fewer
+// than n are applied, all of them are rolled back with a warning.
+func (m Migrator) DownN(pipe chan interface{}, n int) {
+	if n <= 0 {
+		go m.closePipe(pipe, fmt.Errorf("Expected a positive number of migrations to roll back, got %v.", n))
+		return
+	}
+
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(pipe)
+	if err != nil {
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	applyMigrationFiles, err := files.ToFirstFrom(version)
+	if err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
+		}
+		go m.closePipe(pipe, err)
+		return
+	}
+	to := uint64(0)
+	if n > len(applyMigrationFiles) {
+		m.send(pipe, fmt.Sprintf("Only %v migrations are applied, rolling back all of them.", len(applyMigrationFiles)))
+	} else {
+		applyMigrationFiles = applyMigrationFiles[:n]
+		to = applyMigrationFiles[n-1].Version - 1
+	}
+	if err := m.checkDownFiles(d, files, version, to); err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
+		}
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	if len(applyMigrationFiles) > 0 {
+		m.migrateFiles(d, applyMigrationFiles, pipe)
+	}
+	if err2 := m.closeDriver(d); err2 != nil {
+		m.send(pipe, err2)
+	}
+	go m.closePipe(pipe, nil)
+}
+
+// DownNSync is synchronous version of DownN
+func (m Migrator) DownNSync(n int) (err []error, ok bool) {
+	pipe := pipep.New()
+	go m.DownN(pipe, n)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
 // Redo rolls back the most recently applied migration, then runs it again.
 func (m Migrator) Redo(pipe chan interface{}) {
+	m.RedoN(pipe, 1)
+}
+
+// RedoN rolls back the n most recently applied migrations, then runs
+// them again.
+func (m Migrator) RedoN(pipe chan interface{}, n int) {
+	if n <= 0 {
+		go m.closePipe(pipe, fmt.Errorf("Expected a positive number of migrations to redo, got %v.", n))
+		return
+	}
 	pipe1 := pipep.New()
-	go m.Migrate(pipe1, -1)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-		go pipep.Close(pipe, nil)
+	go m.Migrate(pipe1, -n)
+	if ok := m.waitAndRedirect(pipe1, pipe); !ok {
+		go m.closePipe(pipe, nil)
 		return
 	} else {
-		go m.Migrate(pipe, +1)
+		go m.Migrate(pipe, +n)
 	}
 }
 
@@ -149,12 +453,20 @@ func (m Migrator) RedoSync() (err []error, ok bool) {
 	return err, len(err) == 0
 }
 
+// RedoNSync is synchronous version of RedoN
+func (m Migrator) RedoNSync(n int) (err []error, ok bool) {
+	pipe := pipep.New()
+	go m.RedoN(pipe, n)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
 // Reset runs the down and up migration function
 func (m Migrator) Reset(pipe chan interface{}) {
 	pipe1 := pipep.New()
 	go m.Down(pipe1)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-		go pipep.Close(pipe, nil)
+	if ok := m.waitAndRedirect(pipe1, pipe); !ok {
+		go m.closePipe(pipe, nil)
 		return
 	} else {
 		go m.Up(pipe)
@@ -169,44 +481,68 @@ func (m Migrator) ResetSync() (err []error, ok bool) {
 	return err, len(err) == 0
 }
 
-// Migrate applies relative +n/-n migrations
+// Migrate applies relative +n/-n migrations. Like Up, migrating up
+// refuses to continue from a dirty version.
 func (m Migrator) Migrate(pipe chan interface{}, relativeN int) {
-	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion()
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(pipe)
 	if err != nil {
-		go pipep.Close(pipe, err)
+		go m.closePipe(pipe, err)
 		return
 	}
 
+	if relativeN > 0 {
+		if err := checkDirty(d, m.Id, files, version); err != nil {
+			if err2 := m.closeDriver(d); err2 != nil {
+				m.send(pipe, err2)
+			}
+			go m.closePipe(pipe, err)
+			return
+		}
+	}
+
 	applyMigrationFiles, err := files.From(version, relativeN)
 	if err != nil {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		go pipep.Close(pipe, err)
+		go m.closePipe(pipe, err)
 		return
 	}
-
-	if len(applyMigrationFiles) > 0 && relativeN != 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(m.Id, f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
+	if relativeN < 0 {
+		to := uint64(0)
+		if len(applyMigrationFiles) == -relativeN {
+			to = applyMigrationFiles[len(applyMigrationFiles)-1].Version - 1
+		}
+		if err := m.checkDownFiles(d, files, version, to); err != nil {
+			if err2 := m.closeDriver(d); err2 != nil {
+				m.send(pipe, err2)
 			}
+			go m.closePipe(pipe, err)
+			return
 		}
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
+	}
+
+	if len(applyMigrationFiles) > 0 && relativeN != 0 {
+		m.migrateFiles(d, applyMigrationFiles, pipe)
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
 		}
-		go pipep.Close(pipe, nil)
+		go m.closePipe(pipe, nil)
 		return
 	}
-	if err2 := d.Close(); err2 != nil {
-		pipe <- err2
+	if err2 := m.closeDriver(d); err2 != nil {
+		m.send(pipe, err2)
 	}
-	go pipep.Close(pipe, nil)
+	go m.closePipe(pipe, nil)
 	return
 }
 
+// MigrateContext is Migrate with m.RunContext set to ctx.
+func (m Migrator) MigrateContext(ctx context.Context, pipe chan interface{}, relativeN int) {
+	m.RunContext = ctx
+	m.Migrate(pipe, relativeN)
+}
+
 // MigrateSync is synchronous version of Migrate
 func (m Migrator) MigrateSync(relativeN int) (err []error, ok bool) {
 	pipe := pipep.New()
@@ -215,92 +551,836 @@ func (m Migrator) MigrateSync(relativeN int) (err []error, ok bool) {
 	return err, len(err) == 0
 }
 
-// Version returns the current migration version
+// Goto applies the up or down migrations between the current version
+// and version, whatever the gaps between versions. Like Up, migrating
+// up refuses to continue from a dirty version.
+func (m Migrator) Goto(pipe chan interface{}, version uint64) {
+	d, files, currentVersion, err := m.initDriverAndReadMigrationFilesAndGetVersion(pipe)
+	if err != nil {
+		go m.closePipe(pipe, err)
+		return
+	}
+
+	if version > currentVersion {
+		if err := checkDirty(d, m.Id, files, currentVersion); err != nil {
+			if err2 := m.closeDriver(d); err2 != nil {
+				m.send(pipe, err2)
+			}
+			go m.closePipe(pipe, err)
+			return
+		}
+	}
+
+	applyMigrationFiles, err := files.ToVersion(currentVersion, version)
+	if err != nil {
+		if err2 := m.closeDriver(d); err2 != nil {
+			m.send(pipe, err2)
+		}
+		go m.closePipe(pipe, err)
+		return
+	}
+	if version < currentVersion {
+		if err := m.checkDownFiles(d, files, currentVersion, version); err != nil {
+			if err2 := m.closeDriver(d); err2 != nil {
+				m.send(pipe, err2)
+			}
+			go m.closePipe(pipe, err)
+			return
+		}
+	}
+
+	if len(applyMigrationFiles) > 0 {
+		m.migrateFiles(d, applyMigrationFiles, pipe)
+	}
+	if err2 := m.closeDriver(d); err2 != nil {
+		m.send(pipe, err2)
+	}
+	go m.closePipe(pipe, nil)
+}
+
+// GotoSync is synchronous version of Goto
+func (m Migrator) GotoSync(version uint64) (err []error, ok bool) {
+	pipe := pipep.New()
+	go m.Goto(pipe, version)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
+// MigrateInTx is like MigrateSync, but runs the migrations and their
+// bookkeeping in the caller's transaction, leaving commit and rollback
+// to the caller. The driver has to accept a *sql.Tx as instance, as the
+// postgres driver does.
+func (m Migrator) MigrateInTx(tx *sql.Tx, relativeN int) (err []error, ok bool) {
+	if m.VersionStore != nil {
+		err := errors.New("Migrations can't run in a transaction when versions are kept in a version store.")
+		return []error{err}, false
+	}
+	m.Instance = tx
+	m.driver = nil
+	return m.MigrateSync(relativeN)
+}
+
+// Version returns the current migration version. Unless the migrator
+// was opened, see Open, every call connects on its own.
 func (m Migrator) Version() (version uint64, err error) {
-	d, err := driver.New(m.Instance, m.Url)
+	if m.VersionStore != nil {
+		return m.VersionStore.GetVersion(m.Id)
+	}
+	d, err := m.newDriver()
 	if err != nil {
 		return 0, err
 	}
+	defer m.closeDriver(d)
+	return m.VersionWith(d)
+}
+
+// VersionWith is Version, asking the initialized driver d instead of
+// connecting, e.g. to share one driver between the migrators of several
+// ids. d is not closed.
+func (m Migrator) VersionWith(d driver.Driver) (version uint64, err error) {
+	if m.VersionStore != nil {
+		return m.VersionStore.GetVersion(m.Id)
+	}
 	return d.Version(m.Id)
 }
 
+// IdVersions returns the current version of every migration id,
+// ignoring Migrator.Id. The version store, or else the driver,
+// has to implement driver.IdLister.
+func (m Migrator) IdVersions() (map[string]uint64, error) {
+	if m.VersionStore != nil {
+		lister, ok := m.VersionStore.(driver.IdLister)
+		if !ok {
+			return nil, fmt.Errorf("Version store does not list ids.")
+		}
+		return lister.IdVersions()
+	}
+	d, err := m.newDriver()
+	if err != nil {
+		return nil, err
+	}
+	defer m.closeDriver(d)
+	lister, ok := d.(driver.IdLister)
+	if !ok {
+		return nil, fmt.Errorf("Driver does not list ids.")
+	}
+	return lister.IdVersions()
+}
+
+// History returns the applied versions in the order they were applied,
+// with the time each was applied at if the driver recorded it. The
+// version store, or else the driver, has to implement driver.Historian.
+func (m Migrator) History() ([]AppliedMigration, error) {
+	if m.VersionStore != nil {
+		historian, ok := m.VersionStore.(driver.Historian)
+		if !ok {
+			return nil, fmt.Errorf("Version store does not record the history of versions.")
+		}
+		return historian.History(m.Id)
+	}
+	d, err := m.newDriver()
+	if err != nil {
+		return nil, err
+	}
+	defer m.closeDriver(d)
+	historian, ok := d.(driver.Historian)
+	if !ok {
+		return nil, fmt.Errorf("Driver does not record the history of versions.")
+	}
+	return historian.History(m.Id)
+}
+
+// Unlock forcibly clears the migration lock of the driver, e.g. one
+// left behind by a crashed migrator. Only use it if no other migrator
+// is running. The driver has to implement driver.LockBreaker.
+func (m Migrator) Unlock() error {
+	d, err := m.newDriver()
+	if err != nil {
+		return err
+	}
+	defer m.closeDriver(d)
+	breaker, ok := d.(driver.LockBreaker)
+	if !ok {
+		return fmt.Errorf("Driver does not support clearing locks.")
+	}
+	return breaker.ForceUnlock(m.Id)
+}
+
+// Capabilities returns what the driver for the given url supports
+func (m Migrator) Capabilities() (driver.Capabilities, error) {
+	d, err := m.newDriver()
+	if err != nil {
+		return driver.Capabilities{}, err
+	}
+	caps := driver.CapabilitiesOf(d)
+	if err := m.closeDriver(d); err != nil {
+		return caps, err
+	}
+	return caps, nil
+}
+
+// Applied returns the up files of the migrations applied so far,
+// i.e. up to the current version, in order.
+func (m Migrator) Applied() ([]file.File, error) {
+	applied, _, err := m.status()
+	return applied, err
+}
+
+// Pending returns the up files of the migrations Up would apply, in order.
+func (m Migrator) Pending() ([]file.File, error) {
+	_, pending, err := m.status()
+	return pending, err
+}
+
+// Plan returns the files Migrate would apply for relativeN, in order,
+// e.g. for review before a deploy. It only reads from the database and
+// takes no locks.
+func (m Migrator) Plan(relativeN int) ([]file.File, error) {
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer m.closeDriver(d)
+
+	if relativeN > 0 {
+		if err := checkDirty(d, m.Id, files, version); err != nil {
+			return nil, err
+		}
+	}
+	planned, err := files.From(version, relativeN)
+	if err != nil {
+		return nil, err
+	}
+	if relativeN < 0 {
+		to := uint64(0)
+		if len(planned) == -relativeN {
+			to = planned[len(planned)-1].Version - 1
+		}
+		if err := m.checkDownFiles(d, files, version, to); err != nil {
+			return nil, err
+		}
+	}
+	if planned == nil {
+		planned = make(file.Files, 0)
+	}
+	return planned, nil
+}
+
+// status splits the up files into applied and pending ones based on the
+// current version. It only reads from the database.
+func (m Migrator) status() (applied, pending file.Files, err error) {
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer m.closeDriver(d)
+
+	applied = make(file.Files, 0)
+	pending = make(file.Files, 0)
+	sort.Sort(files)
+	for _, mf := range *files {
+		if mf.UpFile == nil {
+			continue
+		}
+		if mf.Version <= version {
+			applied = append(applied, *mf.UpFile)
+		} else {
+			pending = append(pending, *mf.UpFile)
+		}
+	}
+	return applied, pending, nil
+}
+
+// CheckSyntax validates the syntax of all pending up migrations without
+// applying them and returns the first syntax error.
+// The driver has to implement driver.SyntaxChecker.
+func (m Migrator) CheckSyntax() error {
+	d, files, version, err := m.initDriverAndReadMigrationFilesAndGetVersion(nil)
+	if err != nil {
+		return err
+	}
+	defer m.closeDriver(d)
+
+	checker, ok := d.(driver.SyntaxChecker)
+	if !ok {
+		return fmt.Errorf("Driver does not support syntax checks.")
+	}
+	pending, err := files.ToLastFrom(version)
+	if err != nil {
+		return err
+	}
+	for _, f := range pending {
+		if err := checker.CheckSyntax(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Show returns the migration file of a given version with the content
+// of both its up and down file read from the store.
+// It does not connect to the database.
+func (m Migrator) Show(version uint64) (*file.MigrationFile, error) {
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mf := range files {
+		if mf.Version != version {
+			continue
+		}
+		if mf.UpFile != nil {
+			if err := mf.UpFile.ReadContent(); err != nil {
+				return nil, err
+			}
+		}
+		if mf.DownFile != nil {
+			if err := mf.DownFile.ReadContent(); err != nil {
+				return nil, err
+			}
+		}
+		return &mf, nil
+	}
+	return nil, fmt.Errorf("No migration file for version %v found in %s.", version, strings.Join(m.migrationPaths(), ", "))
+}
+
+// Force makes version the current version without running any
+// migrations, e.g. after a failed migration was completed or undone by
+// hand. Applied versions above it are forgotten and the dirty marker,
+// if any, is cleared. The version doesn't have to belong to a migration
+// file. The driver has to be a driver.VersionForcer, unless a version
+// store is used.
+func (m Migrator) Force(version uint64) error {
+	if m.VersionStore != nil {
+		if err := m.lockVersionStore(); err != nil {
+			return migrationerror.Wrap(migrationerror.Lock, err)
+		}
+		defer m.VersionStore.Unlock(m.Id)
+		versions, err := m.VersionStore.ListVersions(m.Id)
+		if err != nil {
+			return err
+		}
+		applied := false
+		for _, v := range versions {
+			if v > version {
+				if err := m.VersionStore.SetVersion(m.Id, v, direction.Down); err != nil {
+					return err
+				}
+			}
+			applied = applied || v == version
+		}
+		if !applied && version > 0 {
+			return m.VersionStore.SetVersion(m.Id, version, direction.Up)
+		}
+		return nil
+	}
+
+	d, err := m.newDriver()
+	if err != nil {
+		return err
+	}
+	defer m.closeDriver(d)
+	forcer, ok := d.(driver.VersionForcer)
+	if !ok {
+		return fmt.Errorf("Driver does not support forcing the version.")
+	}
+	if locker, ok := d.(driver.Locker); ok {
+		if err := locker.Lock(m.Id); err != nil {
+			return migrationerror.Wrap(migrationerror.Lock, err)
+		}
+		defer locker.Unlock(m.Id)
+	}
+	return forcer.ForceVersion(m.Id, version)
+}
+
+// Baseline records the versions of all migration files up to version,
+// and version itself, as applied without running them, e.g. when
+// adopting migrate on a database that predates it. It fails if any
+// version is applied already. The driver has to be a driver.Baseliner,
+// unless a version store is used.
+func (m Migrator) Baseline(version uint64) error {
+	if version == 0 {
+		return fmt.Errorf("Baseline version must be greater than 0.")
+	}
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return err
+	}
+	versions := make([]uint64, 0)
+	for _, mf := range files {
+		if mf.Version < version {
+			versions = append(versions, mf.Version)
+		}
+	}
+	versions = append(versions, version)
+
+	if m.VersionStore != nil {
+		if err := m.lockVersionStore(); err != nil {
+			return migrationerror.Wrap(migrationerror.Lock, err)
+		}
+		defer m.VersionStore.Unlock(m.Id)
+		applied, err := m.VersionStore.ListVersions(m.Id)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			return fmt.Errorf("Unable to baseline, version %v is applied already.", applied[len(applied)-1])
+		}
+		for _, v := range versions {
+			if err := m.VersionStore.SetVersion(m.Id, v, direction.Up); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	d, err := m.newDriver()
+	if err != nil {
+		return err
+	}
+	defer m.closeDriver(d)
+	baseliner, ok := d.(driver.Baseliner)
+	if !ok {
+		return fmt.Errorf("Driver does not support baselining.")
+	}
+	if locker, ok := d.(driver.Locker); ok {
+		if err := locker.Lock(m.Id); err != nil {
+			return migrationerror.Wrap(migrationerror.Lock, err)
+		}
+		defer locker.Unlock(m.Id)
+	}
+	current, err := d.Version(m.Id)
+	if err != nil {
+		return err
+	}
+	if current > 0 {
+		return fmt.Errorf("Unable to baseline, version %v is applied already.", current)
+	}
+	return baseliner.Baseline(m.Id, versions)
+}
+
+// ApplyFile runs the up or down file of a given version, without
+// recording or checking versions, e.g. to rerun a migration while
+// testing it. The driver has to be a driver.Executor.
+func (m Migrator) ApplyFile(version uint64, dir direction.Direction) error {
+	mf, err := m.Show(version)
+	if err != nil {
+		return err
+	}
+	f := mf.UpFile
+	if dir == direction.Down {
+		f = mf.DownFile
+	}
+	if f == nil {
+		return fmt.Errorf("No %s migration file for version %v found in %s.", dir, version, strings.Join(m.migrationPaths(), ", "))
+	}
+
+	d, err := m.newDriver()
+	if err != nil {
+		return err
+	}
+	defer m.closeDriver(d)
+	executor, ok := d.(driver.Executor)
+	if !ok {
+		return fmt.Errorf("Driver can't run migrations without recording their version.")
+	}
+	if err := checkMultiStatement(d, file.Files{*f}); err != nil {
+		return err
+	}
+	if locker, ok := d.(driver.Locker); ok {
+		if err := locker.Lock(m.Id); err != nil {
+			return migrationerror.Wrap(migrationerror.Lock, err)
+		}
+		defer locker.Unlock(m.Id)
+	}
+
+	if err := m.beforeEach(*f); err != nil {
+		return err
+	}
+	pipe := pipep.New()
+	go executor.Execute(*f, pipe)
+	if errs := pipep.ReadErrors(m.trace(d, *f, pipe)); len(errs) > 0 {
+		return errs[0]
+	}
+	return m.afterEach(*f)
+}
+
+// Lint checks, on a best effort basis, that the down files drop
+// the objects created by their up files.
+// It does not connect to the database.
+func (m Migrator) Lint() ([]file.LintWarning, error) {
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	return file.Lint(files)
+}
+
 // Create creates new migration files on disk
 func (m Migrator) Create(name string) (*file.MigrationFile, error) {
-	d, err := driver.New(m.Instance, m.Url)
+	d, err := m.newDriver()
+	if err != nil {
+		return nil, err
+	}
+	filenameRegex, err := m.filenameRegex(d.FilenameExtension())
 	if err != nil {
 		return nil, err
 	}
-	files, err := file.ReadMigrationFilesFromStore(m.Store, m.Path,
-		file.FilenameRegex(d.FilenameExtension()))
+	files, err := file.ReadMigrationFilesFromStoresWithOptions(m.Store, m.migrationPaths(), filenameRegex, m.readOptions())
 	if err != nil {
 		return nil, err
 	}
 
-	version := uint64(0)
+	lastVersion := uint64(0)
 	if len(files) > 0 {
 		lastFile := files[len(files)-1]
-		version = lastFile.Version
+		lastVersion = lastFile.Version
 	}
-	version += 1
-	versionStr := strconv.FormatUint(version, 10)
 
-	length := 4 // TODO(mattes) check existing files and try to guess length
-	if len(versionStr)%length != 0 {
-		versionStr = strings.Repeat("0", length-len(versionStr)%length) + versionStr
+	var version uint64
+	var versionStr string
+	switch m.VersionFormat {
+	case "", VersionSequential:
+		version = lastVersion + 1
+		versionStr = strconv.FormatUint(version, 10)
+		if digits := m.versionDigits(files); len(versionStr) < digits {
+			versionStr = strings.Repeat("0", digits-len(versionStr)) + versionStr
+		}
+
+	case VersionTimestamp:
+		layout, _, err := m.timestampFormat()
+		if err != nil {
+			return nil, err
+		}
+		if version, versionStr, err = timestampVersion(time.Now(), layout); err != nil {
+			return nil, err
+		}
+		if version <= lastVersion {
+			return nil, fmt.Errorf("Timestamp version %v is not greater than the latest version %v.", version, lastVersion)
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown version format '%s'.", m.VersionFormat)
 	}
 
-	filenamef := "%s_%s.%s.%s"
 	name = strings.Replace(name, " ", "_", -1)
+	upContent, err := m.renderTemplate(version, name, direction.Up)
+	if err != nil {
+		return nil, err
+	}
+	if m.CreateUpOnly {
+		upContent = append([]byte(file.IrreversibleDirective+"\n"), upContent...)
+	}
+	downContent, err := m.renderTemplate(version, name, direction.Down)
+	if err != nil {
+		return nil, err
+	}
 
-	mfile := &file.MigrationFile{
+	mfile, err := m.newMigrationFile(d.FilenameExtension(), version, versionStr, name, upContent, downContent)
+	if err != nil {
+		return nil, err
+	}
+	if m.CreateUpOnly {
+		mfile.DownFile = nil
+	}
+	if err := mfile.WriteToStore(m.Store); err != nil {
+		return nil, err
+	}
+	return mfile, nil
+}
+
+// newMigrationFile returns the migration file of version, named by
+// versionStr and name, in the first migration path and the layout of the
+// migrator. Nothing is written.
+func (m Migrator) newMigrationFile(extension string, version uint64, versionStr, name string, upContent, downContent []byte) (*file.MigrationFile, error) {
+	separator, err := m.nameSeparator()
+	if err != nil {
+		return nil, err
+	}
+	filenamef := "%s%s%s.%s.%s"
+	upFileName := fmt.Sprintf(filenamef, versionStr, separator, name, "up", extension)
+	downFileName := fmt.Sprintf(filenamef, versionStr, separator, name, "down", extension)
+	combined := m.Layout == file.CombinedLayout
+	if combined {
+		upFileName = fmt.Sprintf("%s%s%s.%s", versionStr, separator, name, extension)
+		downFileName = upFileName
+	}
+
+	return &file.MigrationFile{
 		Version: version,
 		UpFile: &file.File{
-			Path:      m.Path,
-			FileName:  fmt.Sprintf(filenamef, versionStr, name, "up", d.FilenameExtension()),
+			Path:      m.migrationPaths()[0],
+			FileName:  upFileName,
 			Name:      name,
-			Content:   []byte(""),
+			Content:   upContent,
 			Direction: direction.Up,
+			Combined:  combined,
 		},
 		DownFile: &file.File{
-			Path:      m.Path,
-			FileName:  fmt.Sprintf(filenamef, versionStr, name, "down", d.FilenameExtension()),
+			Path:      m.migrationPaths()[0],
+			FileName:  downFileName,
 			Name:      name,
-			Content:   []byte(""),
+			Content:   downContent,
 			Direction: direction.Down,
+			Combined:  combined,
 		},
-	}
+	}, nil
+}
 
-	if err := ioutil.WriteFile(path.Join(mfile.UpFile.Path, mfile.UpFile.FileName), mfile.UpFile.Content, 0644); err != nil {
+// readMigrationFiles reads the migration files for the url's driver
+// without initializing the driver.
+func (m Migrator) readMigrationFiles() (file.MigrationFiles, error) {
+	url, err := m.driverUrl()
+	if err != nil {
 		return nil, err
 	}
-	if err := ioutil.WriteFile(path.Join(mfile.DownFile.Path, mfile.DownFile.FileName), mfile.DownFile.Content, 0644); err != nil {
+	d, err := driver.Lookup(url)
+	if err != nil {
+		return nil, err
+	}
+	filenameRegex, err := m.filenameRegex(d.FilenameExtension())
+	if err != nil {
 		return nil, err
 	}
+	files, err := file.ReadMigrationFilesFromStoresWithOptions(m.Store, m.migrationPaths(), filenameRegex, m.readOptions())
+	if err != nil {
+		return nil, err
+	}
+	if m.StrictSequence && m.VersionFormat != VersionTimestamp {
+		if err := files.CheckSequence(); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
 
-	return mfile, nil
+// filterMigrationFiles returns the files matching NameFilter, or files
+// if it is empty.
+func (m Migrator) filterMigrationFiles(files file.MigrationFiles) (file.MigrationFiles, error) {
+	if m.NameFilter == "" {
+		return files, nil
+	}
+	return files.FilterByName(m.NameFilter)
+}
+
+// migrationPaths returns Paths, or Path if there are none.
+func (m Migrator) migrationPaths() []string {
+	if len(m.Paths) > 0 {
+		return m.Paths
+	}
+	return []string{m.Path}
+}
+
+// Open connects to the database and keeps the driver open for all
+// following calls, instead of every call connecting on its own, e.g.
+// in a long running service. Call Close once done. An opened migrator
+// must not be used by multiple goroutines at once.
+func (m *Migrator) Open() error {
+	if m.driver != nil {
+		return nil
+	}
+	d, err := m.newDriver()
+	if err != nil {
+		return err
+	}
+	m.driver = d
+	return nil
+}
+
+// Close closes the driver kept open by Open.
+func (m *Migrator) Close() error {
+	if m.driver == nil {
+		return nil
+	}
+	d := m.driver
+	m.driver = nil
+	return d.Close()
+}
+
+// closeDriver closes d unless it is the driver kept open by Open
+func (m Migrator) closeDriver(d driver.Driver) error {
+	if d == m.driver {
+		return nil
+	}
+	return d.Close()
+}
+
+// newDriver returns a new initialized driver for the migrator's url,
+// or the one kept open by Open
+func (m Migrator) newDriver() (driver.Driver, error) {
+	return m.connect(nil)
+}
+
+// connect is newDriver, reporting connection retries down pipe
+// if it is not nil
+func (m Migrator) connect(pipe chan interface{}) (driver.Driver, error) {
+	if m.driver != nil {
+		if setter, ok := m.driver.(driver.ContextSetter); ok {
+			// don't keep the context of a previous call
+			setter.SetContext(m.RunContext)
+		}
+		return m.driver, nil
+	}
+	url, err := m.driverUrl()
+	if err != nil {
+		return nil, err
+	}
+	d, err := driver.NewWithRetry(m.Instance, url, m.ConnectRetry, func(err error, wait time.Duration) {
+		if pipe != nil {
+			m.send(pipe, fmt.Sprintf("Unable to connect, retrying in %v: %v", wait, err))
+		}
+	})
+	if err != nil {
+		return nil, migrationerror.Wrap(migrationerror.Connection, err)
+	}
+	if m.Journal != nil {
+		j, ok := d.(driver.Journaler)
+		if !ok {
+			d.Close()
+			return nil, fmt.Errorf("Driver does not support journaling.")
+		}
+		j.SetJournal(journal.New(m.Journal))
+	}
+	if m.DryRun {
+		r, ok := d.(driver.DryRunner)
+		if !ok {
+			d.Close()
+			return nil, fmt.Errorf("Driver does not support dry runs.")
+		}
+		r.SetDryRun(true)
+	}
+	if setter, ok := d.(driver.ContextSetter); ok && m.RunContext != nil {
+		setter.SetContext(m.RunContext)
+	}
+	return d, nil
 }
 
 // initDriverAndReadMigrationFilesAndGetVersion is a small helper
-// function that is common to most of the migration funcs
-func (m Migrator) initDriverAndReadMigrationFilesAndGetVersion() (driver.Driver, *file.MigrationFiles, uint64, error) {
-	d, err := driver.New(m.Instance, m.Url)
+// function that is common to most of the migration funcs. Connection
+// retries are reported down pipe if it is not nil.
+func (m Migrator) initDriverAndReadMigrationFilesAndGetVersion(pipe chan interface{}) (driver.Driver, *file.MigrationFiles, uint64, error) {
+	// read the files first, so that invalid ones fail before
+	// the database is touched
+	all, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	files, err := m.filterMigrationFiles(all)
 	if err != nil {
 		return nil, nil, 0, err
 	}
-	files, err := file.ReadMigrationFilesFromStore(m.Store, m.Path,
-		file.FilenameRegex(d.FilenameExtension()))
+	d, err := m.connect(pipe)
 	if err != nil {
-		d.Close() // TODO what happens with errors from this func?
 		return nil, nil, 0, err
 	}
-	version, err := d.Version(m.Id)
+	version, err := m.currentVersion(d)
 	if err != nil {
-		d.Close() // TODO what happens with errors from this func?
+		m.closeDriver(d) // TODO what happens with errors from this func?
 		return nil, nil, 0, err
 	}
+	if m.ExpectVersion != nil && *m.ExpectVersion != version {
+		m.closeDriver(d) // TODO what happens with errors from this func?
+		return nil, nil, 0, fmt.Errorf("Expected current version %v, but it is %v.", *m.ExpectVersion, version)
+	}
+	if !m.AllowAhead {
+		if err := checkAhead(all, version); err != nil {
+			m.closeDriver(d)
+			return nil, nil, 0, err
+		}
+	}
 	return d, &files, version, nil
 }
 
+// checkAhead returns an error if version is higher than the highest
+// version of files, which are then likely from an older revision.
+func checkAhead(files file.MigrationFiles, version uint64) error {
+	highest := uint64(0)
+	for _, f := range files {
+		if f.Version > highest {
+			highest = f.Version
+		}
+	}
+	if version > highest {
+		return fmt.Errorf("Database version %v is ahead of the highest migration file version %v. "+
+			"The migration files may be from an older revision, set AllowAhead (-allow-ahead) to migrate anyway.", version, highest)
+	}
+	return nil
+}
+
+// checkDirty returns an error naming the last completed version
+// if the driver tracks dirty state and the current version is dirty.
+func checkDirty(d driver.Driver, id string, files *file.MigrationFiles, version uint64) error {
+	tracker, ok := d.(driver.DirtyTracker)
+	if !ok {
+		return nil
+	}
+	dirty, err := tracker.Dirty(id)
+	if err != nil || !dirty {
+		return err
+	}
+
+	lastCompleted := uint64(0)
+	for _, f := range *files {
+		if f.Version < version && f.Version > lastCompleted {
+			lastCompleted = f.Version
+		}
+	}
+	return fmt.Errorf("Dirty database version %v: the migration stopped before completing. "+
+		"The last successfully completed version is %v. "+
+		"Fix the database manually and clear the dirty marker with force %v, then run up again.", version, lastCompleted, lastCompleted)
+}
+
+// checkMultiStatement returns an error for the first of files with more
+// than one statement if the driver runs a file as a single statement,
+// which would fail confusingly. Drivers that can't count statements
+// aren't checked.
+func checkMultiStatement(d driver.Driver, files file.Files) error {
+	counter, ok := d.(driver.StatementCounter)
+	if !ok || d.SupportsMultiStatement() {
+		return nil
+	}
+	for _, f := range files {
+		if err := f.ReadContent(); err != nil {
+			return err
+		}
+		if n := counter.CountStatements(f.Content); n > 1 {
+			return migrationerror.New(f, "", fmt.Errorf("%s has %d statements, but the connection runs one statement per file. "+
+				"Enable multi-statement execution in the url (e.g. ?multiStatements=true for MySQL) or split the file.", f.FileName, n))
+		}
+	}
+	return nil
+}
+
+// context returns the migrator's context, context.Background() if unset
+func (m Migrator) context() context.Context {
+	if m.Context == nil {
+		return context.Background()
+	}
+	return m.Context
+}
+
+// send sends item down pipe unless the migrator's context is done
+func (m Migrator) send(pipe chan interface{}, item interface{}) {
+	m.log(item)
+	pipep.Send(m.context(), pipe, item)
+}
+
+// closePipe closes pipe after sending err, unless the migrator's
+// context is done
+func (m Migrator) closePipe(pipe chan interface{}, err error) {
+	if err != nil {
+		m.log(err)
+	}
+	pipep.CloseContext(m.context(), pipe, err)
+}
+
+// waitAndRedirect is pipe.WaitAndRedirect with the migrator's context
+func (m Migrator) waitAndRedirect(pipe, redirectPipe chan interface{}) (ok bool) {
+	errorReceived, interrupted := pipep.WaitAndRedirectStatusContext(m.context(), m.logged(pipe), redirectPipe, handleInterrupts())
+	return !errorReceived && !interrupted
+}
+
 // NewPipe is a convenience function for pipe.New().
 // This is helpful if the user just wants to import this package and nothing else.
 func NewPipe() chan interface{} {
@@ -311,6 +1391,14 @@ func NewPipe() chan interface{} {
 // interrupt handling
 var interrupts = true
 
+// firstInterrupt receives the first ^C of the process once interrupts
+// are handled; every later ^C exits immediately, see handleInterrupts
+var (
+	handleInterruptsOnce sync.Once
+	firstInterrupt       = make(chan os.Signal, 1)
+	interruptsReceived   int32
+)
+
 // Graceful enables interrupts checking. Once the first ^C is received
 // it will finish the currently running migration and abort execution
 // of the next migration. If ^C is received twice, it will stop
@@ -326,12 +1414,35 @@ func NonGraceful() {
 }
 
 // interrupts returns a signal channel if interrupts checking is
-// enabled. nil otherwise.
+// enabled. nil otherwise. The channel receives the first ^C only: once
+// interrupts are handled, a second ^C exits the process wherever it is,
+// e.g. while waiting for a lock after the first one stopped the run.
 func handleInterrupts() chan os.Signal {
-	if interrupts {
+	if !interrupts {
+		return nil
+	}
+	handleInterruptsOnce.Do(func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
-		return c
+		go func() {
+			for sig := range c {
+				if atomic.AddInt32(&interruptsReceived, 1) > 1 || !interrupts {
+					os.Exit(5)
+				}
+				firstInterrupt <- sig
+			}
+		}()
+	})
+	return firstInterrupt
+}
+
+// interruptPending reports whether an interrupt was received that no
+// pipe is waiting for, e.g. between two migrations, and takes it.
+func interruptPending(interrupt chan os.Signal) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
 	}
-	return nil
 }
@@ -1,8 +1,22 @@
 package migrate
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/PlanitarInc/migrate/driver/bash"
+	"github.com/PlanitarInc/migrate/driver/mock"
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	pipep "github.com/PlanitarInc/migrate/pipe"
 )
 
 // Add Driver URLs here to test basic Up, Down, .. functions.
@@ -247,3 +261,767 @@ func TestMigrate(t *testing.T) {
 		}
 	}
 }
+
+func TestVerify(t *testing.T) {
+	for _, driverUrl := range driverUrls {
+		t.Logf("Test driver: %s", driverUrl)
+		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpdir)
+
+		m := Migrator{Url: driverUrl, Path: tmpdir}
+
+		ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sql"), []byte("SELECT 1;"), 0644)
+		ioutil.WriteFile(path.Join(tmpdir, "0001_a.down.sql"), nil, 0644)
+
+		if errs, ok := m.ResetSync(); !ok {
+			t.Fatal(errs)
+		}
+		if errs, ok := m.Verify(); !ok {
+			t.Errorf("Expected unchanged files to verify, got %v", errs)
+		}
+
+		ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sql"), []byte("SELECT 2;"), 0644)
+		if errs, ok := m.Verify(); ok || len(errs) != 1 {
+			t.Errorf("Expected the edited file to be reported, got %v", errs)
+		}
+	}
+}
+
+func TestShow(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), []byte("echo up"), 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.down.sh"), []byte("echo down"), 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_bar.up.sh"), []byte("echo bar"), 0644)
+
+	// no database is needed, so the url doesn't have to be reachable
+	m := Migrator{Url: "postgres://unreachable:1/db", Path: tmpdir}
+	if _, err := m.Show(1); err == nil {
+		t.Error("Expected postgres driver not to find .sh files")
+	}
+
+	m.Url = "bash://"
+	mf, err := m.Show(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mf.UpFile.Content) != "echo up" || string(mf.DownFile.Content) != "echo down" {
+		t.Errorf("Unexpected content %q, %q", mf.UpFile.Content, mf.DownFile.Content)
+	}
+
+	mf, err = m.Show(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.DownFile != nil {
+		t.Error("Expected no down file for version 2")
+	}
+
+	if _, err := m.Show(3); err == nil {
+		t.Error("Expected error for unknown version")
+	}
+}
+
+func TestUpSince(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sh", "0002_b.up.sh", "0003_c.up.sh"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	errs, ok := m.UpSinceSync(1)
+	if !ok {
+		t.Fatal(errs)
+	}
+	versions, _ := store.ListVersions("")
+	if len(versions) != 2 || versions[0] != 2 || versions[1] != 3 {
+		t.Fatalf("Expected versions 2 and 3 to be applied, got %v", versions)
+	}
+}
+
+func TestExpectVersion(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_b.up.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{1: true}}
+	expect := uint64(0)
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store, ExpectVersion: &expect}
+
+	if _, ok := m.UpSync(); ok {
+		t.Fatal("Expected up to fail on unexpected version")
+	}
+	if version, _ := m.Version(); version != 1 {
+		t.Fatalf("Expected version 1, got %v", version)
+	}
+
+	expect = 1
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 2 {
+		t.Fatalf("Expected version 2, got %v", version)
+	}
+}
+
+func TestJournalUnsupported(t *testing.T) {
+	m := Migrator{Url: "bash://", Journal: &bytes.Buffer{}}
+	if _, ok := m.UpSync(); ok {
+		t.Error("Expected error for a driver that can't journal")
+	}
+}
+
+func TestDryRunUnsupported(t *testing.T) {
+	m := Migrator{Url: "bash://", DryRun: true}
+	if _, ok := m.UpSync(); ok {
+		t.Error("Expected error for a driver that can't do dry runs")
+	}
+}
+
+func TestAbandonedPipe(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// more migrations than fit into the buffer of the pipe
+	n := 3 * pipep.BufferSize
+	for i := 1; i <= n; i++ {
+		ioutil.WriteFile(path.Join(tmpdir, fmt.Sprintf("%04d_m.up.sh", i)), nil, 0644)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store, Context: ctx}
+
+	pipe := NewPipe()
+	done := make(chan struct{})
+	go func() {
+		m.Up(pipe)
+		close(done)
+	}()
+
+	// read the first file, then stop reading
+	<-pipe
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected migrations to stop once the pipe was abandoned")
+	}
+	if len(store.versions) == 0 || len(store.versions) == n {
+		t.Errorf("Expected migrations to stop mid-batch, got versions %v", store.versions)
+	}
+}
+
+func TestAbandonedPipeWithoutContext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for i := 1; i <= 3*pipep.BufferSize; i++ {
+		ioutil.WriteFile(path.Join(tmpdir, fmt.Sprintf("%04d_m.up.sql", i)), nil, 0644)
+	}
+
+	// the first migration fails and nobody reads the pipe, the few
+	// messages sent fit into its buffer
+	m := Migrator{Url: "mock://?fail_on=1", Path: tmpdir, Instance: mock.NewDatabase()}
+	pipe := NewPipe()
+	done := make(chan struct{})
+	go func() {
+		m.Up(pipe)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the producer to finish without anybody reading the pipe")
+	}
+	if errs := pipep.ReadErrors(pipe); len(errs) != 1 {
+		t.Errorf("Expected the error to be buffered, got %v", errs)
+	}
+
+	// the consumer abandons the pipe after the first message of many
+	m = Migrator{Url: "mock://", Path: tmpdir, Instance: mock.NewDatabase()}
+	pipe = NewPipe()
+	done = make(chan struct{})
+	go func() {
+		m.Up(pipe)
+		close(done)
+	}()
+	<-pipe
+	pipep.Abandon(pipe)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the producer to finish after the pipe was abandoned")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_b.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0003_c.up.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{1: true, 2: true}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	applied, err := m.Applied()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 2 {
+		t.Errorf("Expected versions 1 and 2 to be applied, got %v", applied)
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 3 {
+		t.Errorf("Expected version 3 to be pending, got %v", pending)
+	}
+	if store.locks != 0 {
+		t.Error("Expected status not to take the lock")
+	}
+}
+
+func TestApplyFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	if err := m.ApplyFile(1, direction.Up); err != nil {
+		t.Fatal(err)
+	}
+	if store.sets != 0 {
+		t.Errorf("Expected no version to be recorded, got %v", store.versions)
+	}
+	if err := m.ApplyFile(1, direction.Down); err == nil {
+		t.Error("Expected error for the missing down file")
+	}
+	if err := m.ApplyFile(2, direction.Up); err == nil {
+		t.Error("Expected error for an unknown version")
+	}
+}
+
+func TestCreateUpOnly(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+	if _, err := m.Create("create_things"); err != nil {
+		t.Fatal(err)
+	}
+	m.CreateUpOnly = true
+	mfile, err := m.Create("backfill_things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mfile.DownFile != nil {
+		t.Errorf("Expected no down file, got %v", mfile.DownFile.FileName)
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "0002_backfill_things.down.sh")); !os.IsNotExist(err) {
+		t.Errorf("Expected no down file to be written, got %v", err)
+	}
+
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if _, ok := m.DownSync(); ok {
+		t.Error("Expected down to fail at the irreversible migration")
+	}
+	if _, ok := m.MigrateSync(-1); ok {
+		t.Error("Expected migrate -1 to fail at the irreversible migration")
+	}
+	if version, _ := m.Version(); version != 2 {
+		t.Errorf("Expected version 2, got %v", version)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.down.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	}
+	opened := m.driver
+
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if d, err := m.newDriver(); err != nil || d != opened {
+		t.Errorf("Expected the opened driver to be reused, got %v, %v", d, err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.driver != nil {
+		t.Error("Expected Close to drop the opened driver")
+	}
+}
+
+// batchDriver records the versions of every batch it migrates
+type batchDriver struct {
+	bash.Driver
+	batches [][]uint64
+}
+
+func (d *batchDriver) MigrateBatch(id string, files file.Files, pipe chan interface{}) {
+	defer close(pipe)
+	versions := make([]uint64, 0)
+	for _, f := range files {
+		pipe <- f
+		versions = append(versions, f.Version)
+	}
+	d.batches = append(d.batches, versions)
+}
+
+func TestAllInOneTx(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_b.up.sh"), nil, 0644)
+
+	d := &batchDriver{}
+	m := Migrator{Url: "bash://", Path: tmpdir, AllInOneTx: true, driver: d}
+
+	pipe := NewPipe()
+	go m.Up(pipe)
+	progress := make([]Progress, 0)
+	for item := range pipe {
+		switch item := item.(type) {
+		case Progress:
+			progress = append(progress, item)
+		case error:
+			t.Fatal(item)
+		}
+	}
+	if len(d.batches) != 1 || len(d.batches[0]) != 2 {
+		t.Fatalf("Expected one batch of 2 files, got %v", d.batches)
+	}
+	if len(progress) != 2 || progress[1] != (Progress{Current: 2, Total: 2}) {
+		t.Errorf("Expected progress of 2 files, got %v", progress)
+	}
+
+	m.AllInOneTx = false
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if len(d.batches) != 1 {
+		t.Errorf("Expected files to be migrated one by one, got %v", d.batches)
+	}
+}
+
+// singleStatementDriver counts statements, but runs a file as one
+type singleStatementDriver struct {
+	bash.Driver
+}
+
+func (d *singleStatementDriver) CountStatements(content []byte) int {
+	return strings.Count(string(content), ";")
+}
+
+func TestMultiStatement(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_a.up.sh"), []byte("echo a;"), 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_b.up.sh"), []byte("echo b; echo c;"), 0644)
+
+	m := Migrator{Url: "bash://", Path: tmpdir, driver: &singleStatementDriver{}}
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	merr, isMigrationError := errs[0].(*MigrationError)
+	if !isMigrationError || merr.File.Version != 2 || !strings.Contains(merr.Error(), "0002_b.up.sh has 2 statements") {
+		t.Errorf("Expected an error for the statements of version 2, got %v", errs[0])
+	}
+
+	m.driver = &bash.Driver{}
+	if errs, ok := m.UpSync(); !ok {
+		t.Errorf("Expected drivers that can't count statements to migrate, got %v", errs)
+	}
+}
+
+func TestMockDriver(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql", "0002_b.down.sql", "0003_c.up.sql", "0003_c.down.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	db := mock.NewDatabase()
+	m := Migrator{Url: "mock://?fail_on=3", Path: tmpdir, Id: "tenant1", Instance: db}
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected version 3 to fail, got %v", errs)
+	}
+	if version, err := m.Version(); err != nil || version != 2 {
+		t.Errorf("Expected version 2, got %v, %v", version, err)
+	}
+
+	m.Url = "mock://"
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if errs, ok := m.DownNSync(2); !ok {
+		t.Fatal(errs)
+	}
+	if versions := db.Versions("tenant1"); len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("Expected version 1 in the database, got %v", versions)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0002_b.up.sql", "0003_c.up.sql", "0004_d.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	// version 3 stops halfway and is left dirty
+	db := mock.NewDatabase()
+	m := Migrator{Url: "mock://?stop_on=3", Path: tmpdir, Instance: db}
+	if errs, ok := m.UpSync(); ok || len(errs) != 1 {
+		t.Fatalf("Expected version 3 to stop, got %v", errs)
+	}
+	if !db.Dirty("") {
+		t.Fatal("Expected the version to be dirty")
+	}
+
+	m.Url = "mock://"
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 || !strings.Contains(errs[0].Error(), "Dirty database version 3") ||
+		!strings.Contains(errs[0].Error(), "last successfully completed version is 2") {
+		t.Fatalf("Expected up to refuse the dirty version 3, got %v", errs)
+	}
+	if errs, ok := m.MigrateSync(+1); ok || len(errs) != 1 {
+		t.Errorf("Expected migrate +1 to refuse the dirty version, got %v", errs)
+	}
+	if errs, ok := m.GotoSync(4); ok || len(errs) != 1 {
+		t.Errorf("Expected goto to refuse the dirty version, got %v", errs)
+	}
+	if versions := db.Versions(""); len(versions) != 3 {
+		t.Errorf("Expected nothing to be applied on a dirty version, got %v", versions)
+	}
+
+	if err := m.Force(2); err != nil {
+		t.Fatal(err)
+	}
+	if db.Dirty("") {
+		t.Error("Expected force to clear the dirty marker")
+	}
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if versions := db.Versions(""); len(versions) != 4 || db.Dirty("") {
+		t.Errorf("Expected up to resume after version 2, got %v", versions)
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_users.up.sql", "0002_tenant_a.up.sql", "0003_posts.up.sql", "0004_tenant_b.up.sql", "0005_comments.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	db := mock.NewDatabase()
+	m := Migrator{Url: "mock://", Path: tmpdir, Instance: db, NameFilter: "tenant_*"}
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Version != 2 || pending[1].Version != 4 {
+		t.Errorf("Expected versions 2 and 4 to be pending, got %v", pending)
+	}
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if versions := db.Versions(""); len(versions) != 2 || versions[0] != 2 || versions[1] != 4 {
+		t.Errorf("Expected versions 2 and 4 in the database, got %v", versions)
+	}
+
+	// version 4 isn't ahead of the files the filter left out
+	m.NameFilter = "users"
+	if applied, err := m.Applied(); err != nil || len(applied) != 1 {
+		t.Errorf("Expected version 1 to count as applied, got %v, %v", applied, err)
+	}
+
+	m.NameFilter = "[tenant"
+	if _, err := m.Pending(); err == nil {
+		t.Error("Expected error for an invalid name filter")
+	}
+}
+
+func TestUpN(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0002_b.up.sql", "0003_c.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	m := Migrator{Url: "mock://", Path: tmpdir, Instance: mock.NewDatabase()}
+	if errs, ok := m.UpNSync(1); !ok {
+		t.Fatal(errs)
+	}
+	if version, err := m.Version(); err != nil || version != 1 {
+		t.Errorf("Expected version 1, got %v, %v", version, err)
+	}
+
+	pipe := NewPipe()
+	go m.UpN(pipe, 5)
+	messages := make([]string, 0)
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			t.Fatal(item)
+		case string:
+			messages = append(messages, item)
+		}
+	}
+	if len(messages) != 1 || messages[0] != "Only 2 migrations are pending, applying all of them." {
+		t.Errorf("Expected the number of pending migrations to be reported, got %v", messages)
+	}
+	if version, err := m.Version(); err != nil || version != 3 {
+		t.Errorf("Expected version 3, got %v, %v", version, err)
+	}
+
+	if _, ok := m.UpNSync(0); ok {
+		t.Error("Expected error for n = 0")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql", "0002_b.down.sql", "0003_c.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	db := mock.NewDatabase()
+	m := Migrator{Url: "mock://", Path: tmpdir, Instance: db}
+	if errs, ok := m.UpNSync(2); !ok {
+		t.Fatal(errs)
+	}
+
+	var tests = []struct {
+		relativeN int
+		expect    []string
+	}{
+		{+1, []string{"0003_c.up.sql"}},
+		{+5, []string{"0003_c.up.sql"}},
+		{-2, []string{"0002_b.down.sql", "0001_a.down.sql"}},
+		{0, []string{}},
+	}
+	for _, test := range tests {
+		planned, err := m.Plan(test.relativeN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0)
+		for _, f := range planned {
+			names = append(names, f.FileName)
+		}
+		if strings.Join(names, ",") != strings.Join(test.expect, ",") {
+			t.Errorf("Expected plan %v for %+d, got %v", test.expect, test.relativeN, names)
+		}
+	}
+	if versions := db.Versions(""); len(versions) != 2 {
+		t.Errorf("Expected planning not to apply anything, got versions %v", versions)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql", "0003_c.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), []byte("-- "+name), 0644)
+	}
+
+	db := mock.NewDatabase()
+	calls := make([]string, 0)
+	m := Migrator{Url: "mock://", Path: tmpdir, Instance: db}
+	m.BeforeEach = func(f file.File) error {
+		calls = append(calls, "before "+f.FileName)
+		if string(f.Content) != "-- "+f.FileName {
+			t.Errorf("Expected the content of %s, got %q", f.FileName, f.Content)
+		}
+		if f.Version == 3 {
+			return errors.New("flag service unavailable")
+		}
+		return nil
+	}
+	m.AfterEach = func(f file.File) error {
+		calls = append(calls, "after "+f.FileName+" "+f.Direction.String())
+		return nil
+	}
+
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected the hook of version 3 to fail, got %v", errs)
+	}
+	if merr, ok := errs[0].(*MigrationError); !ok || merr.Category != HookError || merr.File.Version != 3 {
+		t.Errorf("Expected a hook error of version 3, got %#v", errs[0])
+	}
+	expect := []string{"before 0001_a.up.sql", "after 0001_a.up.sql up", "before 0002_b.up.sql", "after 0002_b.up.sql up", "before 0003_c.up.sql"}
+	if strings.Join(calls, ", ") != strings.Join(expect, ", ") {
+		t.Errorf("Expected hooks %v, got %v", expect, calls)
+	}
+	if versions := db.Versions(""); len(versions) != 2 {
+		t.Errorf("Expected version 3 to be skipped, got %v", versions)
+	}
+
+	db = mock.NewDatabase()
+	m.Instance = db
+	m.BeforeEach = nil
+	m.AfterEach = func(f file.File) error {
+		return errors.New("flag service unavailable")
+	}
+	errs, ok = m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected the hook after version 1 to fail, got %v", errs)
+	}
+	if versions := db.Versions(""); len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("Expected version 1 to be kept and the run to stop, got %v", versions)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sql", "0002_b.up.sql", "0003_c.up.sql", "0004_d.up.sql"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	// a ^C received while the first migration runs
+	handleInterrupts() <- os.Interrupt
+
+	db := mock.NewDatabase()
+	m := Migrator{Url: "mock://", Path: tmpdir, Instance: db}
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	var interrupted *Interrupted
+	if !errors.As(errs[0], &interrupted) || interrupted.Applied.Version != 1 || interrupted.Skipped == nil || interrupted.Skipped.Version != 2 {
+		t.Fatalf("Expected an interrupt after version 1, got %#v", errs[0])
+	}
+	if errs[0].Error() != "Interrupted after 0001_a.up.sql, skipped 0002_b.up.sql and 2 more migrations." {
+		t.Errorf("Unexpected message %q", errs[0].Error())
+	}
+	if !strings.Contains(errs[0].(*MigrationError).Category.String(), "interrupt") {
+		t.Errorf("Expected the interrupt category, got %v", errs[0].(*MigrationError).Category)
+	}
+	if versions := db.Versions(""); len(versions) != 1 {
+		t.Errorf("Expected only version 1 to be applied, got %v", versions)
+	}
+}
+
+func TestVersionWith(t *testing.T) {
+	db := mock.NewDatabase()
+	d := &mock.Driver{}
+	if err := d.Initialize(db, "mock://"); err != nil {
+		t.Fatal(err)
+	}
+	d.Baseline("tenant1", []uint64{1, 2})
+	d.Baseline("tenant2", []uint64{1})
+
+	for id, expect := range map[string]uint64{"tenant1": 2, "tenant2": 1, "tenant3": 0} {
+		m := Migrator{Url: "mock://", Id: id}
+		if version, err := m.VersionWith(d); err != nil || version != expect {
+			t.Errorf("Expected version %v of %v, got %v, %v", expect, id, version, err)
+		}
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{5: true}}
+	m := Migrator{Url: "mock://", Id: "tenant1", VersionStore: store}
+	if version, err := m.VersionWith(d); err != nil || version != 5 {
+		t.Errorf("Expected the version of the version store, got %v, %v", version, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package migrationerror holds the error type drivers report for failed migrations.
+package migrationerror
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PlanitarInc/migrate/file"
+)
+
+// Category tells what kind of failure an Error is.
+type Category int
+
+const (
+	Unknown Category = iota
+
+	// the database could not be reached
+	Connection
+
+	// a statement of a migration file failed
+	SQL
+
+	// the migration lock could not be taken
+	Lock
+
+	// the run was interrupted, e.g. by ^C
+	Interrupt
+
+	// the migration didn't finish in time
+	Timeout
+
+	// a BeforeEach or AfterEach hook of the migrator failed
+	Hook
+)
+
+func (c Category) String() string {
+	switch c {
+	case Connection:
+		return "connection"
+	case SQL:
+		return "sql"
+	case Lock:
+		return "lock"
+	case Interrupt:
+		return "interrupt"
+	case Timeout:
+		return "timeout"
+	case Hook:
+		return "hook"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is an error that occurred while applying a migration file.
+type Error struct {
+	// the migration file that failed, nil if the error
+	// is not about a single file
+	File *file.File
+
+	// driver specific error code, e.g. the postgres SQLSTATE
+	Code string
+
+	// what kind of failure this is
+	Category Category
+
+	// the underlying error
+	Err error
+}
+
+// New returns an SQL Error for a given file.
+func New(f file.File, code string, err error) *Error {
+	return &Error{File: &f, Code: code, Category: SQL, Err: err}
+}
+
+// Wrap returns an Error of the given category that is not
+// about a single file. Errors are returned as is.
+func Wrap(category Category, err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Category: category, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Cancelled returns a Timeout (or, if cancelled otherwise, an Interrupt)
+// Error for f if ctx is done, nil otherwise. Drivers report it instead
+// of the error of the statement the context aborted.
+func Cancelled(ctx context.Context, f file.File) *Error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	category := Interrupt
+	if err == context.DeadlineExceeded {
+		category = Timeout
+	}
+	return &Error{File: &f, Category: category, Err: fmt.Errorf("%s was aborted: %v", f.FileName, err)}
+}
@@ -0,0 +1,150 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PlanitarInc/migrate/driver"
+	"github.com/PlanitarInc/migrate/file"
+)
+
+// SquashedName is the name of the migration file written by Squash.
+const SquashedName = "squashed"
+
+// Squash concatenates the up files of all migrations up to version upTo
+// into one up file, and their down files in reverse order into one down
+// file, and writes them to the directory out of the store. The squashed
+// migration has the highest version of the squashed ones, so databases
+// migrated past it already aren't affected. If one of them is
+// irreversible, so is the squashed migration.
+//
+// Squash doesn't connect to the database, the files are for review:
+// replace the squashed files by them once done. out can't be one of the
+// migration paths, where the squashed migration would duplicate the
+// version of the last squashed one. Files without content and files with
+// directives other than irreversible, which would no longer apply once
+// squashed, fail.
+func (m Migrator) Squash(upTo uint64, out string) (*file.MigrationFile, error) {
+	if out == "" {
+		return nil, errors.New("Squash needs a directory to write the squashed migration to.")
+	}
+	for _, p := range m.migrationPaths() {
+		if samePath(p, out) {
+			return nil, fmt.Errorf("Can't write the squashed migration to the migrations path %s, "+
+				"where its version would be a duplicate. Write it to another directory.", p)
+		}
+	}
+	url, err := m.driverUrl()
+	if err != nil {
+		return nil, err
+	}
+	d, err := driver.Lookup(url)
+	if err != nil {
+		return nil, err
+	}
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(files)
+
+	squashed := make(file.MigrationFiles, 0)
+	for _, mf := range files {
+		if mf.Version <= upTo {
+			squashed = append(squashed, mf)
+		}
+	}
+	if len(squashed) == 0 {
+		return nil, fmt.Errorf("There are no migrations up to version %v to squash.", upTo)
+	}
+
+	var up, down [][]byte
+	irreversible := false
+	for _, mf := range squashed {
+		if mf.UpFile == nil {
+			return nil, fmt.Errorf("Version %v has no up file.", mf.Version)
+		}
+		content, err := squashContent(mf.UpFile)
+		if err != nil {
+			return nil, err
+		}
+		up = append(up, content)
+		if mf.UpFile.Irreversible() {
+			irreversible = true
+			continue
+		}
+		if mf.DownFile == nil {
+			return nil, fmt.Errorf("Version %v has no down file.", mf.Version)
+		}
+		if content, err = squashContent(mf.DownFile); err != nil {
+			return nil, err
+		}
+		down = append([][]byte{content}, down...)
+	}
+
+	last := squashed[len(squashed)-1].Version
+	versionStr := strconv.FormatUint(last, 10)
+	if m.VersionFormat != VersionTimestamp {
+		if digits := m.versionDigits(files); len(versionStr) < digits {
+			versionStr = strings.Repeat("0", digits-len(versionStr)) + versionStr
+		}
+	}
+	upContent := bytes.Join(up, []byte("\n"))
+	if irreversible {
+		upContent = append([]byte(file.IrreversibleDirective+"\n"), upContent...)
+	}
+	mfile, err := m.newMigrationFile(d.FilenameExtension(), last, versionStr, SquashedName, upContent, bytes.Join(down, []byte("\n")))
+	if err != nil {
+		return nil, err
+	}
+	mfile.UpFile.Path, mfile.DownFile.Path = out, out
+	if irreversible {
+		mfile.DownFile = nil
+	}
+	if err := mfile.WriteToStore(m.Store); err != nil {
+		return nil, err
+	}
+	return mfile, nil
+}
+
+// samePath reports whether the paths a and b name the same directory.
+func samePath(a, b string) bool {
+	if absA, err := filepath.Abs(a); err == nil {
+		if absB, err := filepath.Abs(b); err == nil {
+			return absA == absB
+		}
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// squashContent returns the content of f ending in a newline, failing if
+// f is empty or has directives other than irreversible.
+func squashContent(f *file.File) ([]byte, error) {
+	if err := f.ReadContent(); err != nil {
+		return nil, err
+	}
+	content := f.Content
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, fmt.Errorf("%s has no content.", f.FileName)
+	}
+	directives := make([]string, 0)
+	for key := range f.Options {
+		if key != "irreversible" {
+			directives = append(directives, key)
+		}
+	}
+	if len(directives) > 0 {
+		sort.Strings(directives)
+		return nil, fmt.Errorf("%s has the directives %s, which don't apply once squashed. Squash the migrations before it.",
+			f.FileName, strings.Join(directives, ", "))
+	}
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(append([]byte{}, content...), '\n')
+	}
+	return content, nil
+}
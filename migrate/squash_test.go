@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSquash(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for name, content := range map[string]string{
+		"0001_a.up.sql":   "CREATE TABLE a ();",
+		"0001_a.down.sql": "DROP TABLE a;\n",
+		"0002_b.up.sql":   "CREATE TABLE b ();\n",
+		"0002_b.down.sql": "DROP TABLE b;",
+		"0003_c.up.sql":   "-- migrate:irreversible\nUPDATE b SET x = 1;\n",
+		"0004_d.up.sql":   "-- migrate:transaction false\nCREATE INDEX CONCURRENTLY d ON b (x);\n",
+		"0004_d.down.sql": "DROP INDEX d;\n",
+		"0005_e.up.sql":   "\n",
+		"0005_e.down.sql": "DROP TABLE e;\n",
+	} {
+		ioutil.WriteFile(path.Join(tmpdir, name), []byte(content), 0644)
+	}
+
+	out, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	m := Migrator{Url: "mock://", Paths: []string{tmpdir}}
+
+	// the squashed migration would duplicate version 2 in the migrations path
+	for _, dir := range []string{tmpdir, tmpdir + "/", path.Join(tmpdir, "x", ".."), ""} {
+		if _, err := m.Squash(2, dir); err == nil {
+			t.Errorf("Expected error for writing to %q", dir)
+		}
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "0002_squashed.up.sql")); err == nil {
+		t.Error("Expected nothing to be written to the migrations path")
+	}
+
+	mfile, err := m.Squash(2, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mfile.Version != 2 || mfile.UpFile.FileName != "0002_squashed.up.sql" || mfile.DownFile.FileName != "0002_squashed.down.sql" {
+		t.Errorf("Expected version 2 squashed files, got %+v", mfile)
+	}
+	if up, _ := ioutil.ReadFile(path.Join(out, "0002_squashed.up.sql")); string(up) != "CREATE TABLE a ();\n\nCREATE TABLE b ();\n" {
+		t.Errorf("Unexpected up file %q", up)
+	}
+	if down, _ := ioutil.ReadFile(path.Join(out, "0002_squashed.down.sql")); string(down) != "DROP TABLE b;\n\nDROP TABLE a;\n" {
+		t.Errorf("Unexpected down file %q", down)
+	}
+	os.Remove(path.Join(out, "0002_squashed.up.sql"))
+	os.Remove(path.Join(out, "0002_squashed.down.sql"))
+
+	// an irreversible migration makes the squashed one irreversible
+	if mfile, err = m.Squash(3, out); err != nil {
+		t.Fatal(err)
+	}
+	if mfile.DownFile != nil {
+		t.Errorf("Expected no down file, got %+v", mfile.DownFile)
+	}
+	if err := mfile.UpFile.ReadContent(); err != nil || !mfile.UpFile.Irreversible() {
+		t.Errorf("Expected the squashed migration to be irreversible, got %v", err)
+	}
+	os.Remove(path.Join(out, "0003_squashed.up.sql"))
+
+	if _, err := m.Squash(4, out); err == nil {
+		t.Error("Expected error for a file with a transaction directive")
+	}
+	m.Paths = []string{out}
+	if _, err := m.Squash(5, tmpdir); err == nil {
+		t.Error("Expected error without migrations to squash")
+	}
+	m.Paths = []string{tmpdir}
+	os.Remove(path.Join(tmpdir, "0004_d.up.sql"))
+	os.Remove(path.Join(tmpdir, "0004_d.down.sql"))
+	if _, err := m.Squash(5, out); err == nil {
+		t.Error("Expected error for a file without content")
+	}
+}
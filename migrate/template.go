@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/PlanitarInc/migrate/migrate/direction"
+)
+
+// TemplateData is passed to Migrator.CreateTemplate when
+// rendering the content of a new migration file.
+type TemplateData struct {
+	Version   uint64
+	Name      string
+	Direction string // "up" or "down"
+}
+
+// DefaultTemplateFuncs are the functions available in every create template:
+//
+// 	{{ now }}               the current time
+// 	{{ upper .Name }}       the name in upper case
+// 	{{ snakecase .Name }}   the name in snake_case
+//
+// Migrator.TemplateFuncs adds to (or overrides) these.
+var DefaultTemplateFuncs = template.FuncMap{
+	"now":       func() time.Time { return time.Now().Round(0) },
+	"upper":     strings.ToUpper,
+	"snakecase": snakecase,
+}
+
+// templateFuncs merges the default template functions with the
+// migrator's own.
+func (m Migrator) templateFuncs() template.FuncMap {
+	funcs := template.FuncMap{}
+	for name, fn := range DefaultTemplateFuncs {
+		funcs[name] = fn
+	}
+	for name, fn := range m.TemplateFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// renderTemplate renders the create template for a new migration file.
+// It returns empty content if no template is configured.
+func (m Migrator) renderTemplate(version uint64, name string, d direction.Direction) ([]byte, error) {
+	if m.CreateTemplate == "" {
+		return []byte(""), nil
+	}
+
+	tmpl, err := template.New("create").Funcs(m.templateFuncs()).Parse(m.CreateTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	data := TemplateData{Version: version, Name: name, Direction: d.String()}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// snakecase converts e.g. "AddUsers table" to "add_users_table".
+func snakecase(s string) string {
+	var buf bytes.Buffer
+	var prev rune
+	underscore := false
+	for _, r := range s {
+		switch {
+		case r == ' ' || r == '-' || r == '_':
+			underscore = buf.Len() > 0
+		case unicode.IsUpper(r):
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				underscore = buf.Len() > 0
+			}
+			fallthrough
+		default:
+			if underscore {
+				buf.WriteRune('_')
+				underscore = false
+			}
+			buf.WriteRune(unicode.ToLower(r))
+		}
+		prev = r
+	}
+	return buf.String()
+}
@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PlanitarInc/migrate/migrate/direction"
+)
+
+func TestSnakecase(t *testing.T) {
+	var tests = []struct {
+		name   string
+		expect string
+	}{
+		{"add_users", "add_users"},
+		{"AddUsers", "add_users"},
+		{"add users table", "add_users_table"},
+		{"add-users  table", "add_users_table"},
+		{"HTTPHeaders", "httpheaders"},
+		{"v2Users", "v2_users"},
+		{"_leading", "leading"},
+		{"trailing_", "trailing"},
+	}
+
+	for _, test := range tests {
+		if s := snakecase(test.name); s != test.expect {
+			t.Errorf("Expected snakecase(%q) to be %q, got %q", test.name, test.expect, s)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	m := Migrator{}
+	content, err := m.renderTemplate(1, "foo", direction.Up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != 0 {
+		t.Errorf("Expected empty content without template, got %q", content)
+	}
+
+	m.CreateTemplate = `-- {{ .Version }} {{ upper .Name }} {{ snakecase .Name }} {{ .Direction }} {{ team }} {{ now.Year }}`
+	m.TemplateFuncs = map[string]interface{}{
+		"team": func() string { return "core" },
+	}
+	content, err = m.renderTemplate(3, "AddUsers", direction.Down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "-- 3 ADDUSERS add_users down core ") {
+		t.Errorf("Unexpected template output %q", content)
+	}
+
+	m.CreateTemplate = `{{ .Unknown }}`
+	if _, err := m.renderTemplate(3, "foo", direction.Up); err == nil {
+		t.Error("Expected error for unknown template field")
+	}
+}
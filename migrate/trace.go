@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"github.com/PlanitarInc/migrate/driver"
+	"github.com/PlanitarInc/migrate/file"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+// Tracer starts a span per migration file. It is a minimal subset of
+// tracing APIs like OpenTelemetry's, so that the migrate package doesn't
+// depend on any of them; wrap your tracer to implement it.
+type Tracer interface {
+	// StartSpan starts a span with the given name and attributes.
+	StartSpan(name string, attributes map[string]interface{}) Span
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// RecordError records an error that occurred during the span.
+	RecordError(err error)
+
+	// End ends the span.
+	End()
+}
+
+// Span attributes set by the migrator
+const (
+	SpanAttributeVersion    = "migrate.version"
+	SpanAttributeDirection  = "migrate.direction"
+	SpanAttributeFile       = "migrate.file"
+	SpanAttributeStatements = "migrate.statements"
+)
+
+// trace starts a span for a migration file if the migrator has a tracer.
+// It returns a pipe forwarding everything sent down pipe, recording
+// the errors in the span, which ends once pipe is closed.
+func (m Migrator) trace(d driver.Driver, f file.File, pipe chan interface{}) chan interface{} {
+	if m.Tracer == nil {
+		return pipe
+	}
+
+	attributes := map[string]interface{}{
+		SpanAttributeVersion:   f.Version,
+		SpanAttributeDirection: f.Direction.String(),
+		SpanAttributeFile:      f.FileName,
+	}
+	if counter, ok := d.(driver.StatementCounter); ok {
+		if err := f.ReadContent(); err == nil {
+			attributes[SpanAttributeStatements] = counter.CountStatements(f.Content)
+		}
+	}
+	span := m.Tracer.StartSpan("migrate "+f.FileName, attributes)
+
+	traced := pipep.New()
+	go func() {
+		defer close(traced)
+		defer span.End()
+		for item := range pipe {
+			if err, ok := item.(error); ok {
+				span.RecordError(err)
+			}
+			traced <- item
+		}
+	}()
+	return traced
+}
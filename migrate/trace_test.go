@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// recordingTracer records the attributes of all spans
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	attributes map[string]interface{}
+	errors     []error
+	ended      bool
+}
+
+func (t *recordingTracer) StartSpan(name string, attributes map[string]interface{}) Span {
+	s := &recordingSpan{attributes: attributes}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.errors = append(s.errors, err)
+}
+
+func (s *recordingSpan) End() {
+	s.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_bar.up.sh"), nil, 0644)
+
+	tracer := &recordingTracer{}
+	m := Migrator{Url: "bash://", Path: tmpdir, Tracer: tracer}
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %v", len(tracer.spans))
+	}
+	for i, s := range tracer.spans {
+		if !s.ended {
+			t.Errorf("Expected span %v to be ended", i)
+		}
+		if s.attributes[SpanAttributeVersion] != uint64(i+1) || s.attributes[SpanAttributeDirection] != "up" {
+			t.Errorf("Unexpected attributes %v", s.attributes)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+)
+
+// Version formats for Migrator.VersionFormat
+const (
+	// VersionSequential numbers migrations 0001, 0002, ...
+	VersionSequential = "sequential"
+
+	// VersionTimestamp uses the creation time as version,
+	// formatted by Migrator.TimestampFormat.
+	VersionTimestamp = "timestamp"
+)
+
+// DefaultVersionDigits is the width sequential versions are zero-padded
+// to if neither Migrator.VersionDigits nor existing files tell.
+const DefaultVersionDigits = 4
+
+// versionDigits returns VersionDigits, or else the number of leading
+// digits of the widest existing filename.
+func (m Migrator) versionDigits(files file.MigrationFiles) int {
+	if m.VersionDigits > 0 {
+		return m.VersionDigits
+	}
+	digits := 0
+	for _, mf := range files {
+		for _, f := range []*file.File{mf.UpFile, mf.DownFile} {
+			if f == nil {
+				continue
+			}
+			n := strings.IndexFunc(f.FileName, func(r rune) bool { return r < '0' || r > '9' })
+			if n > digits {
+				digits = n
+			}
+		}
+	}
+	if digits == 0 {
+		return DefaultVersionDigits
+	}
+	return digits
+}
+
+// DefaultTimestampFormat is the time layout of timestamp versions
+// unless Migrator.TimestampFormat is set.
+const DefaultTimestampFormat = "20060102150405"
+
+// timestampElements are the elements a timestamp format consists of,
+// from most to least significant. Formats must use a prefix of them in
+// this order, so versions sort like the times they were created at.
+var timestampElements = []string{"2006", "01", "02", "15", "04", "05"}
+
+// timestampSeparators may separate the elements of a timestamp format.
+const timestampSeparators = "_-"
+
+// timestampFormat returns the configured timestamp format and the
+// regular expression its versions match.
+func (m Migrator) timestampFormat() (string, string, error) {
+	layout := m.TimestampFormat
+	if layout == "" {
+		layout = DefaultTimestampFormat
+	}
+	pattern, err := timestampPattern(layout)
+	if err != nil {
+		return "", "", err
+	}
+	return layout, pattern, nil
+}
+
+// timestampPattern validates a timestamp format and returns the regular
+// expression matching the versions it produces. Formats must start with
+// year, month and day and may continue with hour, minute and second,
+// optionally separated by _ or -. Anything else (month names, 12-hour
+// clocks, time zones, ...) would not produce monotonic, sortable versions.
+func timestampPattern(layout string) (string, error) {
+	pattern := ""
+	rest := layout
+	for i, element := range timestampElements {
+		if rest == "" && i >= 3 {
+			break
+		}
+		if i > 0 && rest != "" && strings.IndexByte(timestampSeparators, rest[0]) >= 0 {
+			pattern += regexp.QuoteMeta(rest[:1])
+			rest = rest[1:]
+		}
+		if !strings.HasPrefix(rest, element) {
+			return "", fmt.Errorf("Invalid timestamp format '%s': expected %s at '%s'. "+
+				"Formats must be year, month, day and optionally hour, minute, second, like %s.",
+				layout, element, rest, DefaultTimestampFormat)
+		}
+		pattern += fmt.Sprintf(`\d{%d}`, len(element))
+		rest = rest[len(element):]
+	}
+	if rest != "" {
+		return "", fmt.Errorf("Invalid timestamp format '%s': unexpected '%s'.", layout, rest)
+	}
+	return pattern, nil
+}
+
+// timestampVersion returns the version for t in the given format
+// and its string representation used in filenames.
+func timestampVersion(t time.Time, layout string) (uint64, string, error) {
+	versionStr := t.UTC().Format(layout)
+	version, err := strconv.ParseUint(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, versionStr), 10, 64)
+	return version, versionStr, err
+}
+
+// filenameRegex returns the regular expression migration filenames
+// with a given extension have to match.
+func (m Migrator) filenameRegex(filenameExtension string) (*regexp.Regexp, error) {
+	separator, err := m.nameSeparator()
+	if err != nil {
+		return nil, err
+	}
+	pattern := ""
+	if m.TimestampFormat != "" {
+		if _, pattern, err = m.timestampFormat(); err != nil {
+			return nil, err
+		}
+	}
+	if m.Layout == file.CombinedLayout {
+		return file.CombinedFilenameRegex(filenameExtension, pattern, separator), nil
+	}
+	return file.FilenameRegexWithSeparator(filenameExtension, pattern, separator), nil
+}
+
+// readOptions returns how migration files are read.
+func (m Migrator) readOptions() file.ReadOptions {
+	return file.ReadOptions{Layout: m.Layout}
+}
+
+// nameSeparator returns NameSeparator, or file.DefaultNameSeparator if
+// it is empty. Separators can't contain digits, dots or slashes, which
+// would make filenames ambiguous.
+func (m Migrator) nameSeparator() (string, error) {
+	if m.NameSeparator == "" {
+		return file.DefaultNameSeparator, nil
+	}
+	if strings.ContainsAny(m.NameSeparator, "0123456789./") {
+		return "", fmt.Errorf("Invalid name separator '%s', it can't contain digits, dots or slashes.", m.NameSeparator)
+	}
+	return m.NameSeparator, nil
+}
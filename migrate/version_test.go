@@ -0,0 +1,222 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+)
+
+func TestTimestampPattern(t *testing.T) {
+	var tests = []struct {
+		layout      string
+		expectError bool
+	}{
+		{"20060102150405", false},
+		{"2006_01_02_150405", false},
+		{"2006-01-02-15-04-05", false},
+		{"20060102", false},
+		{"200601021504", false},
+		{"", true},
+		{"2006", true},
+		{"02012006", true},
+		{"2006Jan02", true},
+		{"20060102030405", true},
+		{"20060102150405MST", true},
+		{"2006__01_02", true},
+	}
+
+	for _, test := range tests {
+		_, err := timestampPattern(test.layout)
+		if (err != nil) != test.expectError {
+			t.Errorf("Expected error for %q: %v, got %v", test.layout, test.expectError, err)
+		}
+	}
+}
+
+func TestCreateTimestamp(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), nil, 0644)
+
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionFormat: VersionTimestamp, TimestampFormat: "2006_01_02_150405"}
+	mf, err := m.Create("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := time.Now().UTC().Format("2006_01_02_")
+	if !strings.HasPrefix(mf.UpFile.FileName, prefix) {
+		t.Errorf("Expected filename to start with %v, got %v", prefix, mf.UpFile.FileName)
+	}
+
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1].Version != mf.Version {
+		t.Fatalf("Expected versions 1 and %v, got %v", mf.Version, files)
+	}
+
+	m.TimestampFormat = "Jan 2006"
+	if _, err := m.Create("baz"); err == nil {
+		t.Error("Expected error for invalid timestamp format")
+	}
+}
+
+func TestCreateNameSeparator(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001-foo.up.sh"), nil, 0644)
+
+	m := Migrator{Url: "bash://", Path: tmpdir, NameSeparator: "-"}
+	mf, err := m.Create("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.UpFile.FileName != "0002-bar.up.sh" {
+		t.Errorf("Expected 0002-bar.up.sh, got %v", mf.UpFile.FileName)
+	}
+
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].UpFile.Name != "foo" || files[1].UpFile.Name != "bar" {
+		t.Fatalf("Expected foo and bar, got %v", files)
+	}
+
+	m.NameSeparator = "1"
+	if _, err := m.Create("baz"); err == nil {
+		t.Error("Expected error for a separator of digits")
+	}
+}
+
+func TestCreateVersionDigits(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m := Migrator{Url: "bash://", Path: tmpdir}
+	mf, err := m.Create("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.UpFile.FileName != "0001_foo.up.sh" {
+		t.Errorf("Expected 0001_foo.up.sh without existing files, got %v", mf.UpFile.FileName)
+	}
+	os.Remove(path.Join(tmpdir, "0001_foo.up.sh"))
+	os.Remove(path.Join(tmpdir, "0001_foo.down.sh"))
+
+	ioutil.WriteFile(path.Join(tmpdir, "000001_foo.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "000002_bar.up.sh"), nil, 0644)
+	if mf, err = m.Create("baz"); err != nil {
+		t.Fatal(err)
+	}
+	if mf.UpFile.FileName != "000003_baz.up.sh" {
+		t.Errorf("Expected 000003_baz.up.sh as wide as the existing files, got %v", mf.UpFile.FileName)
+	}
+
+	m.VersionDigits = 3
+	if mf, err = m.Create("qux"); err != nil {
+		t.Fatal(err)
+	}
+	if mf.UpFile.FileName != "004_qux.up.sh" {
+		t.Errorf("Expected 004_qux.up.sh, got %v", mf.UpFile.FileName)
+	}
+}
+
+// memStore is an in-memory file.WritableStore
+type memStore map[string][]byte
+
+func (s memStore) ReadFile(f *file.File) ([]byte, error) {
+	content, ok := s[path.Join(f.Path, f.FileName)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return content, nil
+}
+
+func (s memStore) ReadDir(dirname string) ([]string, error) {
+	names := make([]string, 0)
+	for p := range s {
+		if path.Dir(p) == dirname {
+			names = append(names, path.Base(p))
+		}
+	}
+	return names, nil
+}
+
+func (s memStore) Write(p string, content []byte) error {
+	s[p] = content
+	return nil
+}
+
+func TestCreateInStore(t *testing.T) {
+	store := memStore{}
+	m := Migrator{Url: "bash://", Path: "/migrations", Store: store, CreateTemplate: "# {{.Name}} {{.Direction}}\n"}
+	for _, name := range []string{"foo", "bar"} {
+		if _, err := m.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store) != 4 {
+		t.Errorf("Expected 4 files in the store, got %v", len(store))
+	}
+
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].UpFile.Name != "foo" || files[1].DownFile.Name != "bar" {
+		t.Fatalf("Expected foo and bar, got %v", files)
+	}
+	if err := files[1].DownFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(files[1].DownFile.Content) != "# bar down\n" {
+		t.Errorf("Unexpected content %q", files[1].DownFile.Content)
+	}
+}
+
+func TestCreateCombined(t *testing.T) {
+	store := memStore{}
+	m := Migrator{Url: "bash://", Path: "/migrations", Store: store, Layout: file.CombinedLayout, CreateTemplate: "# {{.Name}} {{.Direction}}\n"}
+	if _, err := m.Create("foo"); err != nil {
+		t.Fatal(err)
+	}
+	content, ok := store["/migrations/0001_foo.sh"]
+	if len(store) != 1 || !ok {
+		t.Fatalf("Expected 0001_foo.sh in the store, got %v", store)
+	}
+	if string(content) != "-- +migrate Up\n# foo up\n\n-- +migrate Down\n# foo down\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !files[0].UpFile.Combined || files[0].DownFile.FileName != "0001_foo.sh" {
+		t.Fatalf("Expected the combined file foo, got %v", files)
+	}
+	if err := files[0].DownFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(files[0].DownFile.Content), "\n# foo down\n") || strings.Contains(string(files[0].DownFile.Content), "foo up") {
+		t.Errorf("Expected only the down section, got %q", files[0].DownFile.Content)
+	}
+}
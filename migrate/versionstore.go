@@ -0,0 +1,307 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PlanitarInc/migrate/driver"
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+	"github.com/PlanitarInc/migrate/migrate/migrationerror"
+	pipep "github.com/PlanitarInc/migrate/pipe"
+)
+
+// VersionStore keeps track of applied migrations separately from the
+// database migrations run against, e.g. in a central metadata database.
+//
+// If Migrator.VersionStore is set, the driver only executes the migration
+// files (it has to implement driver.Executor) and all bookkeeping goes
+// through the version store. Otherwise the driver keeps track of
+// versions itself.
+type VersionStore interface {
+	// GetVersion returns the current version.
+	GetVersion(id string) (uint64, error)
+
+	// SetVersion records that the migration of a version was
+	// applied (direction.Up) or rolled back (direction.Down).
+	SetVersion(id string, version uint64, d direction.Direction) error
+
+	// ListVersions returns all applied versions in ascending order.
+	ListVersions(id string) ([]uint64, error)
+
+	// Lock blocks until no other migrator holds the lock for id.
+	Lock(id string) error
+
+	// Unlock releases the lock taken by Lock.
+	Unlock(id string) error
+}
+
+// currentVersion returns the current version from the version store
+// if there is one, from the driver otherwise.
+func (m Migrator) currentVersion(d driver.Driver) (uint64, error) {
+	if m.VersionStore != nil {
+		return m.VersionStore.GetVersion(m.Id)
+	}
+	return d.Version(m.Id)
+}
+
+// appliedVersions returns the applied versions from the version store
+// if there is one, from the driver if it lists them, or just the current
+// version otherwise.
+func (m Migrator) appliedVersions(d driver.Driver, version uint64) ([]uint64, error) {
+	if m.VersionStore != nil {
+		return m.VersionStore.ListVersions(m.Id)
+	}
+	if lister, ok := d.(driver.VersionLister); ok {
+		return lister.ListVersions(m.Id)
+	}
+	if version == 0 {
+		return nil, nil
+	}
+	return []uint64{version}, nil
+}
+
+// lockVersionStore takes the lock of the version store, which gives up
+// once RunContext is done if it implements driver.ContextSetter.
+func (m Migrator) lockVersionStore() error {
+	if setter, ok := m.VersionStore.(driver.ContextSetter); ok {
+		setter.SetContext(m.RunContext)
+	}
+	return m.VersionStore.Lock(m.Id)
+}
+
+// checkDownFiles fails if an applied version that rolling back from
+// version to to would undo has no down file, e.g. because the file was
+// deleted after it was applied. It would be skipped silently otherwise.
+func (m Migrator) checkDownFiles(d driver.Driver, files *file.MigrationFiles, version, to uint64) error {
+	applied, err := m.appliedVersions(d, version)
+	if err != nil {
+		return err
+	}
+	downFiles := make(map[uint64]bool)
+	for _, f := range *files {
+		if f.DownFile != nil {
+			downFiles[f.Version] = true
+		}
+	}
+	missing := make([]string, 0)
+	for _, v := range applied {
+		if v > to && v <= version && !downFiles[v] {
+			missing = append(missing, strconv.FormatUint(v, 10))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Applied versions %s have no down migration file, refusing to roll back.", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// unappliedFiles drops the files another migrator applied (or rolled
+// back) while waiting for the lock.
+func (m Migrator) unappliedFiles(d driver.Driver, files file.Files) (file.Files, error) {
+	version, err := m.currentVersion(d)
+	if err != nil {
+		return nil, err
+	}
+	unapplied := make(file.Files, 0, len(files))
+	for _, f := range files {
+		if (f.Direction == direction.Up && f.Version > version) ||
+			(f.Direction == direction.Down && f.Version <= version) {
+			unapplied = append(unapplied, f)
+		}
+	}
+	return unapplied, nil
+}
+
+// Progress is sent down the pipe before each migration file is
+// applied: it is the Current one of Total files.
+type Progress struct {
+	Current int
+	Total   int
+}
+
+// migrateFiles applies files one after another. It stops after the
+// first failed migration or once an interrupt is received.
+func (m Migrator) migrateFiles(d driver.Driver, files file.Files, pipe chan interface{}) {
+	if err := checkMultiStatement(d, files); err != nil {
+		m.send(pipe, err)
+		return
+	}
+	if m.VersionStore == nil {
+		if locker, ok := d.(driver.Locker); ok {
+			if err := locker.Lock(m.Id); err != nil {
+				m.send(pipe, migrationerror.Wrap(migrationerror.Lock, err))
+				return
+			}
+			defer func() {
+				if err := locker.Unlock(m.Id); err != nil {
+					m.send(pipe, err)
+				}
+			}()
+			var err error
+			if files, err = m.unappliedFiles(d, files); err != nil {
+				m.send(pipe, err)
+				return
+			}
+		}
+		if batcher, ok := d.(driver.BatchMigrator); ok && m.AllInOneTx && !m.DryRun {
+			for _, f := range files {
+				if err := m.beforeEach(f); err != nil {
+					m.send(pipe, err)
+					return
+				}
+			}
+			pipe1 := pipep.New()
+			go batcher.MigrateBatch(m.Id, files, pipe1)
+			// the batch can't be stopped halfway, interrupts are only reported
+			if errorReceived, _ := pipep.WaitAndRedirectStatusContext(m.context(), m.logged(batchProgress(pipe1, len(files))), pipe, handleInterrupts()); errorReceived {
+				return
+			}
+			for _, f := range files {
+				if err := m.afterEach(f); err != nil {
+					m.send(pipe, err)
+					return
+				}
+			}
+			return
+		}
+		for i, f := range files {
+			if i > 0 && interruptPending(handleInterrupts()) {
+				m.sendInterrupted(pipe, files[i-1], files[i:])
+				break
+			}
+			m.send(pipe, Progress{Current: i + 1, Total: len(files)})
+			if err := m.beforeEach(f); err != nil {
+				m.send(pipe, err)
+				break
+			}
+			pipe1 := pipep.New()
+			go d.Migrate(m.Id, f, pipe1)
+			errorReceived, interrupted := pipep.WaitAndRedirectStatusContext(m.context(), m.logged(m.trace(d, f, pipe1)), pipe, handleInterrupts())
+			if errorReceived {
+				break
+			}
+			if err := m.afterEach(f); err != nil {
+				m.send(pipe, err)
+				break
+			}
+			if interrupted {
+				m.sendInterrupted(pipe, f, files[i+1:])
+				break
+			}
+		}
+		return
+	}
+
+	executor, ok := d.(driver.Executor)
+	if !ok {
+		m.send(pipe, errors.New("Driver can't run migrations without recording their version, which a version store requires."))
+		return
+	}
+
+	if err := m.lockVersionStore(); err != nil {
+		m.send(pipe, migrationerror.Wrap(migrationerror.Lock, err))
+		return
+	}
+	defer func() {
+		if err := m.VersionStore.Unlock(m.Id); err != nil {
+			m.send(pipe, err)
+		}
+	}()
+	files, err := m.unappliedFiles(d, files)
+	if err != nil {
+		m.send(pipe, err)
+		return
+	}
+
+	for i, f := range files {
+		if i > 0 && interruptPending(handleInterrupts()) {
+			m.sendInterrupted(pipe, files[i-1], files[i:])
+			break
+		}
+		m.send(pipe, Progress{Current: i + 1, Total: len(files)})
+		if err := m.beforeEach(f); err != nil {
+			m.send(pipe, err)
+			break
+		}
+		pipe1 := pipep.New()
+		go executor.Execute(f, pipe1)
+		errorReceived, interrupted := pipep.WaitAndRedirectStatusContext(m.context(), m.logged(m.trace(d, f, pipe1)), pipe, handleInterrupts())
+		if errorReceived {
+			break
+		}
+		if m.DryRun {
+			continue
+		}
+		// the file was applied even if an interrupt was received meanwhile
+		if err := m.VersionStore.SetVersion(m.Id, f.Version, f.Direction); err != nil {
+			m.send(pipe, err)
+			break
+		}
+		if err := m.afterEach(f); err != nil {
+			m.send(pipe, err)
+			break
+		}
+		if interrupted {
+			m.sendInterrupted(pipe, f, files[i+1:])
+			break
+		}
+	}
+}
+
+// batchProgress forwards everything sent down pipe by a batch of total
+// files, preceding every file with its Progress.
+func batchProgress(pipe chan interface{}, total int) chan interface{} {
+	progress := pipep.New()
+	go func() {
+		defer close(progress)
+		current := 0
+		for item := range pipe {
+			if _, ok := item.(file.File); ok {
+				current += 1
+				progress <- Progress{Current: current, Total: total}
+			}
+			progress <- item
+		}
+	}()
+	return progress
+}
+
+// Interrupted is the error of a run stopped by an interrupt, sent down
+// the pipe wrapped in a MigrationError of category InterruptError.
+type Interrupted struct {
+	// the last migration file that was applied
+	Applied file.File
+
+	// the next migration file, which wasn't started, nil if none was left
+	Skipped *file.File
+
+	// the number of migration files that weren't applied, including Skipped
+	Remaining int
+}
+
+func (e *Interrupted) Error() string {
+	switch {
+	case e.Skipped == nil:
+		return fmt.Sprintf("Interrupted after %s, which was the last migration.", e.Applied.FileName)
+	case e.Remaining > 1:
+		return fmt.Sprintf("Interrupted after %s, skipped %s and %d more migrations.", e.Applied.FileName, e.Skipped.FileName, e.Remaining-1)
+	}
+	return fmt.Sprintf("Interrupted after %s, skipped %s.", e.Applied.FileName, e.Skipped.FileName)
+}
+
+// sendInterrupted reports that the run stopped after f because of an
+// interrupt, skipping remaining, unless the migrator's context was
+// cancelled
+func (m Migrator) sendInterrupted(pipe chan interface{}, f file.File, remaining file.Files) {
+	if m.context().Err() != nil {
+		return
+	}
+	interrupted := &Interrupted{Applied: f, Remaining: len(remaining)}
+	if len(remaining) > 0 {
+		interrupted.Skipped = &remaining[0]
+	}
+	m.send(pipe, &MigrationError{File: &f, Category: migrationerror.Interrupt, Err: interrupted})
+}
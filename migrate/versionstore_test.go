@@ -0,0 +1,590 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlanitarInc/migrate/file"
+	"github.com/PlanitarInc/migrate/migrate/direction"
+)
+
+// memVersionStore is an in-memory VersionStore
+type memVersionStore struct {
+	versions map[uint64]bool
+	locks    int
+	sets     int
+
+	// called while taking the lock, e.g. to simulate another migrator
+	onLock func()
+
+	// returned by Lock if set
+	lockErr error
+}
+
+func (s *memVersionStore) GetVersion(id string) (uint64, error) {
+	versions, _ := s.ListVersions(id)
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (s *memVersionStore) SetVersion(id string, version uint64, d direction.Direction) error {
+	s.sets += 1
+	if d == direction.Up {
+		s.versions[version] = true
+	} else {
+		delete(s.versions, version)
+	}
+	return nil
+}
+
+func (s *memVersionStore) ListVersions(id string) ([]uint64, error) {
+	versions := make([]uint64, 0)
+	for version := range s.versions {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+func (s *memVersionStore) Lock(id string) error {
+	if s.lockErr != nil {
+		return s.lockErr
+	}
+	s.locks += 1
+	if s.onLock != nil {
+		s.onLock()
+	}
+	return nil
+}
+
+func (s *memVersionStore) Unlock(id string) error {
+	s.locks -= 1
+	return nil
+}
+
+func TestVersionStore(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.down.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_bar.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_bar.down.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	errs, ok := m.UpSync()
+	if !ok {
+		t.Fatal(errs)
+	}
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("Expected version 2, got %v", version)
+	}
+
+	errs, ok = m.MigrateSync(-1)
+	if !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 1 {
+		t.Fatalf("Expected version 1, got %v", version)
+	}
+	if store.locks != 0 {
+		t.Errorf("Expected version store to be unlocked, got %v locks", store.locks)
+	}
+}
+
+func TestVersionStoreAppliedWhileWaitingForLock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0002_bar.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0003_baz.up.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	store.onLock = func() {
+		// another migrator applied the first two versions meanwhile
+		store.versions[1] = true
+		store.versions[2] = true
+	}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	errs, ok := m.UpSync()
+	if !ok {
+		t.Fatal(errs)
+	}
+	if store.sets != 1 {
+		t.Errorf("Expected only version 3 to be applied, got %v applied", store.sets)
+	}
+	if version, _ := m.Version(); version != 3 {
+		t.Errorf("Expected version 3, got %v", version)
+	}
+}
+
+func TestGotoAcrossGaps(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a", "0005_b", "0010_c"} {
+		ioutil.WriteFile(path.Join(tmpdir, name+".up.sh"), nil, 0644)
+		ioutil.WriteFile(path.Join(tmpdir, name+".down.sh"), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	if errs, ok := m.GotoSync(7); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 5 {
+		t.Errorf("Expected version 5, got %v", version)
+	}
+	if errs, ok := m.GotoSync(1); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 1 {
+		t.Errorf("Expected version 1, got %v", version)
+	}
+}
+
+func TestForce(t *testing.T) {
+	store := &memVersionStore{versions: map[uint64]bool{1: true, 2: true, 3: true}}
+	m := Migrator{Url: "bash://", VersionStore: store}
+
+	if err := m.Force(2); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := store.GetVersion(""); version != 2 || store.versions[3] {
+		t.Errorf("Expected version 2 with 3 forgotten, got %v", store.versions)
+	}
+	if err := m.Force(5); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := store.GetVersion(""); version != 5 {
+		t.Errorf("Expected version 5, got %v", version)
+	}
+	if err := m.Force(0); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.versions) != 0 {
+		t.Errorf("Expected no versions, got %v", store.versions)
+	}
+	if store.locks != 0 {
+		t.Errorf("Expected version store to be unlocked, got %v locks", store.locks)
+	}
+
+	if err := (Migrator{Url: "bash://"}).Force(1); err == nil {
+		t.Error("Expected error for a driver that can't force versions")
+	}
+}
+
+// historyVersionStore is a memVersionStore that also records when
+// versions were applied.
+type historyVersionStore struct {
+	memVersionStore
+	applied []AppliedMigration
+}
+
+func (s *historyVersionStore) History(id string) ([]AppliedMigration, error) {
+	return s.applied, nil
+}
+
+func TestHistory(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := &historyVersionStore{applied: []AppliedMigration{{Version: 1}, {Version: 2, AppliedAt: at}}}
+	applied, err := Migrator{Url: "bash://", VersionStore: store}.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[1].Version != 2 || !applied[1].AppliedAt.Equal(at) {
+		t.Errorf("Unexpected history %v", applied)
+	}
+
+	if _, err := (Migrator{Url: "bash://", VersionStore: &memVersionStore{}}).History(); err == nil {
+		t.Error("Expected error for a version store without history")
+	}
+	if _, err := (Migrator{Url: "bash://"}).History(); err == nil {
+		t.Error("Expected error for a driver without history")
+	}
+}
+
+func TestLockErrorCategory(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.down.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{}, lockErr: errors.New("locked")}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	merr, isMigrationError := errs[0].(*MigrationError)
+	if !isMigrationError || merr.Category != LockError || merr.Err != store.lockErr {
+		t.Errorf("Expected a lock MigrationError, got %#v", errs[0])
+	}
+}
+
+// contextVersionStore is a memVersionStore that records the context
+// it locks with.
+type contextVersionStore struct {
+	memVersionStore
+	ctx, lockCtx context.Context
+}
+
+func (s *contextVersionStore) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *contextVersionStore) Lock(id string) error {
+	s.lockCtx = s.ctx
+	return s.memVersionStore.Lock(id)
+}
+
+func TestVersionStoreRunContext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, "0001_foo.down.sh"), nil, 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &contextVersionStore{memVersionStore: memVersionStore{versions: map[uint64]bool{}}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store, RunContext: ctx}
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if store.lockCtx != ctx {
+		t.Errorf("Expected the version store to lock with the run context, got %v", store.lockCtx)
+	}
+
+	m.RunContext = nil
+	if err := m.Force(1); err != nil {
+		t.Fatal(err)
+	}
+	if store.lockCtx != nil {
+		t.Errorf("Expected the context of the previous call to be reset, got %v", store.lockCtx)
+	}
+}
+
+func TestDownN(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a", "0002_b", "0003_c"} {
+		ioutil.WriteFile(path.Join(tmpdir, name+".up.sh"), nil, 0644)
+		ioutil.WriteFile(path.Join(tmpdir, name+".down.sh"), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	if errs, ok := m.DownNSync(2); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 1 {
+		t.Errorf("Expected version 1, got %v", version)
+	}
+
+	// more than applied rolls back everything
+	if errs, ok := m.DownNSync(5); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 0 {
+		t.Errorf("Expected version 0, got %v", version)
+	}
+
+	if _, ok := m.DownNSync(0); ok {
+		t.Error("Expected error for n = 0")
+	}
+}
+
+func TestRedoN(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+	for _, name := range []string{"migration1", "migration2", "migration3"} {
+		if _, err := m.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if errs, ok := m.UpSync(); !ok {
+		t.Fatal(errs)
+	}
+	store.sets = 0
+	if errs, ok := m.RedoNSync(2); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 3 {
+		t.Errorf("Expected version 3, got %v", version)
+	}
+	if store.sets != 4 {
+		t.Errorf("Expected 2 versions rolled back and reapplied, got %v changes", store.sets)
+	}
+
+	if _, ok := m.RedoNSync(0); ok {
+		t.Error("Expected error for n = 0")
+	}
+}
+
+func TestDownMissingFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// version 2 was applied, then its files were deleted
+	for _, name := range []string{"0001_a", "0003_c"} {
+		ioutil.WriteFile(path.Join(tmpdir, name+".up.sh"), nil, 0644)
+		ioutil.WriteFile(path.Join(tmpdir, name+".down.sh"), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{1: true, 2: true, 3: true}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	if _, ok := m.DownSync(); ok {
+		t.Error("Expected down to fail because of the missing down file of version 2")
+	}
+	if _, ok := m.MigrateSync(-2); ok {
+		t.Error("Expected migrate -2 to fail because of the missing down file of version 2")
+	}
+	if len(store.versions) != 3 {
+		t.Errorf("Expected nothing to be rolled back, got %v", store.versions)
+	}
+
+	// rolling back versions above 2 is fine
+	if errs, ok := m.MigrateSync(-1); !ok {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(); version != 2 {
+		t.Errorf("Expected version 2, got %v", version)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sh", "0002_b.up.sh", "0003_c.up.sh"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	pipe := NewPipe()
+	go m.Up(pipe)
+	progress := make([]Progress, 0)
+	for item := range pipe {
+		if p, ok := item.(Progress); ok {
+			progress = append(progress, p)
+		}
+	}
+	if len(progress) != 3 || progress[0] != (Progress{1, 3}) || progress[2] != (Progress{3, 3}) {
+		t.Errorf("Expected progress 1/3 to 3/3, got %v", progress)
+	}
+}
+
+func TestStrictSequence(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sh", "0002_b.up.sh", "0004_d.up.sh"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store, StrictSequence: true}
+	if _, ok := m.UpSync(); ok {
+		t.Error("Expected up to fail because version 3 is missing")
+	}
+	if len(store.versions) != 0 {
+		t.Errorf("Expected nothing to be applied, got %v", store.versions)
+	}
+
+	m.VersionFormat = VersionTimestamp
+	if errs, ok := m.UpSync(); !ok {
+		t.Errorf("Expected timestamp versions to be exempt, got %v", errs)
+	}
+}
+
+func TestAhead(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_a.up.sh", "0002_b.up.sh"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{1: true, 2: true, 3: true}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+	errs, ok := m.UpSync()
+	if ok || len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if msg := errs[0].Error(); !strings.Contains(msg, "version 3") || !strings.Contains(msg, "version 2") {
+		t.Errorf("Expected error to name versions 3 and 2, got %q", msg)
+	}
+
+	m.AllowAhead = true
+	if errs, ok := m.UpSync(); !ok {
+		t.Errorf("Expected up to succeed with AllowAhead, got %v", errs)
+	}
+}
+
+func TestPipeFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_add_users.up.sh", "0002_add_posts.up.sh"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	pipe := NewPipe()
+	go m.Up(pipe)
+	files := make([]file.File, 0)
+	for item := range pipe {
+		if f, ok := item.(file.File); ok {
+			files = append(files, f)
+		}
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %v", len(files))
+	}
+	if f := files[1]; f.Version != 2 || f.Name != "add_posts" || f.Direction != direction.Up {
+		t.Errorf("Expected version 2, name add_posts, direction up, got %v, %v, %v", f.Version, f.Name, f.Direction)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"0001_foo.up.sh", "0002_bar.up.sh", "0004_baz.up.sh"} {
+		ioutil.WriteFile(path.Join(tmpdir, name), nil, 0644)
+	}
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Path: tmpdir, VersionStore: store}
+
+	if err := m.Baseline(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.versions) != 2 || !store.versions[1] || !store.versions[2] {
+		t.Errorf("Expected versions 1 and 2, got %v", store.versions)
+	}
+	if err := m.Baseline(4); err == nil {
+		t.Error("Expected error for a baseline over applied versions")
+	}
+	if store.versions[4] {
+		t.Errorf("Expected version 4 not to be applied, got %v", store.versions)
+	}
+	if store.locks != 0 {
+		t.Errorf("Expected version store to be unlocked, got %v locks", store.locks)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	users, billing := path.Join(tmpdir, "users"), path.Join(tmpdir, "billing")
+	os.Mkdir(users, 0755)
+	os.Mkdir(billing, 0755)
+	ioutil.WriteFile(path.Join(users, "0001_users.up.sh"), nil, 0644)
+	ioutil.WriteFile(path.Join(billing, "0002_invoices.up.sh"), nil, 0644)
+
+	store := &memVersionStore{versions: map[uint64]bool{}}
+	m := Migrator{Url: "bash://", Paths: []string{users, billing}, VersionStore: store}
+
+	pipe := NewPipe()
+	go m.Up(pipe)
+	applied := make([]string, 0)
+	for item := range pipe {
+		switch item := item.(type) {
+		case file.File:
+			applied = append(applied, path.Join(item.Path, item.FileName))
+		case error:
+			t.Fatal(item)
+		}
+	}
+	expected := []string{path.Join(users, "0001_users.up.sh"), path.Join(billing, "0002_invoices.up.sh")}
+	if strings.Join(applied, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v to be applied, got %v", expected, applied)
+	}
+
+	mf, err := m.Create("payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.Version != 3 || mf.UpFile.Path != users {
+		t.Errorf("Expected version 3 in %s, got %v in %s", users, mf.Version, mf.UpFile.Path)
+	}
+}
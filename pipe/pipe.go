@@ -2,15 +2,32 @@
 package pipe
 
 import (
+	"context"
 	"os"
 )
 
-// New creates a new pipe. A pipe is basically a channel.
+// BufferSize is the number of messages a pipe holds while nobody is
+// reading it, so producers sending a few more messages after the consumer
+// stopped reading, like the error closing the pipe, don't block.
+const BufferSize = 64
+
+// New creates a new pipe. A pipe is basically a buffered channel.
 func New() chan interface{} {
-	return make(chan interface{}, 0)
+	return make(chan interface{}, BufferSize)
+}
+
+// Abandon discards everything still sent down pipe until it is closed.
+// Consumers that stop reading early, e.g. on the first error, call it so
+// that the producer doesn't block once the buffer is full.
+func Abandon(pipe chan interface{}) {
+	go func() {
+		for range pipe {
+		}
+	}()
 }
 
-// Close closes a pipe and optionally sends an error
+// Close closes a pipe and optionally sends an error. It doesn't block
+// while the buffer of the pipe has room, even if nobody is reading.
 func Close(pipe chan interface{}, err error) {
 	if err != nil {
 		pipe <- err
@@ -18,12 +35,44 @@ func Close(pipe chan interface{}, err error) {
 	close(pipe)
 }
 
+// Send sends item down pipe unless ctx is done first, e.g. because the
+// consumer stopped reading, and reports whether it was sent.
+func Send(ctx context.Context, pipe chan interface{}, item interface{}) bool {
+	select {
+	case pipe <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// CloseContext is like Close, but drops err if ctx is done first.
+func CloseContext(ctx context.Context, pipe chan interface{}, err error) {
+	if err != nil {
+		Send(ctx, pipe, err)
+	}
+	close(pipe)
+}
+
 // WaitAndRedirect waits for pipe to be closed and
 // redirects all messages from pipe to redirectPipe
 // while it waits. It also checks if there was an
 // interrupt send and will quit gracefully if yes.
 func WaitAndRedirect(pipe, redirectPipe chan interface{}, interrupt chan os.Signal) (ok bool) {
-	errorReceived := false
+	errorReceived, interrupted := WaitAndRedirectStatus(pipe, redirectPipe, interrupt)
+	return !errorReceived && !interrupted
+}
+
+// WaitAndRedirectStatus is like WaitAndRedirect, but tells apart
+// whether an error was received or an interrupt was sent.
+func WaitAndRedirectStatus(pipe, redirectPipe chan interface{}, interrupt chan os.Signal) (errorReceived, interrupted bool) {
+	return WaitAndRedirectStatusContext(context.Background(), pipe, redirectPipe, interrupt)
+}
+
+// WaitAndRedirectStatusContext is like WaitAndRedirectStatus, but stops
+// redirecting once ctx is done. pipe is still drained until it is closed,
+// so its sender doesn't block, and ctx being done counts as an interrupt.
+func WaitAndRedirectStatusContext(ctx context.Context, pipe, redirectPipe chan interface{}, interrupt chan os.Signal) (errorReceived, interrupted bool) {
 	interruptsReceived := 0
 	if pipe != nil && redirectPipe != nil {
 		for {
@@ -35,14 +84,14 @@ func WaitAndRedirect(pipe, redirectPipe chan interface{}, interrupt chan os.Sign
 					os.Exit(5)
 				} else {
 					// add white space at beginning for ^C splitting
-					redirectPipe <- " Aborting after this migration ... Hit again to force quit."
+					Send(ctx, redirectPipe, " Aborting after this migration ... Hit again to force quit.")
 				}
 
 			case item, ok := <-pipe:
 				if !ok {
-					return !errorReceived && interruptsReceived == 0
+					return errorReceived, interruptsReceived > 0 || ctx.Err() != nil
 				} else {
-					redirectPipe <- item
+					Send(ctx, redirectPipe, item)
 					switch item.(type) {
 					case error:
 						errorReceived = true
@@ -51,7 +100,7 @@ func WaitAndRedirect(pipe, redirectPipe chan interface{}, interrupt chan os.Sign
 			}
 		}
 	}
-	return !errorReceived && interruptsReceived == 0
+	return errorReceived, interruptsReceived > 0
 }
 
 // ReadErrors selects all received errors and returns them.